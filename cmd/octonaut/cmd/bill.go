@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// billCmd represents the bill command
+var billCmd = &cobra.Command{
+	Use:   "bill",
+	Short: "Reconstructs your electricity bills month-by-month using the tariffs you were actually on",
+	Run:   doBill,
+}
+
+var (
+	billFrom   string
+	billTo     string
+	billMPAN   string
+	billFormat string
+	billVAT    string
+
+	billPaymentMethod string
+)
+
+func init() {
+	rootCmd.AddCommand(billCmd)
+
+	billCmd.Flags().StringVar(&billFrom, "from", "", "Date from which to reconstruct bills (YYYY-MM-DD).")
+	billCmd.Flags().StringVar(&billTo, "to", "", "Date to reconstruct bills to, or leave unset to use today (YYYY-MM-DD).")
+	billCmd.Flags().StringVar(&billMPAN, "mpan", "", "Import MPAN to reconstruct bills for, defaults to all import MPANs over the history of the account.")
+	billCmd.Flags().StringVar(&billFormat, "format", "table", "Output format. Valid options: table, csv.")
+	billCmd.Flags().StringVar(&billVAT, "vat", "domestic", "How VAT is applied to prices. Valid options: domestic (5%), business (20%), exclusive (no VAT).")
+	billCmd.Flags().StringVar(&billPaymentMethod, "payment_method", "", "How you paid for the tariffs you were on, which may change their prices. Valid options: direct_debit_monthly, direct_debit_quarterly, varying (on receipt of a bill), prepayment. Defaults to direct debit, or prepayment for prepay products.")
+
+	billCmd.MarkFlagRequired("from")
+}
+
+func doBill(command *cobra.Command, args []string) {
+	ctx := context.Background()
+	o, c := MustNewFromFlags(ctx)
+	defer func() {
+		if err := c(); err != nil {
+			log.Warnf("close: %v", err)
+		}
+	}()
+
+	a, notFound, err := o.Account(ctx)
+	if err != nil {
+		log.Fatalf("Account: %v", err)
+	}
+	if notFound {
+		log.Fatalf("Account %s not found locally, run the sync command first", Account)
+	}
+	from, to := mustParseDates(billFrom, billTo)
+	vm, err := octonaut.ParseVATMode(billVAT)
+	if err != nil {
+		log.Fatalf("Invalid --vat: %v", err)
+	}
+
+	switch billPaymentMethod {
+	case "", octopus.DirectDebitMonthly, octopus.DirectDebitQuarterly, octopus.Varying, octopus.Prepayment:
+	default:
+		log.Fatalf("Invalid --payment_method %q", billPaymentMethod)
+	}
+
+	sources := octonaut.ImportSources(a)
+	if billMPAN != "" {
+		// Properties may have several import MPANs, of which ImportSources only uses the first.
+		sources = octonaut.MPANSources(a, billMPAN)
+	}
+	if len(sources) == 0 {
+		log.Fatalf("Found no matching MPANs")
+	}
+
+	unit, standing, tariffs := agreementRates(ctx, o, a, sources, billPaymentMethod, vm, from, to)
+
+	cons, err := o.StitchedConsumption(ctx, sources, from, to)
+	if err != nil {
+		log.Fatalf("Consumption: %v", err)
+	}
+	// Consumption while no agreement was in force, e.g. between moving out and in, can't be costed.
+	cons, gaps := octonaut.SplitScheduled(cons, unit)
+	for _, g := range gaps {
+		log.Warnf("No agreement with known rates covers %v to %v, leaving %.2f kWh out of the bills", g.Start, g.End, g.Consumption)
+	}
+	if len(cons.Intervals) == 0 {
+		log.Fatalf("None of the consumption between %v and %v is covered by an agreement", from, to)
+	}
+	loc := MustLocation()
+	cost, err := octonaut.TotalCost(ctx, cons, octonaut.Schedule(unit))
+	if err != nil {
+		log.Fatalf("TotalCost: %v", err)
+	}
+	start := octonaut.StartOfDay(cons.Intervals[0].Start, loc)
+	end := octonaut.StartOfDay(cons.Intervals[len(cons.Intervals)-1].End, loc)
+	skipped := 0
+	sc, err := octonaut.StandingCost(ctx, start, end, loc, skipUnscheduled(octonaut.Schedule(standing), &skipped))
+	if err != nil {
+		log.Fatalf("StandingCost: %v", err)
+	}
+	if skipped > 0 {
+		log.Warnf("No agreement with known rates covers the start of %d days, leaving their standing charges out of the bills", skipped)
+	}
+
+	ms, err := monthlyBills(cost, sc, tariffs, loc)
+	if err != nil {
+		log.Fatalf("Failed to break down costs by month: %v", err)
+	}
+	if err := writeBills(os.Stdout, billFormat, ms); err != nil {
+		log.Fatalf("Failed to write bills: %v", err)
+	}
+}
+
+// tariffPeriod records the tariff which was in force for a period of time.
+type tariffPeriod struct {
+	TariffCode string
+	From, To   time.Time
+}
+
+// skipUnscheduled wraps a Schedule so that intervals which none of its periods cover cost nothing, rather
+// than failing, and counts them in skipped.
+func skipUnscheduled(rf octonaut.RateFn, skipped *int) octonaut.RateFn {
+	return func(ctx context.Context, from, to time.Time) (float64, error) {
+		r, err := rf(ctx, from, to)
+		if errors.Is(err, octonaut.ErrNotScheduled) {
+			*skipped++
+			return 0, nil
+		}
+		return r, err
+	}
+}
+
+// agreementRates syncs the unit rates and standing charges for each of the agreements on the sources' MPANs
+// which were in force between from and to, and returns schedules of them with VAT applied according to vm.
+// Tariffs are found in the details of their products for the payment method, or preferring direct debit
+// if it's empty. Agreements whose rates can't be found are left out of the schedules, with a warning, so
+// that they're reported as gaps.
+func agreementRates(ctx context.Context, o *octonaut.Octonaut, a *octopus.Account, sources []octonaut.ConsumptionSource, paymentMethod string, vm octonaut.VATMode, from, to time.Time) ([]octonaut.RatePeriod, []octonaut.RatePeriod, []tariffPeriod) {
+	unit, standing, tariffs := []octonaut.RatePeriod{}, []octonaut.RatePeriod{}, []tariffPeriod{}
+	for _, s := range sources {
+		em := meterPoint(property(a, s.Property), s.MPAN)
+		as := append([]octopus.Agreement{}, em.Agreements...)
+		sort.Slice(as, func(i, j int) bool { return as[i].ValidFrom.Before(as[j].ValidFrom) })
+		for _, ag := range as {
+			pf, pt := latest(from, s.From, ag.ValidFrom), to
+			if !s.To.IsZero() && s.To.Before(pt) {
+				pt = s.To
+			}
+			if ag.ValidTo != nil && ag.ValidTo.Before(pt) {
+				pt = *ag.ValidTo
+			}
+			if !pf.Before(pt) {
+				continue
+			}
+
+			r, sc, err := agreementRate(ctx, o, ag.TariffCode, paymentMethod, vm, pf, pt)
+			if err != nil {
+				log.Warnf("Failed to find the rates of %s for %v to %v: %v", ag.TariffCode, pf, pt, err)
+				continue
+			}
+			unit = append(unit, octonaut.RatePeriod{From: pf, To: pt, Rate: r})
+			standing = append(standing, octonaut.RatePeriod{From: pf, To: pt, Rate: sc})
+			tariffs = append(tariffs, tariffPeriod{TariffCode: ag.TariffCode, From: pf, To: pt})
+		}
+	}
+	return unit, standing, tariffs
+}
+
+// agreementRate syncs the rates of the agreed tariff between from and to, and returns its unit rates and
+// standing charges with VAT applied according to vm.
+func agreementRate(ctx context.Context, o *octonaut.Octonaut, tariffCode, paymentMethod string, vm octonaut.VATMode, from, to time.Time) (octonaut.RateFn, octonaut.RateFn, error) {
+	f, registers, product, region, err := octopus.ParseTariffCode(tariffCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := o.ResolveTariff(ctx, product, f, registers, region, paymentMethod)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ResolveTariff: %v", err)
+	}
+	log.Infof("Syncing %s for %v to %v", t.Code, from, to)
+	if err := o.SyncResolvedTariff(ctx, t, from, to); err != nil {
+		return nil, nil, fmt.Errorf("SyncResolvedTariff: %v", err)
+	}
+	r, err := unitRates(ctx, o, t.Code, vm, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc, err := o.StandingCharges(ctx, t.Code, from, to)
+	if err != nil {
+		return nil, nil, fmt.Errorf("StandingCharges: %v", err)
+	}
+	return r, octonaut.TariffVAT(*sc, vm), nil
+}
+
+func latest(ts ...time.Time) time.Time {
+	r := ts[0]
+	for _, t := range ts[1:] {
+		if t.After(r) {
+			r = t
+		}
+	}
+	return r
+}
+
+// monthlyBill is the reconstruction of a single month's bill, along with the tariffs which were in force.
+// Costs are in pence, including VAT according to --vat.
+type monthlyBill struct {
+	octonaut.BreakdownBucket
+	Tariffs []string
+}
+
+func monthlyBills(cost *octonaut.Cost, standing *octonaut.Standing, tariffs []tariffPeriod, loc *time.Location) ([]monthlyBill, error) {
+	bs, err := octonaut.Breakdown(cost, standing, octonaut.ByMonth, loc)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]monthlyBill, 0, len(bs))
+	for _, b := range bs {
+		m := monthlyBill{BreakdownBucket: b}
+		for _, tp := range tariffs {
+			if tp.From.Before(b.End) && tp.To.After(b.Start) {
+				m.Tariffs = append(m.Tariffs, tp.TariffCode)
+			}
+		}
+		r = append(r, m)
+	}
+	return r, nil
+}
+
+func writeBills(w io.Writer, format string, ms []monthlyBill) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Month\tkWh\tEnergy\tStanding\tTotal\tTariffs")
+		for _, m := range ms {
+			fmt.Fprintf(tw, "%s\t%.2f\t£%.2f\t£%.2f\t£%.2f\t%s\n", m.Label, m.Consumption, m.EnergyCost/100.0, m.StandingCost/100.0, m.TotalCost()/100.0, strings.Join(m.Tariffs, ", "))
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"Month", "ConsumptionKWh", "EnergyCost", "StandingCost", "TotalCost", "Tariffs"}); err != nil {
+			return err
+		}
+		f := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+		for _, m := range ms {
+			if err := cw.Write([]string{m.Label, f(m.Consumption), f(m.EnergyCost), f(m.StandingCost), f(m.TotalCost()), strings.Join(m.Tariffs, " ")}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
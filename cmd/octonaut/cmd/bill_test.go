@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/AlCutter/octonaut/internal/octopus/fake"
+)
+
+func TestAgreementRates(t *testing.T) {
+	ctx := context.Background()
+	from, to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	s := fake.New(fake.DefaultKey)
+	s.Generate(fake.Options{From: from, To: to})
+	// Prepay products only have prices for customers who don't pay by direct debit.
+	const prepayProduct = "PREPAY-VAR-18-09-21"
+	prepay := octopus.BuildTariffCode("E", "1R", prepayProduct, fake.Region)
+	s.AddProduct(octopus.Product{Code: prepayProduct, FullName: "Prepay Flexible", IsVariable: true, IsPrepay: true, Direction: "IMPORT", AvailableFrom: from})
+	s.AddRates(prepay, octopus.StandardUnitRates, []octopus.RateInterval{
+		{ValidFrom: from, ValueExcVat: 20, ValueIncVat: 21, PaymentMethod: octopus.DirectDebit},
+		{ValidFrom: from, ValueExcVat: 25, ValueIncVat: 26.25, PaymentMethod: octopus.NonDirectDebit},
+	})
+	s.AddRates(prepay, octopus.StandingCharges, []octopus.RateInterval{{ValidFrom: from, ValueExcVat: 50, ValueIncVat: 52.5, PaymentMethod: octopus.NonDirectDebit}})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "octonaut.sqlite3"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	o, err := octonaut.New(ctx, fake.DefaultAccount, fake.DefaultKey, srv.URL+"/", db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Agile until the 10th, nothing until the 15th, then Variable, then prepay from the 20th, and finally
+	// a product which the API doesn't know from the 25th.
+	agile := octopus.BuildTariffCode("E", "1R", fake.ImportAgile, fake.Region)
+	variable := octopus.BuildTariffCode("E", "1R", fake.Variable, fake.Region)
+	unknown := octopus.BuildTariffCode("E", "1R", "GONE-20-01-01", fake.Region)
+	movedOut, movedIn := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	toPrepay, toUnknown := time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 25, 0, 0, 0, 0, time.UTC)
+	a := &octopus.Account{Properties: []octopus.Property{{
+		ID: 1,
+		ElectricityMeterPoints: []octopus.ElectricityMeterPoint{{
+			MPAN: fake.ImportMPAN,
+			// Agreements aren't necessarily listed in order.
+			Agreements: []octopus.Agreement{
+				{TariffCode: unknown, ValidFrom: toUnknown},
+				{TariffCode: variable, ValidFrom: movedIn, ValidTo: &toPrepay},
+				{TariffCode: prepay, ValidFrom: toPrepay, ValidTo: &toUnknown},
+				{TariffCode: agile, ValidFrom: from.AddDate(0, -1, 0), ValidTo: &movedOut},
+			},
+		}},
+	}}}
+	sources := []octonaut.ConsumptionSource{{Property: 1, MPAN: fake.ImportMPAN, From: from}}
+
+	// The unknown product's agreement is left out, rather than failing.
+	unit, standing, tariffs := agreementRates(ctx, o, a, sources, "", octonaut.VATExclusive, from, to)
+	wantTariffs := []tariffPeriod{
+		{TariffCode: agile, From: from, To: movedOut},
+		{TariffCode: variable, From: movedIn, To: toPrepay},
+		{TariffCode: prepay, From: toPrepay, To: toUnknown},
+	}
+	if !slices.Equal(tariffs, wantTariffs) {
+		t.Fatalf("got tariffs %+v, want %+v", tariffs, wantTariffs)
+	}
+	if len(unit) != 3 || len(standing) != 3 {
+		t.Fatalf("got %d unit and %d standing periods, want 3 of each", len(unit), len(standing))
+	}
+
+	// Rates come from the tariff in force, without VAT.
+	for _, test := range []struct {
+		at     time.Time
+		code   string
+		rates  []octonaut.RatePeriod
+		lookup func(code string) (*octopus.TariffRate, error)
+	}{
+		{at: from.Add(time.Hour), code: agile, rates: unit, lookup: func(code string) (*octopus.TariffRate, error) {
+			return o.TariffRates(ctx, code, octopus.StandardUnitRates, from, to)
+		}},
+		{at: movedIn.AddDate(0, 0, 1), code: variable, rates: unit, lookup: func(code string) (*octopus.TariffRate, error) {
+			return o.TariffRates(ctx, code, octopus.StandardUnitRates, from, to)
+		}},
+		{at: movedIn.AddDate(0, 0, 1), code: variable, rates: standing, lookup: func(code string) (*octopus.TariffRate, error) {
+			return o.StandingCharges(ctx, code, from, to)
+		}},
+	} {
+		want, err := test.lookup(test.code)
+		if err != nil {
+			t.Fatalf("%s: %v", test.code, err)
+		}
+		w, err := octonaut.TariffVAT(*want, octonaut.VATExclusive)(ctx, test.at, test.at.Add(30*time.Minute))
+		if err != nil {
+			t.Fatalf("%s: %v", test.code, err)
+		}
+		got, err := octonaut.Schedule(test.rates)(ctx, test.at, test.at.Add(30*time.Minute))
+		if err != nil {
+			t.Fatalf("%s at %v: %v", test.code, test.at, err)
+		}
+		if got != w {
+			t.Errorf("%s at %v: got %v, want %v", test.code, test.at, got, w)
+		}
+	}
+
+	// Prepay agreements are priced for customers who don't pay by direct debit.
+	if got, err := octonaut.Schedule(unit)(ctx, toPrepay, toPrepay.Add(30*time.Minute)); err != nil || got != 25 {
+		t.Errorf("prepay: got %v, %v, want 25", got, err)
+	}
+
+	// Nothing is scheduled while there was no agreement, or no rates for it.
+	for _, at := range []time.Time{movedOut, toUnknown} {
+		if _, err := octonaut.Schedule(unit)(ctx, at, at.Add(30*time.Minute)); !errors.Is(err, octonaut.ErrNotScheduled) {
+			t.Errorf("at %v: got err %v, want ErrNotScheduled", at, err)
+		}
+	}
+}
+
+func TestMonthlyBills(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	// Two days either side of the end of January, with a change of tariff at the start of February.
+	from := time.Date(2024, 1, 30, 0, 0, 0, 0, london)
+	feb := time.Date(2024, 2, 1, 0, 0, 0, 0, london)
+	to := time.Date(2024, 2, 3, 0, 0, 0, 0, london)
+	cost := &octonaut.Cost{}
+	for s := from; s.Before(to); s = s.Add(time.Hour) {
+		cost.IntervalCosts = append(cost.IntervalCosts, octonaut.ConsumptionIntervalCost{
+			ConsumptionInterval: octonaut.ConsumptionInterval{Start: s, End: s.Add(time.Hour), Consumption: 1},
+			Rate:                10,
+			Cost:                10,
+		})
+	}
+	standing := &octonaut.Standing{}
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		standing.DailyCosts = append(standing.DailyCosts, octonaut.DailyStandingCharge{Start: d, End: d.AddDate(0, 0, 1), Cost: 50})
+	}
+	tariffs := []tariffPeriod{
+		{TariffCode: "E-1R-AGILE-23-12-06-C", From: from.AddDate(0, -1, 0), To: feb},
+		{TariffCode: "E-1R-VAR-22-11-01-C", From: feb, To: to},
+	}
+
+	ms, err := monthlyBills(cost, standing, tariffs, london)
+	if err != nil {
+		t.Fatalf("monthlyBills: %v", err)
+	}
+	want := []struct {
+		label   string
+		kWh     float64
+		total   float64
+		tariffs []string
+	}{
+		{label: "2024-01", kWh: 48, total: 480 + 100, tariffs: []string{"E-1R-AGILE-23-12-06-C"}},
+		{label: "2024-02", kWh: 48, total: 480 + 100, tariffs: []string{"E-1R-VAR-22-11-01-C"}},
+	}
+	if len(ms) != len(want) {
+		t.Fatalf("got %d months, want %d", len(ms), len(want))
+	}
+	for i, w := range want {
+		m := ms[i]
+		if m.Label != w.label || m.Consumption != w.kWh || m.TotalCost() != w.total || !slices.Equal(m.Tariffs, w.tariffs) {
+			t.Errorf("month %d: got %s %.2f kWh £%.2f %v, want %s %.2f kWh £%.2f %v", i, m.Label, m.Consumption, m.TotalCost()/100, m.Tariffs, w.label, w.kWh, w.total/100, w.tariffs)
+		}
+	}
+}
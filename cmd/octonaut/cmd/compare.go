@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/charmbracelet/log"
+)
+
+// doCompare models the consumption against each of the products requested via the --compare or
+// --compare_all flags, and writes out a table of the results ranked from cheapest to most expensive.
+func doCompare(ctx context.Context, o *octonaut.Octonaut, cons octonaut.Consumption, bm *batteryModel, fuel string, registers []string, area string, from, to time.Time) {
+	products := compareProducts
+	if compareAll {
+		ps, err := o.Products(ctx, nil)
+		if err != nil {
+			log.Fatalf("Products: %v", err)
+		}
+		products = electricityProducts(ps)
+	}
+
+	results := []*tariffResult{}
+	for _, p := range products {
+		for _, reg := range registers {
+			t, err := o.ResolveTariff(ctx, p, fuel, reg, area, "")
+			if nf := (*octopus.NotFoundError)(nil); errors.As(err, &nf) || errors.Is(err, octopus.ErrNotOffered) {
+				log.Infof("Skipping %s: no %s-%s tariff in region %s", p, fuel, reg, area)
+				continue
+			} else if err != nil {
+				log.Warnf("Skipping %s: %v", p, err)
+				continue
+			}
+			log.Infof("Modelling %s", t.Code)
+			r, err := modelTariff(ctx, o, cons, bm, p, t, from, to)
+			if err != nil {
+				log.Warnf("Skipping %s: %v", t.Code, err)
+				continue
+			}
+			results = append(results, r)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].TotalCost < results[j].TotalCost
+	})
+
+	if err := writeComparison(os.Stdout, compareFormat, results); err != nil {
+		log.Fatalf("Failed to write comparison: %v", err)
+	}
+}
+
+// electricityProducts returns the codes of the products which may be used for importing electricity
+// by a domestic customer.
+func electricityProducts(ps octopus.Products) []string {
+	r := []string{}
+	for _, p := range ps.Results {
+		if p.Direction == "EXPORT" || p.IsBusiness {
+			continue
+		}
+		r = append(r, p.Code)
+	}
+	return r
+}
+
+func writeComparison(w io.Writer, format string, rs []*tariffResult) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "Rank\tProduct\tTariff\tkWh\tEnergy\tStanding\tVAT\tTotal\tp/kWh\t")
+		for i, r := range rs {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%.2f\t£%.2f\t£%.2f\t£%.2f\t£%.2f\t%.2f\t\n", i+1, r.Product, r.TariffCode, r.Consumption, r.EnergyCost/100.0, r.StandingCost/100.0, r.VAT/100.0, r.TotalCost/100.0, r.EffectiveRate)
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"Rank", "Product", "TariffCode", "ConsumptionKWh", "Days", "EnergyCost", "StandingCost", "VAT", "TotalCost", "EffectiveRate"}); err != nil {
+			return err
+		}
+		f := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+		for i, r := range rs {
+			if err := cw.Write([]string{strconv.Itoa(i + 1), r.Product, r.TariffCode, f(r.Consumption), strconv.Itoa(r.Days), f(r.EnergyCost), f(r.StandingCost), f(r.VAT), f(r.TotalCost), f(r.EffectiveRate)}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(rs)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
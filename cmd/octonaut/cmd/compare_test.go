@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWriteComparison(t *testing.T) {
+	rs := []*tariffResult{
+		{Product: "GO-VAR-22-10-14", TariffCode: "E-1R-GO-VAR-22-10-14-C", Consumption: 100, Days: 10, EnergyCost: 1500, StandingCost: 478, TotalCost: 1978, VAT: 94.19, EffectiveRate: 19.78},
+		{Product: "VAR-22-11-01", TariffCode: "E-1R-VAR-22-11-01-C", Consumption: 100, Days: 10, EnergyCost: 2450, StandingCost: 534, TotalCost: 2984, VAT: 142.1, EffectiveRate: 29.84},
+	}
+
+	t.Run("table", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		if err := writeComparison(b, "table", rs); err != nil {
+			t.Fatalf("writeComparison: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+		if len(lines) != 1+len(rs) {
+			t.Fatalf("got %q, want a header and a line per result", lines)
+		}
+		want := [][]string{
+			{"1", "GO-VAR-22-10-14", "E-1R-GO-VAR-22-10-14-C", "100.00", "£15.00", "£4.78", "£0.94", "£19.78", "19.78"},
+			{"2", "VAR-22-11-01", "E-1R-VAR-22-11-01-C", "100.00", "£24.50", "£5.34", "£1.42", "£29.84", "29.84"},
+		}
+		for i, w := range want {
+			if got := strings.Fields(lines[i+1]); !slices.Equal(got, w) {
+				t.Errorf("line %d: got %q, want %q", i+1, got, w)
+			}
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		if err := writeComparison(b, "csv", rs); err != nil {
+			t.Fatalf("writeComparison: %v", err)
+		}
+		rows, err := csv.NewReader(b).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		want := [][]string{
+			{"Rank", "Product", "TariffCode", "ConsumptionKWh", "Days", "EnergyCost", "StandingCost", "VAT", "TotalCost", "EffectiveRate"},
+			{"1", "GO-VAR-22-10-14", "E-1R-GO-VAR-22-10-14-C", "100.0000", "10", "1500.0000", "478.0000", "94.1900", "1978.0000", "19.7800"},
+			{"2", "VAR-22-11-01", "E-1R-VAR-22-11-01-C", "100.0000", "10", "2450.0000", "534.0000", "142.1000", "2984.0000", "29.8400"},
+		}
+		if len(rows) != len(want) {
+			t.Fatalf("got %q, want %q", rows, want)
+		}
+		for i := range want {
+			if !slices.Equal(rows[i], want[i]) {
+				t.Errorf("row %d: got %q, want %q", i, rows[i], want[i])
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		if err := writeComparison(b, "json", rs); err != nil {
+			t.Fatalf("writeComparison: %v", err)
+		}
+		var got []tariffResult
+		if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", b, err)
+		}
+		if len(got) != len(rs) {
+			t.Fatalf("got %+v, want %d results", got, len(rs))
+		}
+		for i := range rs {
+			if got[i] != *rs[i] {
+				t.Errorf("result %d: got %+v, want %+v", i, got[i], *rs[i])
+			}
+		}
+	})
+
+	if err := writeComparison(&bytes.Buffer{}, "xml", rs); err == nil {
+		t.Error("writeComparison(xml): got no error, want error for unknown format")
+	}
+}
+
+func TestCompareRejectsWriteCSV(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "model.csv")
+	for _, compare := range []string{"--compare=AGILE-23-12-06", "--compare_all"} {
+		if _, err := tryExecute(t, "model", "--from=2024-01-01", compare, "--write_csv="+fn); err == nil {
+			t.Errorf("%s --write_csv: got no error, want the flags to be rejected", compare)
+		}
+	}
+}
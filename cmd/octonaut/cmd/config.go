@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Shows the effective settings, and where each of them came from",
+	Run:   doConfig,
+}
+
+// envPrefix is prepended to the upper-cased name of a root flag to give the environment variable which sets it.
+const envPrefix = "OCTONAUT_"
+
+var (
+	ConfigPath string
+	KeyFile    string
+	KeyCommand string
+
+	// settingSources records where the value of each root flag came from.
+	settingSources = map[string]string{}
+)
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+
+	rootCmd.PersistentFlags().StringVar(&ConfigPath, "config", "", "Config file to read settings from, defaults to octonaut/config in your user config directory, e.g. ~/.config. It holds a name = value line for each setting.")
+	rootCmd.PersistentFlags().StringVar(&KeyFile, "key_file", "", "File containing the Octopus API key, used if --key isn't set.")
+	rootCmd.PersistentFlags().StringVar(&KeyCommand, "key_command", "", "Command which prints the Octopus API key, e.g. to read it from your OS keyring, used if neither --key nor --key_file are set.")
+	rootCmd.PersistentPreRunE = func(command *cobra.Command, args []string) error {
+		return loadSettings(rootCmd.PersistentFlags())
+	}
+}
+
+// loadSettings fills in any root flags which weren't set on the command line, taking their values from,
+// in order of precedence:
+//   - OCTONAUT_<FLAG> environment variables, e.g. OCTONAUT_ACCOUNT or OCTONAUT_HTTP_TIMEOUT.
+//   - The config file.
+//   - The flag's default.
+//
+// If no API key is set by any of those, it's read from --key_file or the output of --key_command. A key in
+// the config file is ignored if either of those was set by a flag or environment variable, since they're
+// more specific to this run.
+func loadSettings(fs *pflag.FlagSet) error {
+	for k := range settingSources {
+		delete(settingSources, k)
+	}
+	fs.VisitAll(func(f *pflag.Flag) {
+		settingSources[f.Name] = "default"
+		if f.Changed {
+			settingSources[f.Name] = "flag"
+		}
+	})
+
+	set := func(name, value, source string) error {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid %s from %s: %v", name, source, err)
+		}
+		settingSources[name] = source
+		return nil
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && !f.Changed {
+			errs = append(errs, set(f.Name, v, envName(f.Name)))
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	path, explicit := ConfigPath, ConfigPath != ""
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			log.Debugf("No user config directory: %v", err)
+		} else {
+			path = filepath.Join(dir, "octonaut", "config")
+		}
+	}
+	if path != "" {
+		settings, err := readConfig(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !explicit:
+			settingSources["config"] = "default, not found"
+		case err != nil:
+			return fmt.Errorf("failed to read config file: %v", err)
+		}
+		ConfigPath = path
+		keyLookup := settingSources["key_file"] != "default" || settingSources["key_command"] != "default"
+		for _, s := range settings {
+			f := fs.Lookup(s.name)
+			if f == nil || f.Name == "config" {
+				return fmt.Errorf("%s:%d: unknown setting %q", path, s.line, s.name)
+			}
+			if settingSources[f.Name] != "default" || (f.Name == "key" && keyLookup) {
+				continue
+			}
+			if err := set(f.Name, s.value, path); err != nil {
+				return err
+			}
+			if f.Name == "key" {
+				warnIfReadable(path)
+			}
+		}
+	}
+
+	if Key != "" {
+		return nil
+	}
+	switch {
+	case KeyFile != "":
+		warnIfReadable(KeyFile)
+		b, err := os.ReadFile(KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read key file: %v", err)
+		}
+		Key = strings.TrimSpace(string(b))
+		settingSources["key"] = "key_file " + KeyFile
+	case KeyCommand != "":
+		c := exec.Command("sh", "-c", KeyCommand)
+		if runtime.GOOS == "windows" {
+			c = exec.Command("cmd", "/C", KeyCommand)
+		}
+		c.Stderr = os.Stderr
+		b, err := c.Output()
+		if err != nil {
+			return fmt.Errorf("key command %q failed: %v", KeyCommand, err)
+		}
+		Key = strings.TrimSpace(string(b))
+		settingSources["key"] = "key_command"
+	}
+	return nil
+}
+
+// envName returns the environment variable which sets the named flag.
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// setting is a single name = value line from a config file.
+type setting struct {
+	line        int
+	name, value string
+}
+
+// readConfig reads settings from a config file. The file contains lines of the form
+//
+//	name = "value"
+//
+// where name is the name of a root flag, e.g. account or http_timeout. The value may optionally be quoted,
+// with Go escapes in double quotes and none in single quotes. Blank lines and those starting with # are
+// ignored. This resembles a subset of TOML, but isn't parsed as it: there are no tables, arrays, or
+// trailing comments.
+func readConfig(path string) ([]setting, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseConfig(f, path)
+}
+
+// parseConfig parses the settings in a config file, path is only used in errors.
+func parseConfig(in io.Reader, path string) ([]setting, error) {
+	r := []setting{}
+	s := bufio.NewScanner(in)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want name = value, got %q", path, n, l)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, `"`):
+			v, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid quoted value %s: %v", path, n, value, err)
+			}
+			value = v
+		case strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") && len(value) > 1:
+			value = value[1 : len(value)-1]
+		}
+		r = append(r, setting{line: n, name: name, value: value})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// warnIfReadable warns if the file holding an API key can be read by other users.
+func warnIfReadable(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || runtime.GOOS == "windows" {
+		return
+	}
+	if fi.Mode().Perm()&0o077 != 0 {
+		log.Warnf("%s contains your API key but can be read by other users, consider running: chmod 600 %s", path, path)
+	}
+}
+
+// redact hides all but the prefix and last few characters of an API key.
+func redact(key string) string {
+	if key == "" {
+		return ""
+	}
+	prefix := ""
+	if i := strings.LastIndex(key, "_"); i >= 0 && i < len(key)-1 {
+		prefix, key = key[:i+1], key[i+1:]
+	}
+	if len(key) <= 8 {
+		return prefix + "****"
+	}
+	return prefix + "****" + key[len(key)-4:]
+}
+
+func doConfig(command *cobra.Command, args []string) {
+	if err := writeSettings(os.Stdout, rootCmd.PersistentFlags()); err != nil {
+		log.Fatalf("Failed to write settings: %v", err)
+	}
+}
+
+// writeSettings writes the effective value of each root flag, and where it came from, with the API key redacted.
+func writeSettings(w io.Writer, fs *pflag.FlagSet) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	fs.VisitAll(func(f *pflag.Flag) {
+		v := f.Value.String()
+		if f.Name == "key" {
+			v = redact(Key)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, v, settingSources[f.Name])
+	})
+	return tw.Flush()
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// settings runs the config command with the given arguments, and returns the value and source of each setting.
+func settings(t *testing.T, args ...string) map[string][2]string {
+	t.Helper()
+	out := execute(t, append(args, "config")...)
+	r := map[string][2]string{}
+	for _, l := range strings.Split(strings.TrimSpace(string(out)), "\n")[1:] {
+		name, rest, _ := strings.Cut(l, " ")
+		rest = strings.TrimSpace(rest)
+		// Values may be empty, but sources aren't.
+		i := strings.Index(rest, "  ")
+		if i < 0 {
+			r[name] = [2]string{"", rest}
+			continue
+		}
+		r[name] = [2]string{rest[:i], strings.TrimSpace(rest[i:])}
+	}
+	return r
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "config")
+	if err := os.WriteFile(cfg, []byte(`
+# Settings for octonaut.
+account = "A-CONFIG"
+key = 'sk_live_fromconfigfile1234'
+timezone = Europe/Paris
+db = "config.sqlite3"
+`), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	t.Setenv("OCTONAUT_TIMEZONE", "UTC")
+	t.Setenv("OCTONAUT_CONFIG", cfg)
+
+	got := settings(t, "--db=flag.sqlite3")
+	for name, want := range map[string][2]string{
+		"account":  {"A-CONFIG", cfg},
+		"key":      {"sk_live_****1234", cfg},
+		"timezone": {"UTC", "OCTONAUT_TIMEZONE"},
+		"db":       {"flag.sqlite3", "flag"},
+		"endpoint": {"https://api.octopus.energy/", "default"},
+		"config":   {cfg, "OCTONAUT_CONFIG"},
+	} {
+		if got[name] != want {
+			t.Errorf("%s: got %q, want %q", name, got[name], want)
+		}
+	}
+}
+
+func TestSettingsKeyLookup(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("sk_live_fromkeyfile5678\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg := filepath.Join(dir, "config")
+	if err := os.WriteFile(cfg, []byte("key = sk_live_fromconfigfile1234\nkey_command = echo sk_live_fromconfigcmd4321\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	for _, test := range []struct {
+		name string
+		args []string
+		env  map[string]string
+		want [2]string
+	}{
+		{
+			name: "key file",
+			args: []string{"--key_file=" + keyFile, "--key_command=echo sk_live_fromcommand0000"},
+			want: [2]string{"sk_live_****5678", "key_file " + keyFile},
+		}, {
+			name: "key command",
+			args: []string{"--key_command=echo sk_live_fromcommand0000"},
+			want: [2]string{"sk_live_****0000", "key_command"},
+		}, {
+			name: "key takes precedence",
+			args: []string{"--key=sk_live_fromflag9999", "--key_file=" + keyFile},
+			want: [2]string{"sk_live_****9999", "flag"},
+		}, {
+			name: "config file key",
+			args: []string{"--config=" + cfg},
+			want: [2]string{"sk_live_****1234", cfg},
+		}, {
+			name: "key file flag beats config file key",
+			args: []string{"--config=" + cfg, "--key_file=" + keyFile},
+			want: [2]string{"sk_live_****5678", "key_file " + keyFile},
+		}, {
+			name: "key command environment variable beats config file key",
+			args: []string{"--config=" + cfg},
+			env:  map[string]string{"OCTONAUT_KEY_COMMAND": "echo sk_live_fromenvcmd0000"},
+			want: [2]string{"sk_live_****0000", "key_command"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for k, v := range test.env {
+				t.Setenv(k, v)
+			}
+			if got := settings(t, test.args...)["key"]; got != test.want {
+				t.Errorf("got key %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		config  string
+		want    []setting
+		wantErr bool
+	}{
+		{
+			name:   "values",
+			config: "# comment\n\naccount=A-1\n  key = \"sk_live_x=y\"  \ntimezone = 'UTC'\n",
+			want:   []setting{{3, "account", "A-1"}, {4, "key", "sk_live_x=y"}, {5, "timezone", "UTC"}},
+		}, {
+			name:    "no value",
+			config:  "account\n",
+			wantErr: true,
+		}, {
+			name:    "bad quoting",
+			config:  "account = \"A-1\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseConfig(strings.NewReader(test.config), "config")
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("parseConfig: got err %v, want err %t", err, test.wantErr)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %+v, want %+v", got, test.want)
+			}
+			for i := range test.want {
+				if got[i] != test.want[i] {
+					t.Errorf("setting %d: got %+v, want %+v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for key, want := range map[string]string{
+		"":                          "",
+		"sk_live_abcdefghijklmnop":  "sk_live_****mnop",
+		"sk_live_short":             "sk_live_****",
+		"nounderscoresbutquitelong": "****long",
+		"sk_live_":                  "****",
+	} {
+		if got := redact(key); got != want {
+			t.Errorf("redact(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
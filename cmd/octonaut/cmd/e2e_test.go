@@ -0,0 +1,839 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/AlCutter/octonaut/internal/octopus/fake"
+	"github.com/parquet-go/parquet-go"
+	"github.com/spf13/pflag"
+)
+
+// run executes octonaut with the given arguments against the fake API, and returns what it wrote to stdout.
+func run(t *testing.T, srv *httptest.Server, db string, args ...string) []byte {
+	t.Helper()
+	return execute(t, append([]string{"--endpoint=" + srv.URL, "--account=" + fake.DefaultAccount, "--key=" + fake.DefaultKey, "--db=" + db, "--retries=0"}, args...)...)
+}
+
+// execute runs octonaut with the given arguments, and returns what it wrote to stdout.
+func execute(t *testing.T, args ...string) []byte {
+	t.Helper()
+	b, err := tryExecute(t, args...)
+	if err != nil {
+		t.Fatalf("Execute(%v): %v", args, err)
+	}
+	return b
+}
+
+// tryExecute runs octonaut with the given arguments, and returns what it wrote to stdout, or the error
+// returned if the arguments were rejected.
+func tryExecute(t *testing.T, args ...string) ([]byte, error) {
+	t.Helper()
+	// Don't pick up settings from the user's own config file.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	// Flag values persist between executions, so reset them all to their defaults first.
+	reset := func(fs *pflag.FlagSet) {
+		fs.VisitAll(func(f *pflag.Flag) {
+			if sv, ok := f.Value.(pflag.SliceValue); ok {
+				sv.Replace(nil)
+			} else {
+				f.Value.Set(f.DefValue)
+			}
+			f.Changed = false
+		})
+	}
+	reset(rootCmd.PersistentFlags())
+	for _, c := range rootCmd.Commands() {
+		reset(c.Flags())
+	}
+
+	out, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer out.Close()
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+
+	rootCmd.SetArgs(args)
+	if err := rootCmd.Execute(); err != nil {
+		return nil, err
+	}
+
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	b, err := io.ReadAll(out)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return b, nil
+}
+
+func TestSyncAndModel(t *testing.T) {
+	s := fake.New(fake.DefaultKey)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Generate(fake.Options{From: from, To: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)})
+	// Agile is also offered in Eastern England, which isn't where the property is.
+	eastern := octopus.BuildTariffCode("E", "1R", fake.ImportAgile, "A")
+	s.AddRates(eastern, octopus.StandardUnitRates, []octopus.RateInterval{{ValidFrom: from, ValueExcVat: 10, ValueIncVat: 10.5}})
+	s.AddRates(eastern, "standing-charges", []octopus.RateInterval{{ValidFrom: from, ValueExcVat: 40, ValueIncVat: 42}})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	db := filepath.Join(t.TempDir(), "octonaut.sqlite3")
+
+	run(t, srv, db, "sync")
+
+	t.Run("compare", func(t *testing.T) {
+		out := run(t, srv, db, "model", "--from=2024-01-01", "--to=2024-01-31", "--compare_all", "--format=json")
+		var rs []tariffResult
+		if err := json.Unmarshal(out, &rs); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", out, err)
+		}
+		// Export and business products aren't compared.
+		got := []string{}
+		for _, r := range rs {
+			got = append(got, r.Product)
+			if r.Days != 30 || r.Consumption <= 0 || r.TotalCost != r.EnergyCost+r.StandingCost {
+				t.Errorf("%s: got %d days, %.2f kWh, total %.2f, energy %.2f, standing %.2f", r.Product, r.Days, r.Consumption, r.TotalCost, r.EnergyCost, r.StandingCost)
+			}
+		}
+		sort.Strings(got)
+		want := []string{fake.ImportAgile, fake.Fixed, fake.Go, fake.Tracker, fake.Variable}
+		sort.Strings(want)
+		if len(got) != len(want) {
+			t.Fatalf("got products %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("got products %v, want %v", got, want)
+			}
+		}
+		if !sort.SliceIsSorted(rs, func(i, j int) bool { return rs[i].TotalCost < rs[j].TotalCost }) {
+			t.Errorf("results aren't ranked by total cost: %+v", rs)
+		}
+	})
+
+	t.Run("compare_battery_window", func(t *testing.T) {
+		// What optimal dispatch would have cost is only worked out for a single tariff.
+		out := run(t, srv, db, "model", "--from=2024-01-01", "--to=2024-01-02", "--compare="+fake.ImportAgile+","+fake.Go, "--battery_capacity=10", "--battery_rate=5", "--battery_charge=0-4", "--format=json")
+		var rs []tariffResult
+		if err := json.Unmarshal(out, &rs); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", out, err)
+		}
+		if len(rs) != 2 {
+			t.Fatalf("got %d results, want 2", len(rs))
+		}
+		for _, r := range rs {
+			if r.OptimalEnergyCost != nil {
+				t.Errorf("%s: got optimal energy cost %.2f, want none", r.TariffCode, *r.OptimalEnergyCost)
+			}
+		}
+	})
+
+	t.Run("write_csv", func(t *testing.T) {
+		fn := filepath.Join(t.TempDir(), "model.csv")
+		// Rerunning into an existing, larger, file should replace it entirely.
+		if err := os.WriteFile(fn, bytes.Repeat([]byte("garbage\n"), 10000), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		run(t, srv, db, "model", "--from=2024-01-01", "--to=2024-01-02", "--tariff="+fake.ImportAgile, "--write_csv="+fn, "--csv_units=pounds", "--csv_precision=2")
+		b, err := os.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		rows, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", b, err)
+		}
+		if got, want := len(rows), 1+48; got != want {
+			t.Fatalf("got %d rows, want %d", got, want)
+		}
+		if got, want := rows[1][0], "2024-01-01T00:00:00Z"; got != want {
+			t.Errorf("got first start %q, want %q", got, want)
+		}
+	})
+
+	t.Run("region", func(t *testing.T) {
+		for _, test := range []struct {
+			args []string
+			want string
+		}{
+			{want: octopus.BuildTariffCode("E", "1R", fake.ImportAgile, fake.Region)},
+			{args: []string{"--region=a"}, want: eastern},
+		} {
+			out := run(t, srv, db, append(test.args, "model", "--from=2024-01-01", "--to=2024-01-02", "--compare="+fake.ImportAgile, "--format=json")...)
+			var rs []tariffResult
+			if err := json.Unmarshal(out, &rs); err != nil {
+				t.Fatalf("Unmarshal(%q): %v", out, err)
+			}
+			if len(rs) != 1 || rs[0].TariffCode != test.want {
+				t.Errorf("%v: got %+v, want a result for %s", test.args, rs, test.want)
+			}
+		}
+	})
+
+	t.Run("registers", func(t *testing.T) {
+		// Only Flexible Octopus offers a dual register tariff, so Go's is skipped.
+		out := run(t, srv, db, "model", "--from=2024-01-01", "--to=2024-01-02", "--compare="+fake.Variable+","+fake.Go, "--registers=1R,2R", "--format=json")
+		var rs []tariffResult
+		if err := json.Unmarshal(out, &rs); err != nil {
+			t.Fatalf("Unmarshal(%q): %v", out, err)
+		}
+		got := []string{}
+		for _, r := range rs {
+			got = append(got, r.TariffCode)
+			if r.Consumption != rs[0].Consumption || r.EnergyCost <= 0 {
+				t.Errorf("%s: got %.2f kWh costing %.2f, want the same consumption as %s", r.TariffCode, r.Consumption, r.EnergyCost, rs[0].TariffCode)
+			}
+		}
+		slices.Sort(got)
+		want := []string{
+			octopus.BuildTariffCode("E", "1R", fake.Go, fake.Region),
+			octopus.BuildTariffCode("E", "1R", fake.Variable, fake.Region),
+			octopus.BuildTariffCode("E", "2R", fake.Variable, fake.Region),
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("got results for %q, want %q", got, want)
+		}
+	})
+
+	t.Run("sync_tariff", func(t *testing.T) {
+		run(t, srv, db, "sync", "--tariff="+fake.Go)
+		sdb, err := sql.Open("sqlite3", db)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer sdb.Close()
+		o, err := octonaut.New(context.Background(), fake.DefaultAccount, fake.DefaultKey, srv.URL+"/", sdb)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		rs, err := o.TariffRates(context.Background(), octopus.BuildTariffCode("E", "1R", fake.Go, fake.Region), octopus.StandardUnitRates, from, from.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatalf("TariffRates: %v", err)
+		}
+		if len(rs.Results) == 0 {
+			t.Errorf("got no rates for %s in region %s", fake.Go, fake.Region)
+		}
+
+		// The tariff is found from the product's details for the region requested.
+		run(t, srv, db, "sync", "--tariff="+fake.ImportAgile, "--region=A")
+		rs, err = o.TariffRates(context.Background(), eastern, octopus.StandardUnitRates, from, from.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatalf("TariffRates(%s): %v", eastern, err)
+		}
+		if got, want := rs.Results[0].ValueIncVat, 10.5; len(rs.Results) != 1 || got != want {
+			t.Errorf("got rates %+v for %s, want one of %v", rs.Results, eastern, want)
+		}
+	})
+
+	t.Run("breakdown", func(t *testing.T) {
+		out := run(t, srv, db, "model", "--from=2024-01-01", "--to=2024-01-31", "--tariff="+fake.ImportAgile, "--breakdown=weekday", "--format=csv")
+		rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", out, err)
+		}
+		if got, want := len(rows), 8; got != want {
+			t.Errorf("got %d rows, want a header and one per day of the week", got)
+		}
+	})
+
+	t.Run("gas", func(t *testing.T) {
+		sdb, err := sql.Open("sqlite3", db)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer sdb.Close()
+		o, err := octonaut.New(context.Background(), fake.DefaultAccount, fake.DefaultKey, srv.URL+"/", sdb)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		from, to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+		raw, err := o.GasConsumption(context.Background(), fake.GasMPRN, fake.GasMeter, from, to)
+		if err != nil {
+			t.Fatalf("GasConsumption: %v", err)
+		}
+		var readings float64
+		for _, c := range raw.Intervals {
+			readings += c.Consumption
+		}
+		consumption := func() float64 {
+			out := run(t, srv, db, "model", "--from=2024-01-01", "--to=2024-01-31", "--gas_tariff="+fake.Variable, "--breakdown=month", "--format=csv")
+			rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll(%q): %v", out, err)
+			}
+			var kWh float64
+			for _, r := range rows[1:] {
+				v, err := strconv.ParseFloat(r[1], 64)
+				if err != nil {
+					t.Fatalf("ParseFloat(%q): %v", r[1], err)
+				}
+				kWh += v
+			}
+			return kWh
+		}
+
+		// The fake meter's readings are small enough to be volumes, so they're converted to kWh.
+		if got, want := consumption(), readings*1.02264*39.5/3.6; math.Abs(got-want) > 0.01 {
+			t.Errorf("got %.4f kWh, want %.4f kWh converted from %.4f m³", got, want, readings)
+		}
+		run(t, srv, db, "sync", "--gas_units=kWh")
+		if got, want := consumption(), readings; math.Abs(got-want) > 0.01 {
+			t.Errorf("with --gas_units=kWh got %.4f kWh, want the readings %.4f kWh", got, want)
+		}
+		run(t, srv, db, "sync", "--gas_units=m3")
+	})
+
+	t.Run("export_tariff", func(t *testing.T) {
+		sdb, err := sql.Open("sqlite3", db)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer sdb.Close()
+		o, err := octonaut.New(context.Background(), fake.DefaultAccount, fake.DefaultKey, srv.URL+"/", sdb)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		exp, err := o.ExportConsumption(context.Background(), fake.ExportMPAN, fake.ExportMeter, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatalf("ExportConsumption: %v", err)
+		}
+		var exported float64
+		for _, c := range exp.Intervals {
+			exported += c.Consumption
+		}
+
+		f := func(s string) float64 {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				t.Fatalf("ParseFloat(%q): %v", s, err)
+			}
+			return v
+		}
+		for _, vat := range []string{"domestic", "business", "exclusive"} {
+			// Only the export is modelled, so the breakdown is of the export MPAN's consumption and income.
+			out := run(t, srv, db, "model", "--from=2024-01-01", "--to=2024-01-31", "--export_tariff="+fake.ExportAgile, "--vat="+vat, "--breakdown=month", "--format=csv")
+			rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll(%q): %v", out, err)
+			}
+			if len(rows) != 2 {
+				t.Fatalf("got %q, want a header and one month", rows)
+			}
+			if got := f(rows[1][1]); exported <= 0 || math.Abs(got-exported) > 0.01 {
+				t.Errorf("--vat=%s: got %.4f kWh, want the %.4f kWh exported", vat, got, exported)
+			}
+			// Export income has no VAT, so it's priced using the VAT exclusive rates which have now been synced.
+			rates, err := o.TariffRates(context.Background(), octopus.BuildTariffCode("E", "1R", fake.ExportAgile, fake.Region), octopus.StandardUnitRates, exp.Intervals[0].Start, exp.Intervals[len(exp.Intervals)-1].End)
+			if err != nil {
+				t.Fatalf("TariffRates: %v", err)
+			}
+			income, err := octonaut.TotalCost(context.Background(), exp, octonaut.TariffVAT(*rates, octonaut.VATExclusive))
+			if err != nil {
+				t.Fatalf("TotalCost: %v", err)
+			}
+			if got, want := f(rows[1][2]), income.TotalCost; want <= 0 || math.Abs(got-want) > 0.01 {
+				t.Errorf("--vat=%s: got export income %.4f, want %.4f without VAT", vat, got, want)
+			}
+		}
+	})
+
+	t.Run("offline", func(t *testing.T) {
+		args := []string{"model", "--from=2024-01-01", "--to=2024-01-31", "--compare_all", "--format=json"}
+		online := run(t, srv, db, args...)
+		// Everything needed was cached when modelling online, so the API isn't needed.
+		srv.Close()
+		offline := run(t, srv, db, append([]string{"--offline"}, args...)...)
+		if !bytes.Equal(offline, online) {
+			t.Errorf("got offline comparison %s, want %s", offline, online)
+		}
+	})
+}
+
+func TestModelExportWithoutAgreement(t *testing.T) {
+	s := fake.New(fake.DefaultKey)
+	a := s.Generate(fake.Options{From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)})
+	// The property imports on Economy 7, and its export MPAN has no agreements, so the export tariff is
+	// found using the import agreement's region, but not its register type.
+	ems := a.Properties[0].ElectricityMeterPoints
+	ems[0].Agreements[0].TariffCode = octopus.BuildTariffCode("E", "2R", fake.Variable, fake.Region)
+	ems[1].Agreements = nil
+	s.AddAccount(a)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	db := filepath.Join(t.TempDir(), "octonaut.sqlite3")
+
+	run(t, srv, db, "sync")
+	out := run(t, srv, db, "model", "--from=2024-01-01", "--to=2024-01-31", "--export_tariff="+fake.ExportAgile, "--breakdown=month", "--format=csv")
+	rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll(%q): %v", out, err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %q, want a header and one month", rows)
+	}
+	if income, err := strconv.ParseFloat(rows[1][2], 64); err != nil || income <= 0 {
+		t.Errorf("got export income %q, want > 0", rows[1][2])
+	}
+}
+
+func TestModelExportMeters(t *testing.T) {
+	s := fake.New(fake.DefaultKey)
+	from, to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	a := s.Generate(fake.Options{From: from, To: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)})
+	// The export meter was exchanged, and the new one reports 5 kWh every half-hour on the first day.
+	const second = "21E0000003"
+	em := &a.Properties[0].ElectricityMeterPoints[1]
+	em.Meters = append(em.Meters, octopus.Meter{SerialNumber: second})
+	s.AddAccount(a)
+	readings := []octopus.ConsumptionReading{}
+	for i := from; i.Before(from.AddDate(0, 0, 1)); i = i.Add(30 * time.Minute) {
+		readings = append(readings, octopus.ConsumptionReading{Consumption: 5, IntervalStart: i, IntervalEnd: i.Add(30 * time.Minute)})
+	}
+	s.AddConsumption("electricity", fake.ExportMPAN, second, readings)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	db := filepath.Join(t.TempDir(), "octonaut.sqlite3")
+	run(t, srv, db, "sync")
+
+	exported := func(args ...string) float64 {
+		out := run(t, srv, db, append([]string{"model", "--from=2024-01-01", "--to=2024-01-31", "--export_tariff=" + fake.ExportAgile, "--breakdown=month", "--format=csv"}, args...)...)
+		rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", out, err)
+		}
+		v, err := strconv.ParseFloat(rows[1][1], 64)
+		if err != nil {
+			t.Fatalf("ParseFloat(%q): %v", rows[1][1], err)
+		}
+		return v
+	}
+
+	if got, want := exported("--meter="+second), 48*5.0; math.Abs(got-want) > 0.01 {
+		t.Errorf("--meter=%s: got %.4f kWh, want %.4f kWh", second, got, want)
+	}
+
+	// By default both meters are used, with the larger reading being used for each interval on the first day.
+	sdb, err := sql.Open("sqlite3", db)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sdb.Close()
+	o, err := octonaut.New(context.Background(), fake.DefaultAccount, fake.DefaultKey, srv.URL+"/", sdb)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	old, err := o.ExportConsumption(context.Background(), fake.ExportMPAN, fake.ExportMeter, from.AddDate(0, 0, 1), to)
+	if err != nil {
+		t.Fatalf("ExportConsumption: %v", err)
+	}
+	want := 48 * 5.0
+	for _, c := range old.Intervals {
+		want += c.Consumption
+	}
+	if got := exported(); math.Abs(got-want) > 0.01 {
+		t.Errorf("got %.4f kWh, want %.4f kWh", got, want)
+	}
+}
+
+func TestModelGasMeters(t *testing.T) {
+	s := fake.New(fake.DefaultKey)
+	from, to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	a := s.Generate(fake.Options{From: from, To: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)})
+	// A second meter on the MPRN reports 5 kWh every half-hour on the first day.
+	const second = "G4A0000002"
+	gm := &a.Properties[0].GasMeterPoints[0]
+	gm.Meters = append(gm.Meters, octopus.Meter{SerialNumber: second})
+	s.AddAccount(a)
+	readings := []octopus.ConsumptionReading{}
+	for i := from; i.Before(from.AddDate(0, 0, 1)); i = i.Add(30 * time.Minute) {
+		readings = append(readings, octopus.ConsumptionReading{Consumption: 5, IntervalStart: i, IntervalEnd: i.Add(30 * time.Minute)})
+	}
+	s.AddConsumption("gas", fake.GasMPRN, second, readings)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	db := filepath.Join(t.TempDir(), "octonaut.sqlite3")
+	run(t, srv, db, "sync")
+
+	consumption := func(args ...string) float64 {
+		out := run(t, srv, db, append([]string{"model", "--from=2024-01-01", "--to=2024-01-31", "--gas_tariff=" + fake.Variable, "--breakdown=month", "--format=csv"}, args...)...)
+		rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", out, err)
+		}
+		v, err := strconv.ParseFloat(rows[1][1], 64)
+		if err != nil {
+			t.Fatalf("ParseFloat(%q): %v", rows[1][1], err)
+		}
+		return v
+	}
+
+	// The second meter's readings are too large to be volumes, so they're used as kWh.
+	if got, want := consumption("--meter="+second), 48*5.0; math.Abs(got-want) > 0.01 {
+		t.Errorf("--meter=%s: got %.4f kWh, want %.4f kWh", second, got, want)
+	}
+
+	// By default both meters are used, each converted according to its own units, with the larger
+	// reading being used for each interval on the first day.
+	sdb, err := sql.Open("sqlite3", db)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sdb.Close()
+	o, err := octonaut.New(context.Background(), fake.DefaultAccount, fake.DefaultKey, srv.URL+"/", sdb)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	raw, err := o.GasConsumption(context.Background(), fake.GasMPRN, fake.GasMeter, from.AddDate(0, 0, 1), to)
+	if err != nil {
+		t.Fatalf("GasConsumption: %v", err)
+	}
+	want := 48 * 5.0
+	for _, c := range raw.Intervals {
+		want += c.Consumption * 1.02264 * 39.5 / 3.6
+	}
+	if got := consumption(); math.Abs(got-want) > 0.01 {
+		t.Errorf("got %.4f kWh, want %.4f kWh", got, want)
+	}
+}
+
+func TestImport(t *testing.T) {
+	s := fake.New(fake.DefaultKey)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := s.Generate(fake.Options{From: from, To: from.AddDate(0, 0, 7)})
+	// The property has a second import MPAN, with no consumption available from the API.
+	const secondMPAN, secondMeter = "1200000000003", "21E0000003"
+	p := &a.Properties[0]
+	em := p.ElectricityMeterPoints[0]
+	em.MPAN, em.Meters = secondMPAN, []octopus.Meter{{SerialNumber: secondMeter}}
+	p.ElectricityMeterPoints = append(p.ElectricityMeterPoints, em)
+	s.AddAccount(a)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	dir := t.TempDir()
+	db := filepath.Join(dir, "octonaut.sqlite3")
+
+	run(t, srv, db, "sync")
+
+	// Hourly readings from before the account was synced, and overlapping with the synced data.
+	csv := "Timestamp,kWh\n"
+	for h := -24; h < 24; h++ {
+		csv += from.Add(time.Duration(h)*time.Hour).Format("2006-01-02 15:04:05") + ",1.0\n"
+	}
+	fn := filepath.Join(dir, "glow.csv")
+	if err := os.WriteFile(fn, []byte(csv), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	run(t, srv, db, "--timezone=UTC", "import", "--format=glow", "--mpan="+fake.ImportMPAN, fn)
+
+	sdb, err := sql.Open("sqlite3", db)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sdb.Close()
+	o, err := octonaut.New(context.Background(), fake.DefaultAccount, fake.DefaultKey, srv.URL+"/", sdb)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	c, err := o.Consumption(context.Background(), fake.ImportMPAN, "", from.AddDate(0, 0, -1), from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Consumption: %v", err)
+	}
+	if got, want := len(c.Intervals), 96; got != want {
+		t.Fatalf("got %d intervals, want %d", got, want)
+	}
+	for i, ci := range c.Intervals {
+		// Imported readings are split into half-hours, and don't replace synced ones.
+		if imported := ci.Consumption == 0.5; imported != ci.Start.Before(from) {
+			t.Errorf("interval %d at %v: got %.3f kWh", i, ci.Start, ci.Consumption)
+		}
+	}
+
+	// Readings may be imported into any of the property's import MPANs.
+	run(t, srv, db, "--timezone=UTC", "import", "--format=glow", "--mpan="+secondMPAN, fn)
+	c, err = o.Consumption(context.Background(), secondMPAN, secondMeter, from.AddDate(0, 0, -1), from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("Consumption: %v", err)
+	}
+	if got, want := len(c.Intervals), 96; got != want {
+		t.Errorf("got %d intervals for MPAN %s, want %d", got, secondMPAN, want)
+	}
+}
+
+func TestExport(t *testing.T) {
+	s := fake.New(fake.DefaultKey)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Generate(fake.Options{From: from, To: from.AddDate(0, 0, 7)})
+	// Prepay products only have prices for customers who don't pay by direct debit.
+	const prepayProduct = "PREPAY-VAR-18-09-21"
+	prepay := octopus.BuildTariffCode("E", "1R", prepayProduct, fake.Region)
+	s.AddProduct(octopus.Product{Code: prepayProduct, FullName: "Prepay Flexible", IsVariable: true, IsPrepay: true, Direction: "IMPORT", AvailableFrom: from})
+	s.AddRates(prepay, octopus.StandardUnitRates, []octopus.RateInterval{{ValidFrom: from, ValueExcVat: 25, ValueIncVat: 26.25, PaymentMethod: octopus.NonDirectDebit}})
+	s.AddRates(prepay, octopus.StandingCharges, []octopus.RateInterval{{ValidFrom: from, ValueExcVat: 50, ValueIncVat: 52.5, PaymentMethod: octopus.NonDirectDebit}})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	dir := t.TempDir()
+	db := filepath.Join(dir, "octonaut.sqlite3")
+	agile := octopus.BuildTariffCode("E", "1R", fake.ImportAgile, fake.Region)
+
+	run(t, srv, db, "sync")
+
+	t.Run("consumption", func(t *testing.T) {
+		out := run(t, srv, db, "export", "consumption", "--from=2024-01-01", "--to=2024-01-03")
+		rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", out, err)
+		}
+		if got, want := len(rows), 1+2*48; got != want {
+			t.Fatalf("got %d rows, want %d", got, want)
+		}
+		if got, want := rows[0], []string{"interval_start", "interval_end", "consumption_kwh"}; !slices.Equal(got, want) {
+			t.Errorf("got header %q, want %q", got, want)
+		}
+	})
+
+	t.Run("costs", func(t *testing.T) {
+		out := run(t, srv, db, "export", "costs", "--from=2024-01-01", "--to=2024-01-02", "--tariff_code="+agile, "--format=jsonl")
+		n := 0
+		for _, l := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+			var r octonaut.CostRow
+			if err := json.Unmarshal(l, &r); err != nil {
+				t.Fatalf("Unmarshal(%q): %v", l, err)
+			}
+			if r.CostPence != r.RatePence*r.ConsumptionKWh {
+				t.Errorf("got %+v, want cost = rate * consumption", r)
+			}
+			n++
+		}
+		if got, want := n, 48; got != want {
+			t.Errorf("got %d rows, want %d", got, want)
+		}
+	})
+
+	t.Run("rates", func(t *testing.T) {
+		fn := filepath.Join(dir, "rates.parquet")
+		run(t, srv, db, "export", "rates", "--from=2024-01-01", "--to=2024-01-02", "--tariff_code="+agile, "--format=parquet", "--output="+fn)
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Stat: %v", err)
+		}
+		rows, err := parquet.Read[octonaut.RateRow](f, fi.Size())
+		if err != nil {
+			t.Fatalf("parquet.Read: %v", err)
+		}
+		if got, want := len(rows), 48; got != want {
+			t.Errorf("got %d rows, want %d", got, want)
+		}
+	})
+
+	t.Run("prepay", func(t *testing.T) {
+		for _, test := range []struct {
+			export string
+			want   octonaut.RateRow
+		}{
+			{export: "rates", want: octonaut.RateRow{TariffCode: prepay, RateType: octopus.StandardUnitRates, ValueExcVAT: 25, ValueIncVAT: 26.25}},
+			{export: "standing_charges", want: octonaut.RateRow{TariffCode: prepay, RateType: octopus.StandingCharges, ValueExcVAT: 50, ValueIncVAT: 52.5}},
+		} {
+			out := run(t, srv, db, "export", test.export, "--from=2024-01-01", "--to=2024-01-02", "--tariff_code="+prepay, "--format=jsonl")
+			var r octonaut.RateRow
+			if err := json.Unmarshal(bytes.TrimSpace(out), &r); err != nil {
+				t.Fatalf("Unmarshal(%q): %v", out, err)
+			}
+			r.ValidFrom, r.ValidTo = time.Time{}, nil
+			if r != test.want {
+				t.Errorf("%s: got %+v, want %+v", test.export, r, test.want)
+			}
+		}
+	})
+}
+
+func TestBill(t *testing.T) {
+	s := fake.New(fake.DefaultKey)
+	from, to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	a := s.Generate(fake.Options{From: from, To: to})
+	// Switch from Agile to Variable part way through the month, with a gap between the agreements.
+	movedOut, movedIn := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	agile := octopus.BuildTariffCode("E", "1R", fake.ImportAgile, fake.Region)
+	variable := octopus.BuildTariffCode("E", "1R", fake.Variable, fake.Region)
+	a.Properties[0].ElectricityMeterPoints[0].Agreements = []octopus.Agreement{
+		{TariffCode: agile, ValidFrom: from, ValidTo: &movedOut},
+		{TariffCode: variable, ValidFrom: movedIn},
+	}
+	s.AddAccount(a)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	db := filepath.Join(t.TempDir(), "octonaut.sqlite3")
+
+	run(t, srv, db, "sync")
+
+	sdb, err := sql.Open("sqlite3", db)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sdb.Close()
+	o, err := octonaut.New(context.Background(), fake.DefaultAccount, fake.DefaultKey, srv.URL+"/", sdb)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	cons, err := o.Consumption(context.Background(), fake.ImportMPAN, "", from, to)
+	if err != nil {
+		t.Fatalf("Consumption: %v", err)
+	}
+	// Consumption while neither agreement was in force is left out.
+	var billed float64
+	for _, c := range cons.Intervals {
+		if c.Start.Before(movedOut) || !c.Start.Before(movedIn) {
+			billed += c.Consumption
+		}
+	}
+
+	bill := func(vat string) []string {
+		out := run(t, srv, db, "bill", "--from=2024-01-01", "--to=2024-02-01", "--format=csv", "--vat="+vat)
+		rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", out, err)
+		}
+		if len(rows) != 2 {
+			t.Fatalf("got %q, want a header and January", rows)
+		}
+		return rows[1]
+	}
+	f := func(s string) float64 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			t.Fatalf("ParseFloat(%q): %v", s, err)
+		}
+		return v
+	}
+	jan := bill("domestic")
+	if got, want := jan[0], "2024-01"; got != want {
+		t.Errorf("got month %q, want %q", got, want)
+	}
+	if got := f(jan[1]); math.Abs(got-billed) > 0.01 {
+		t.Errorf("got %.4f kWh, want %.4f kWh", got, billed)
+	}
+	if got, want := jan[5], agile+" "+variable; got != want {
+		t.Errorf("got tariffs %q, want %q", got, want)
+	}
+
+	// Without VAT the bill is 5% smaller, give or take the rounding of the API's VAT inclusive prices.
+	exc := bill("exclusive")
+	if got, want := f(exc[4]), f(jan[4])/1.05; math.Abs(got-want) > want*0.001 {
+		t.Errorf("got total %.4f without VAT, want %.4f", got, want)
+	}
+}
+
+func TestProducts(t *testing.T) {
+	s := fake.New(fake.DefaultKey)
+	s.Generate(fake.Options{From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	db := filepath.Join(t.TempDir(), "octonaut.sqlite3")
+
+	for _, test := range []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "current",
+			want: []string{fake.ImportAgile, fake.ExportAgile, fake.Variable, fake.Go, fake.Tracker, fake.Fixed, fake.BusinessVar},
+		}, {
+			name: "available_at",
+			args: []string{"--available_at=2023-06-01"},
+			want: []string{fake.OldAgile},
+		}, {
+			name: "history",
+			args: []string{"--from=2023-06-01", "--to=2024-01-15", "--direction=import", "--variable", "--tracker=false", "--business=false"},
+			want: []string{fake.OldAgile, fake.ImportAgile, fake.Variable, fake.Go},
+		}, {
+			name: "fixed",
+			args: []string{"--variable=false"},
+			want: []string{fake.Fixed},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out := run(t, srv, db, append([]string{"products", "--format=json"}, test.args...)...)
+			var ps []octopus.Product
+			if err := json.Unmarshal(out, &ps); err != nil {
+				t.Fatalf("Unmarshal(%q): %v", out, err)
+			}
+			got := []string{}
+			for _, p := range ps {
+				got = append(got, p.Code)
+			}
+			want := slices.Clone(test.want)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("got products %q, want %q", got, want)
+			}
+		})
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		out := run(t, srv, db, "products", "--from=2023-06-01", "--to=2024-01-15", "--format=csv")
+		rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", out, err)
+		}
+		for _, r := range rows[1:] {
+			if _, err := time.Parse(time.RFC3339, r[11]); err != nil {
+				t.Errorf("%s: available from %q isn't RFC 3339: %v", r[0], r[11], err)
+			}
+			if r[0] != fake.OldAgile {
+				continue
+			}
+			if got, want := r[12], time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339); got != want {
+				t.Errorf("%s: got available to %q, want %q", r[0], got, want)
+			}
+		}
+	})
+
+	t.Run("detail", func(t *testing.T) {
+		out := run(t, srv, db, "--region=C", "products", fake.Variable, "--format=csv")
+		rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll(%q): %v", out, err)
+		}
+		want := []string{
+			octopus.BuildTariffCode("E", "1R", fake.Variable, fake.Region),
+			octopus.BuildTariffCode("E", "2R", fake.Variable, fake.Region),
+			octopus.BuildTariffCode("G", "1R", fake.Variable, fake.Region),
+		}
+		if len(rows) != 1+len(want) {
+			t.Fatalf("got %q, want a row for each of %q", rows, want)
+		}
+		for i, w := range want {
+			if got := rows[i+1][0]; got != w {
+				t.Errorf("row %d: got tariff %q, want %q", i+1, got, w)
+			}
+			if sc, err := strconv.ParseFloat(rows[i+1][5], 64); err != nil || sc <= 0 {
+				t.Errorf("row %d: got standing charge %q, want current price", i+1, rows[i+1][5])
+			}
+		}
+	})
+}
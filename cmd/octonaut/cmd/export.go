@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export {consumption|rates|standing_charges|costs}",
+	Short: "Exports consumption, tariff rates, standing charges, or modelled costs as CSV, JSON Lines, or Parquet",
+	Long: `Exports consumption, tariff rates, standing charges, or modelled costs as CSV, JSON Lines, or Parquet.
+
+Costs are the energy cost of each interval of consumption, and don't include standing charges, which
+are charged daily rather than per interval. Export standing_charges for the same --tariff_code to add them.`,
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"consumption", "rates", "standing_charges", "costs"},
+	Run:       doExport,
+}
+
+var (
+	exportFrom   string
+	exportTo     string
+	exportFormat string
+	exportOutput string
+	exportCode   string
+	exportVAT    string
+	exportMPAN   string
+	exportMeter  string
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringVar(&exportFrom, "from", "", "Date from which to export (YYYY-MM-DD).")
+	exportCmd.Flags().StringVar(&exportTo, "to", "", "Date to export to, or leave unset to use today (YYYY-MM-DD).")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "csv", "Output format. Valid options: csv, jsonl, parquet.")
+	exportCmd.Flags().StringVar(&exportOutput, "output", "", "File to write to, defaults to stdout.")
+	exportCmd.Flags().StringVar(&exportCode, "tariff_code", "", "Tariff code (e.g. E-1R-AGILE-23-12-06-C) to export rates, standing charges, or costs for.")
+	exportCmd.Flags().StringVar(&exportVAT, "vat", "domestic", "How VAT is applied to costs. Valid options: domestic (5%), business (20%), exclusive (no VAT).")
+	exportCmd.Flags().StringVar(&exportMPAN, "mpan", "", "Import MPAN to export consumption or costs for, defaults to stitching together all import MPANs over the history of the account.")
+	exportCmd.Flags().StringVar(&exportMeter, "meter", "", "Serial number of the meter to export consumption or costs for, defaults to all meters on the MPAN.")
+
+	exportCmd.MarkFlagRequired("from")
+}
+
+func doExport(command *cobra.Command, args []string) {
+	ctx := context.Background()
+	o, c := MustNewFromFlags(ctx)
+	defer func() {
+		if err := c(); err != nil {
+			log.Warnf("close: %v", err)
+		}
+	}()
+
+	f, err := octonaut.ParseExportFormat(exportFormat)
+	if err != nil {
+		log.Fatalf("Invalid --format: %v", err)
+	}
+	from, to := mustParseDates(exportFrom, exportTo)
+	loc := MustLocation()
+
+	var write func(w io.Writer) error
+	switch args[0] {
+	case "consumption":
+		cons := mustExportConsumption(ctx, o, from, to)
+		write = func(w io.Writer) error { return octonaut.WriteRows(w, f, octonaut.ConsumptionRows(cons, loc)) }
+	case "rates", "standing_charges":
+		rows, err := exportRates(ctx, o, args[0] == "standing_charges", from, to)
+		if err != nil {
+			log.Fatalf("Failed to read rates: %v", err)
+		}
+		write = func(w io.Writer) error { return octonaut.WriteRows(w, f, rows) }
+	case "costs":
+		cons := mustExportConsumption(ctx, o, from, to)
+		cost, err := exportCosts(ctx, o, cons, from, to)
+		if err != nil {
+			log.Fatalf("Failed to cost consumption: %v", err)
+		}
+		write = func(w io.Writer) error { return octonaut.WriteRows(w, f, octonaut.CostRows(cost, loc)) }
+	}
+
+	if exportOutput == "" {
+		err = write(os.Stdout)
+	} else {
+		err = writeFileAtomically(exportOutput, write)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write %s: %v", args[0], err)
+	}
+}
+
+// writeFileAtomically writes to a temporary file in the same directory as name, which replaces name only
+// once write has succeeded, so that a failed export doesn't leave a truncated file behind.
+func writeFileAtomically(name string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return fmt.Errorf("CreateTemp: %v", err)
+	}
+	// This fails harmlessly once the file has been renamed.
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("Chmod(%q): %v", f.Name(), err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Close(%q): %v", f.Name(), err)
+	}
+	if err := os.Rename(f.Name(), name); err != nil {
+		return fmt.Errorf("Rename: %v", err)
+	}
+	return nil
+}
+
+// mustExportConsumption returns the import consumption selected by the --mpan and --meter flags.
+func mustExportConsumption(ctx context.Context, o *octonaut.Octonaut, from, to time.Time) octonaut.Consumption {
+	sources := []octonaut.ConsumptionSource{{MPAN: exportMPAN, Meter: exportMeter}}
+	if exportMPAN == "" {
+		a, notFound, err := o.Account(ctx)
+		if err != nil {
+			log.Fatalf("Account: %v", err)
+		}
+		if notFound {
+			log.Fatalf("Account %s not found locally, run the sync command first", Account)
+		}
+		sources = octonaut.ImportSources(a)
+	}
+	cons, err := o.StitchedConsumption(ctx, sources, from, to)
+	if err != nil {
+		log.Fatalf("Consumption: %v", err)
+	}
+	return cons
+}
+
+// exportRates syncs the tariff selected by --tariff_code, and returns rows for its unit rates, or
+// its standing charges if standing is set.
+func exportRates(ctx context.Context, o *octonaut.Octonaut, standing bool, from, to time.Time) ([]octonaut.RateRow, error) {
+	t, err := syncTariffCode(ctx, o, from, to)
+	if err != nil {
+		return nil, err
+	}
+	loc := MustLocation()
+	if standing {
+		sc, err := o.StandingCharges(ctx, t.Code, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("StandingCharges: %v", err)
+		}
+		return octonaut.RateRows(t.Code, octopus.StandingCharges, *sc, loc), nil
+	}
+
+	rows := []octonaut.RateRow{}
+	for _, rt := range t.UnitRateTypes() {
+		rs, err := o.TariffRates(ctx, t.Code, rt, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("TariffRates(%s): %v", rt, err)
+		}
+		rows = append(rows, octonaut.RateRows(t.Code, rt, *rs, loc)...)
+	}
+	return rows, nil
+}
+
+// syncTariffCode finds the tariff selected by --tariff_code in its product's details, and syncs its rates
+// between from and to.
+func syncTariffCode(ctx context.Context, o *octonaut.Octonaut, from, to time.Time) (*octopus.Tariff, error) {
+	if exportCode == "" {
+		return nil, fmt.Errorf("--tariff_code is required")
+	}
+	f, registers, product, region, err := octopus.ParseTariffCode(exportCode)
+	if err != nil {
+		return nil, err
+	}
+	t, err := o.ResolveTariff(ctx, product, f, registers, region, "")
+	if err != nil {
+		return nil, fmt.Errorf("ResolveTariff(%s): %v", exportCode, err)
+	}
+	if err := o.SyncResolvedTariff(ctx, t, from, to); err != nil {
+		return nil, fmt.Errorf("SyncResolvedTariff(%s): %v", t.Code, err)
+	}
+	return t, nil
+}
+
+// exportCosts syncs the tariff selected by --tariff_code, and uses it to cost the consumption.
+func exportCosts(ctx context.Context, o *octonaut.Octonaut, cons octonaut.Consumption, from, to time.Time) (*octonaut.Cost, error) {
+	t, err := syncTariffCode(ctx, o, from, to)
+	if err != nil {
+		return nil, err
+	}
+	vm, err := octonaut.ParseVATMode(exportVAT)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := unitRates(ctx, o, t.Code, vm, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return octonaut.TotalCost(ctx, cons, rates)
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "costs.csv")
+	if err := os.WriteFile(name, []byte("previous export\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	check := func(want string) {
+		t.Helper()
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if es, err := os.ReadDir(dir); err != nil || len(es) != 1 {
+			t.Errorf("got directory entries %v, %v, want only %s", es, err, filepath.Base(name))
+		}
+	}
+
+	// A failure part way through leaves the previous file untouched.
+	errWrite := errors.New("rate lookup failed")
+	err := writeFileAtomically(name, func(w io.Writer) error {
+		fmt.Fprintln(w, "partial")
+		return errWrite
+	})
+	if !errors.Is(err, errWrite) {
+		t.Errorf("got err %v, want %v", err, errWrite)
+	}
+	check("previous export\n")
+
+	if err := writeFileAtomically(name, func(w io.Writer) error {
+		_, err := fmt.Fprintln(w, "new export")
+		return err
+	}); err != nil {
+		t.Fatalf("writeFileAtomically: %v", err)
+	}
+	check("new export\n")
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus/fake"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// fakeServerCmd represents the fake-server command
+var fakeServerCmd = &cobra.Command{
+	Use:   "fake-server",
+	Short: "Serves a fake Octopus API with synthetic data, so you can try octonaut without an account",
+	Run:   doFakeServer,
+}
+
+var (
+	fakeListen string
+	fakeDays   int
+	fakeSeed   int64
+)
+
+func init() {
+	rootCmd.AddCommand(fakeServerCmd)
+
+	fakeServerCmd.Flags().StringVar(&fakeListen, "listen", "localhost:8080", "Address to serve the fake API on.")
+	fakeServerCmd.Flags().IntVar(&fakeDays, "days", 365, "Number of days of consumption and rates to generate, up until today.")
+	fakeServerCmd.Flags().Int64Var(&fakeSeed, "seed", 1, "Seed for the randomly generated data.")
+}
+
+func doFakeServer(command *cobra.Command, args []string) {
+	account, key := Account, Key
+	if account == "" {
+		account = fake.DefaultAccount
+	}
+	if key == "" {
+		key = fake.DefaultKey
+	}
+
+	s := fake.New(key)
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	s.Generate(fake.Options{
+		Account: account,
+		From:    to.AddDate(0, 0, -fakeDays),
+		To:      to,
+		Seed:    fakeSeed,
+	})
+
+	ep := fmt.Sprintf("http://%s/", fakeListen)
+	log.Infof("Serving fake Octopus API on %s", ep)
+	log.Infof("Try: octonaut --endpoint=%s --account=%s --key=%s --db=fake.sqlite3 sync", ep, account, key)
+	if err := http.ListenAndServe(fakeListen, s); err != nil {
+		log.Fatalf("ListenAndServe: %v", err)
+	}
+}
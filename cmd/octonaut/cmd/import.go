@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import [flags] file.csv...",
+	Short: "Imports electricity consumption from CSV files, e.g. downloaded from the Octopus dashboard, Home Assistant, Glow, or n3rgy",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   doImport,
+}
+
+var (
+	importFormat  string
+	importMPAN    string
+	importMeter   string
+	importReplace bool
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringVar(&importFormat, "format", "octopus", "Format of the CSV files. Valid options: octopus, homeassistant, glow, n3rgy.")
+	importCmd.Flags().StringVar(&importMPAN, "mpan", "", "Import MPAN to store the readings against, defaults to the MPAN of the property you were in at the time.")
+	importCmd.Flags().StringVar(&importMeter, "meter", "", "Meter serial number to store the readings against, defaults to the latest meter on the MPAN.")
+	importCmd.Flags().BoolVar(&importReplace, "replace", false, "Overwrite readings already stored for the same meter, rather than keeping them.")
+}
+
+func doImport(command *cobra.Command, args []string) {
+	ctx := context.Background()
+	o, c := MustNewFromFlags(ctx)
+	defer func() {
+		if err := c(); err != nil {
+			log.Warnf("close: %v", err)
+		}
+	}()
+
+	f, err := octonaut.ParseImportFormat(importFormat)
+	if err != nil {
+		log.Fatalf("Invalid --format: %v", err)
+	}
+	rs := []octopus.ConsumptionReading{}
+	for _, fn := range args {
+		r, err := os.Open(fn)
+		if err != nil {
+			log.Fatalf("Failed to open %q: %v", fn, err)
+		}
+		frs, err := octonaut.ReadConsumptionCSV(r, f, MustLocation())
+		r.Close()
+		if err != nil {
+			log.Fatalf("Failed to read %q: %v", fn, err)
+		}
+		log.Infof("Read %d readings from %s", len(frs), fn)
+		rs = append(rs, frs...)
+	}
+	rs = octonaut.HalfHourly(rs)
+	if len(rs) == 0 {
+		log.Fatalf("Found no complete half-hours of consumption to import")
+	}
+
+	sources := []octonaut.ConsumptionSource{{MPAN: importMPAN, Meter: importMeter}}
+	if importMPAN == "" || importMeter == "" {
+		a, notFound, err := o.Account(ctx)
+		if err != nil {
+			log.Fatalf("Account: %v", err)
+		}
+		if notFound {
+			log.Fatalf("Account %s not found locally, run the sync command first or set --mpan and --meter", Account)
+		}
+		sources = importTargets(a, importMPAN, importMeter)
+	}
+
+	for _, s := range sources {
+		in := []octopus.ConsumptionReading{}
+		for _, r := range rs {
+			if !r.IntervalStart.Before(s.From) && (s.To.IsZero() || r.IntervalStart.Before(s.To)) {
+				in = append(in, r)
+			}
+		}
+		if len(in) == 0 {
+			continue
+		}
+		n, err := o.ImportConsumption(ctx, s.MPAN, s.Meter, in, importReplace)
+		if err != nil {
+			log.Fatalf("ImportConsumption(%s): %v", s.MPAN, err)
+		}
+		log.Infof("Imported %d of %d half-hours between %v and %v for MPAN %s meter %s", n, len(in), in[0].IntervalStart, in[len(in)-1].IntervalEnd, s.MPAN, s.Meter)
+		if skipped := len(in) - n; skipped > 0 {
+			log.Infof("Skipped %d half-hours which already had consumption stored, use --replace to overwrite them", skipped)
+		}
+	}
+}
+
+// importTargets returns the MPANs and meters on the account which imported readings should be stored
+// against, along with the period each covers. If mpan or meter are set, they override those on the account.
+func importTargets(a *octopus.Account, mpan, meter string) []octonaut.ConsumptionSource {
+	sources := octonaut.ImportSources(a)
+	if mpan != "" {
+		// Properties may have several import MPANs, of which ImportSources only uses the first.
+		sources = octonaut.MPANSources(a, mpan)
+	}
+	r := []octonaut.ConsumptionSource{}
+	for _, s := range sources {
+		s.Meter = meter
+		if s.Meter == "" {
+			s.Meter = latestMeter(a, s.Property, s.MPAN)
+		}
+		if s.Meter == "" {
+			log.Warnf("No meters found for MPAN %s, use --meter to set one", s.MPAN)
+			continue
+		}
+		if mpan != "" {
+			// The readings were explicitly for this MPAN, so don't restrict them to when we lived there.
+			s.From, s.To = time.Time{}, time.Time{}
+		}
+		r = append(r, s)
+	}
+	if len(r) == 0 {
+		log.Fatalf("Found no import MPANs to store the readings against")
+	}
+	return r
+}
+
+// latestMeter returns the serial number of the last meter listed on the property's MPAN.
+func latestMeter(a *octopus.Account, property int, mpan string) string {
+	for _, p := range a.Properties {
+		if p.ID != property {
+			continue
+		}
+		for _, em := range p.ElectricityMeterPoints {
+			if em.MPAN == mpan && len(em.Meters) > 0 {
+				return em.Meters[len(em.Meters)-1].SerialNumber
+			}
+		}
+	}
+	return ""
+}
@@ -2,10 +2,16 @@ package cmd
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
+	"slices"
 	"strconv"
-	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/AlCutter/octonaut/internal/octonaut"
@@ -24,14 +30,41 @@ var modelCmd = &cobra.Command{
 }
 
 var (
-	batteryCap    float64
-	batteryRate   float64
-	batteryCharge string
+	batteryCap           float64
+	batteryRate          float64
+	batteryDischargeRate float64
+	batteryEfficiency    float64
+	batteryMinSoC        float64
+	batteryMaxSoC        float64
+	batteryCharge        string
+	serviceLimit         float64
 
 	fromStr string
 	toStr   string
 
-	csvFile string
+	csvFile      string
+	csvPrecision int
+	csvUnits     string
+	breakdown    string
+
+	registers  []string
+	nightHours string
+
+	compareProducts []string
+	compareAll      bool
+	compareFormat   string
+
+	exportTariff string
+
+	vat string
+
+	propertyID int
+	mpan       string
+	meter      string
+
+	gasTariff           string
+	gasCalorificValue   float64
+	gasCorrectionFactor float64
 )
 
 func init() {
@@ -39,17 +72,48 @@ func init() {
 
 	modelCmd.Flags().StringVar(&tariff, "tariff", "", "Tariff code to use for modelling.")
 	modelCmd.Flags().Float64Var(&batteryCap, "battery_capacity", 0, "Battery capacity in kWh for modelling load shifting.")
-	modelCmd.Flags().Float64Var(&batteryRate, "battery_rate", 0, "Battery max charge/discharge rate in kWh for modelling load shifting.")
-	modelCmd.Flags().StringVar(&batteryCharge, "battery_charge", "", "Battery charge stratech for load shifting. Valid options: <hour>-<hour> (e.g. '0-5' to charge between midnight and 5am).")
+	modelCmd.Flags().Float64Var(&batteryRate, "battery_rate", 0, "Battery max charge/discharge rate in kW for modelling load shifting.")
+	modelCmd.Flags().Float64Var(&batteryDischargeRate, "battery_discharge_rate", 0, "Battery max discharge rate in kW, if different from --battery_rate.")
+	modelCmd.Flags().Float64Var(&batteryEfficiency, "battery_efficiency", 1, "Battery round-trip efficiency, e.g. 0.9 if 10% of energy is lost when charging and discharging.")
+	modelCmd.Flags().Float64Var(&batteryMinSoC, "battery_min_soc", 0, "Fraction of battery capacity to keep in reserve, e.g. 0.1 to never discharge below 10%.")
+	modelCmd.Flags().Float64Var(&batteryMaxSoC, "battery_max_soc", 1, "Fraction of battery capacity to charge up to.")
+	modelCmd.Flags().StringVar(&batteryCharge, "battery_charge", "", "Battery charge stratech for load shifting. Valid options: <hour>-<hour> in local time (e.g. '0-5' to charge between midnight and 5am), or 'optimal' to charge and discharge at the best times for the tariff's rates.")
+	modelCmd.Flags().Float64Var(&serviceLimit, "service_limit", 0, "Maximum power in kW which may be imported from the grid while charging the battery, or 0 for no limit.")
 
 	modelCmd.Flags().StringVar(&fromStr, "from", "", "Date from which to start modelling (YYYY-MM-DD).")
 	modelCmd.Flags().StringVar(&toStr, "to", "", "Date to model to, or leave until to model until today (YYYY-MM-DD).")
 
-	modelCmd.Flags().StringVar(&csvFile, "write_csv", "", "If set, write a csv containing the modeled data to the named file.")
+	modelCmd.Flags().StringVar(&csvFile, "write_csv", "", "If set, write a csv containing the modeled data to the named file, replacing it if it exists. Can't be used with --compare or --compare_all.")
+	modelCmd.Flags().IntVar(&csvPrecision, "csv_precision", 4, "Number of decimal places to write numbers to the --write_csv file with, or -1 for full precision.")
+	modelCmd.Flags().StringVar(&csvUnits, "csv_units", "pence", "Units for rates and costs in the --write_csv file. Valid options: pence, pounds.")
+	modelCmd.Flags().StringVar(&breakdown, "breakdown", "", "If set, also print costs broken down by period in local time. Valid options: day, week, month, quarter, hour (hour of day profile), weekday (day of week profile).")
+
+	modelCmd.Flags().StringSliceVar(&registers, "registers", nil, "Register types to model tariffs with, e.g. 1R for single rate or 2R for day/night Economy 7 tariffs. Defaults to that of your current agreement, several may be given when comparing.")
+	modelCmd.Flags().StringVar(&nightHours, "night_hours", "0.5-7.5", "Hours (in UTC, since Economy 7 meters ignore daylight saving) during which night rates apply for 2R tariffs, in the same <hour>-<hour> format as --battery_charge.")
+
+	modelCmd.Flags().StringSliceVar(&compareProducts, "compare", nil, "Comma separated list of product codes to compare, instead of modelling a single --tariff.")
+	modelCmd.Flags().BoolVar(&compareAll, "compare_all", false, "Compare all currently available electricity products, instead of modelling a single --tariff.")
+	modelCmd.Flags().StringVar(&compareFormat, "format", "table", "Output format for comparisons and breakdowns. Valid options: table, csv, json.")
+
+	modelCmd.Flags().IntVar(&propertyID, "property", 0, "ID of the property to model, defaults to modelling the full history of the account.")
+	modelCmd.Flags().StringVar(&mpan, "mpan", "", "MPAN to model consumption from, defaults to stitching together all import MPANs over the history of the account.")
+	modelCmd.Flags().StringVar(&meter, "meter", "", "Serial number of the electricity or gas meter to model consumption from, defaults to stitching together readings from all meters on the MPAN or MPRN.")
+
+	modelCmd.Flags().StringVar(&vat, "vat", "domestic", "How VAT is applied to prices. Valid options: domestic (5%), business (20%), exclusive (no VAT). Export income never has VAT.")
+
+	modelCmd.Flags().StringVar(&exportTariff, "export_tariff", "", "Export product code (e.g. AGILE-OUTGOING-19-05-13) to use for modelling income from exported electricity.")
+
+	modelCmd.Flags().StringVar(&gasTariff, "gas_tariff", "", "Gas product code to use for modelling gas consumption.")
+	modelCmd.Flags().Float64Var(&gasCalorificValue, "gas_calorific_value", 39.5, "Calorific value of gas in MJ/m³, used to convert readings from meters which report gas volume to kWh.")
+	modelCmd.Flags().Float64Var(&gasCorrectionFactor, "gas_correction_factor", 1.02264, "Volume correction factor used to convert gas volume to kWh.")
 
 	modelCmd.MarkFlagsRequiredTogether("battery_capacity", "battery_rate", "battery_charge")
 	modelCmd.MarkFlagRequired("from")
-	modelCmd.MarkFlagRequired("tariff")
+	modelCmd.MarkFlagsOneRequired("tariff", "compare", "compare_all", "gas_tariff", "export_tariff")
+	modelCmd.MarkFlagsMutuallyExclusive("tariff", "compare", "compare_all")
+	// Comparisons model many tariffs, so there's no single set of intervals to write.
+	modelCmd.MarkFlagsMutuallyExclusive("write_csv", "compare")
+	modelCmd.MarkFlagsMutuallyExclusive("write_csv", "compare_all")
 }
 
 func doModel(command *cobra.Command, args []string) {
@@ -61,30 +125,153 @@ func doModel(command *cobra.Command, args []string) {
 		}
 	}()
 
-	a, _, err := o.Account(ctx)
+	a, notFound, err := o.Account(ctx)
 	if err != nil {
 		log.Fatalf("Account: %v", err)
 	}
+	if notFound {
+		log.Fatalf("Account %s not found locally, run the sync command first", Account)
+	}
+
+	modelElec := tariff != "" || len(compareProducts) > 0 || compareAll
+	var sources []octonaut.ConsumptionSource
+	var ps octopus.Property
+	switch {
+	case modelElec:
+		sources = importSources(a)
+		ps = property(a, sources[len(sources)-1].Property)
+	case propertyID != 0:
+		ps = property(a, propertyID)
+	default:
+		ps = latestProperty(a)
+	}
+
+	from, to := mustParseDates(fromStr, toStr)
+	log.Infof("From: %v", from)
+	log.Infof("To: %v", to)
 
-	ps := a.Properties[0]
-	em := ps.ElectricityMeterPoints[0]
+	var elec, gas *tariffResult
+	if modelElec {
+		elec = modelElectricity(ctx, o, ps, sources, from, to)
+	}
+	if gasTariff != "" {
+		gas = modelGas(ctx, o, ps, from, to)
+	}
+	if elec != nil && gas != nil {
+		total := elec.TotalCost + gas.TotalCost
+		log.Infof("Dual fuel total: £%.2f (£%.2f/day)", total/100.0, (total/100.0)/float64(elec.Days))
+	}
+	if exportTariff != "" {
+		exp := modelExport(ctx, o, ps, from, to)
+		if elec != nil {
+			net := elec.TotalCost - exp.TotalCost
+			log.Infof("Net Cost  : £%.2f (import £%.2f, export income £%.2f)", net/100.0, elec.TotalCost/100.0, exp.TotalCost/100.0)
+		}
+	}
+}
 
-	from, err := time.Parse(time.DateOnly, fromStr)
+// mustParseDates parses the YYYY-MM-DD dates in the time zone selected by --timezone.
+// If to is empty, the start of today is returned.
+func mustParseDates(fromStr, toStr string) (time.Time, time.Time) {
+	loc := MustLocation()
+	from, err := time.ParseInLocation(time.DateOnly, fromStr, loc)
 	if err != nil {
 		log.Fatalf("Invalid from date: %v", err)
 	}
-	to := time.Now().Truncate(24 * time.Hour)
+	to := octonaut.StartOfDay(time.Now(), loc)
 	if toStr != "" {
-		to, err = time.Parse(time.DateOnly, toStr)
+		to, err = time.ParseInLocation(time.DateOnly, toStr, loc)
 		if err != nil {
 			log.Fatalf("Invalid to date: %v", err)
 		}
 	}
-	log.Infof("From: %v", from)
-	log.Infof("To: %v", to)
+	return from, to
+}
+
+// importSources returns the sources of electricity consumption to model, taking into account the
+// --property, --mpan and --meter flags.
+// By default, the full history of the account is used.
+func importSources(a *octopus.Account) []octonaut.ConsumptionSource {
+	r := []octonaut.ConsumptionSource{}
+	if mpan == "" && meter == "" {
+		for _, s := range octonaut.ImportSources(a) {
+			if propertyID == 0 || s.Property == propertyID {
+				r = append(r, s)
+			}
+		}
+	} else {
+		for _, p := range a.Properties {
+			if propertyID != 0 && p.ID != propertyID {
+				continue
+			}
+			for _, em := range p.ElectricityMeterPoints {
+				if mpan != "" && em.MPAN != mpan {
+					continue
+				}
+				if meter != "" && !slices.ContainsFunc(em.Meters, func(m octopus.Meter) bool { return m.SerialNumber == meter }) {
+					continue
+				}
+				r = append(r, octonaut.ConsumptionSource{Property: p.ID, MPAN: em.MPAN, Meter: meter})
+			}
+		}
+		if len(r) > 1 {
+			log.Fatalf("Found %d matching MPANs, please select one with --mpan", len(r))
+		}
+	}
+	if len(r) == 0 {
+		log.Fatalf("Found no matching MPANs with consumption to model")
+	}
+	return r
+}
+
+// property returns the property with the given ID.
+func property(a *octopus.Account, id int) octopus.Property {
+	for _, p := range a.Properties {
+		if p.ID == id {
+			return p
+		}
+	}
+	log.Fatalf("Property %d not found", id)
+	return octopus.Property{}
+}
+
+// meterPoint returns the electricity meter point with the given MPAN.
+func meterPoint(ps octopus.Property, mpan string) octopus.ElectricityMeterPoint {
+	for _, em := range ps.ElectricityMeterPoints {
+		if em.MPAN == mpan {
+			return em
+		}
+	}
+	log.Fatalf("MPAN %s not found in property %d", mpan, ps.ID)
+	return octopus.ElectricityMeterPoint{}
+}
+
+// currentAgreement returns the meter point's agreement which is active now, or the most recent agreement
+// if there isn't one, e.g. because the property has been moved out of.
+func currentAgreement(as []octopus.Agreement) *octopus.Agreement {
+	var r *octopus.Agreement
+	for i, a := range as {
+		if !time.Now().Before(a.ValidFrom) && (a.ValidTo == nil || time.Now().Before(*a.ValidTo)) {
+			return &as[i]
+		}
+		if r == nil || a.ValidFrom.After(r.ValidFrom) {
+			r = &as[i]
+		}
+	}
+	if r == nil {
+		log.Fatalf("No agreements found")
+	}
+	return r
+}
+
+// modelElectricity models the electricity consumption from the sources, either against the tariff
+// requested with --tariff, or by comparing several products.
+// The property's current agreement is used to determine the register types of the tariffs.
+// Returns nil if a comparison was run.
+func modelElectricity(ctx context.Context, o *octonaut.Octonaut, ps octopus.Property, sources []octonaut.ConsumptionSource, from, to time.Time) *tariffResult {
+	em := meterPoint(ps, sources[len(sources)-1].MPAN)
 
-	// TODO
-	cons, err := o.Consumption(ctx, em.MPAN, em.Meters[0].SerialNumber, from, to)
+	cons, err := o.StitchedConsumption(ctx, sources, from, to)
 	if err != nil {
 		log.Fatalf("Consumption: %v", err)
 	}
@@ -95,106 +282,533 @@ func doModel(command *cobra.Command, args []string) {
 		intelligentGo = "INTELLI-VAR-22-10-14"
 	)
 
-	agreement := em.ActiveAgreement(time.Now())
-	f, r, _, pc, err := octopus.ParseTariffCode(agreement.TariffCode)
+	agreement := currentAgreement(em.Agreements)
+	f, r, _, _, err := octopus.ParseTariffCode(agreement.TariffCode)
 	if err != nil {
 		log.Fatalf("Failed to parse existing tariff code: %v", err)
 	}
 
-	tariffCode := octopus.BuildTariffCode(f, r, tariff, pc)
-	log.Infof("Using TariffCode %q", tariffCode)
-	if err := o.SyncTariff(ctx, tariff, tariffCode, from, to); err != nil {
-		log.Fatalf("SyncTariff (%s): %v", tariff, err)
+	var bm *batteryModel
+	if batteryCharge != "" {
+		b, err := batteryFromFlags()
+		if err != nil {
+			log.Fatalf("Invalid battery: %v", err)
+		}
+		bm = &batteryModel{battery: b}
+		if batteryCharge != "optimal" {
+			bm.window, err = octonaut.ParseWindow(batteryCharge, MustLocation())
+			if err != nil {
+				log.Fatalf("Invalid battery charge strategy: %v", err)
+			}
+		}
+	}
+
+	if len(registers) == 0 {
+		registers = []string{r}
+	}
+	pc := MustRegion(ctx, o, ps.ID, agreement.TariffCode)
+
+	if len(compareProducts) > 0 || compareAll {
+		doCompare(ctx, o, cons, bm, f, registers, pc, from, to)
+		return nil
+	}
+
+	t, err := o.ResolveTariff(ctx, tariff, f, registers[0], pc, "")
+	if err != nil {
+		log.Fatalf("Failed to find tariff: %v", err)
+	}
+	log.Infof("Using TariffCode %q", t.Code)
+	if bm != nil {
+		bm.compareOptimal = true
 	}
-	rates, err := o.TariffRates(ctx, tariffCode, from, to)
+	res, err := modelTariff(ctx, o, cons, bm, tariff, t, from, to)
 	if err != nil {
-		log.Fatalf("TariffRates: %v", err)
+		log.Fatalf("%v", err)
+	}
+	if res.battery != nil {
+		log.Infof("Battery losses: %.2f kWh (%.0f%% round-trip efficiency)", res.battery.TotalLosses(), bm.battery.RoundTripEfficiency()*100)
 	}
+	logResult(res)
+	if res.OptimalEnergyCost != nil {
+		log.Infof("Optimal   : £%.2f energy cost with optimal battery dispatch, the charge window costs £%.2f more", *res.OptimalEnergyCost/100.0, (res.EnergyCost-*res.OptimalEnergyCost)/100.0)
+	}
+	logBreakdown(res)
 
-	stats := []octonaut.IntervalStat{}
-	if batteryCharge != "" {
-		cs, err := chargeStrategy(batteryCharge)
+	if csvFile != "" {
+		stats := []octonaut.IntervalStat{}
+		if res.battery != nil {
+			stats = append(stats, res.battery)
+		}
+		if err := writeCSV(csvFile, res.cost, stats...); err != nil {
+			log.Fatalf("Failed to write csv to %q: %v", csvFile, err)
+		}
+	}
+	return res
+}
+
+// modelGas models the gas consumption of the property against the product requested with --gas_tariff.
+func modelGas(ctx context.Context, o *octonaut.Octonaut, ps octopus.Property, from, to time.Time) *tariffResult {
+	gm, serials := gasMeters(ps)
+	cs := []octonaut.Consumption{}
+	for _, serial := range serials {
+		c, err := o.GasConsumption(ctx, gm.MPRN, serial, from, to)
 		if err != nil {
-			log.Fatalf("Invalid battery charge strategy: %v", err)
+			log.Warnf("No consumption for gas meter %s between %v and %v: %v", serial, from, to, err)
+			continue
 		}
-		loadShift, loadShiftStats := octonaut.LoadShift(batteryCap, batteryRate, 0, cs)
-		cons = octonaut.Apply(loadShift, cons)
-		stats = append(stats, loadShiftStats)
+		units, source, notFound, err := o.GasMeterUnits(ctx, gm.MPRN, serial)
+		if err != nil {
+			log.Fatalf("GasMeterUnits: %v", err)
+		}
+		if notFound {
+			log.Fatalf("Units of gas meter %s unknown, run the sync command first", serial)
+		}
+		log.Infof("Gas meter %s reports %s (%s)", serial, units, source)
+		if source == "detected" {
+			log.Warnf("Units of gas meter %s were guessed from its readings, if it doesn't report %s set them with sync --gas_units=kWh (SMETS1) or --gas_units=m3 (SMETS2)", serial, units)
+		}
+		if units == octonaut.GasUnitsM3 {
+			c = octonaut.Apply(octonaut.GasVolumeToKWh(gasCalorificValue, gasCorrectionFactor), c)
+		}
+		cs = append(cs, c)
+	}
+	if len(cs) == 0 {
+		log.Fatalf("No gas consumption for MPRN %s between %v and %v", gm.MPRN, from, to)
 	}
+	cons := mergeConsumption(cs)
 
-	cost := runModel(ctx, cons, rates)
-	if csvFile != "" {
-		if err := writeCSV(csvFile, cost, stats...); err != nil {
-			log.Fatalf("Failed to write csv to %q: %v", csvFile, err)
+	agreement := currentAgreement(gm.Agreements)
+	f, r, _, _, err := octopus.ParseTariffCode(agreement.TariffCode)
+	if err != nil {
+		log.Fatalf("Failed to parse existing gas tariff code: %v", err)
+	}
+	t, err := o.ResolveTariff(ctx, gasTariff, f, r, MustRegion(ctx, o, ps.ID, agreement.TariffCode), "")
+	if err != nil {
+		log.Fatalf("Failed to find gas tariff: %v", err)
+	}
+	log.Infof("Using gas TariffCode %q", t.Code)
+	res, err := modelTariff(ctx, o, cons, nil, gasTariff, t, from, to)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	logResult(res)
+	logBreakdown(res)
+	return res
+}
+
+// gasMeters returns the property's gas meter point to model, and the serial numbers of the meters on it whose
+// readings should be used. These are the meter selected with --meter, if it's a gas meter, or by default all
+// of the meters on the meter point.
+func gasMeters(ps octopus.Property) (octopus.GasMeterPoints, []string) {
+	type candidate struct {
+		gm      octopus.GasMeterPoints
+		serials []string
+	}
+	find := func(serial string) []candidate {
+		r := []candidate{}
+		for _, gm := range ps.GasMeterPoints {
+			c := candidate{gm: gm}
+			for _, m := range gm.ActiveMeters() {
+				if serial == "" || m.SerialNumber == serial {
+					c.serials = append(c.serials, m.SerialNumber)
+				}
+			}
+			if len(c.serials) > 0 {
+				r = append(r, c)
+			}
+		}
+		return r
+	}
+	cs := find(meter)
+	if len(cs) == 0 && meter != "" {
+		// --meter may be selecting the electricity meter when modelling both fuels.
+		cs = find("")
+	}
+	switch len(cs) {
+	case 0:
+		log.Fatalf("Property %d has no gas meters", ps.ID)
+	case 1:
+	default:
+		log.Fatalf("Property %d has %d gas meter points, please select a meter with --meter", ps.ID, len(cs))
+	}
+	return cs[0].gm, cs[0].serials
+}
+
+// mergeConsumption stitches together readings from several meters, preferring the largest reading for each
+// interval as Octonaut.Consumption does, since an old meter may continue to report zeros after it's been
+// replaced.
+func mergeConsumption(cs []octonaut.Consumption) octonaut.Consumption {
+	byStart := map[int64]octonaut.ConsumptionInterval{}
+	for _, c := range cs {
+		for _, i := range c.Intervals {
+			if p, ok := byStart[i.Start.Unix()]; !ok || i.Consumption > p.Consumption {
+				byStart[i.Start.Unix()] = i
+			}
 		}
 	}
+	r := octonaut.Consumption{}
+	for _, i := range byStart {
+		r.Intervals = append(r.Intervals, i)
+	}
+	slices.SortFunc(r.Intervals, func(a, b octonaut.ConsumptionInterval) int { return a.Start.Compare(b.Start) })
+	return r
 }
 
-func runModel(ctx context.Context, cons octonaut.Consumption, rates *octopus.TariffRate) *octonaut.Cost {
-	start := cons.Intervals[0].Start
-	end := cons.Intervals[len(cons.Intervals)-1].End
-	cost, err := octonaut.TotalCost(ctx, cons, octonaut.Tariff(*rates))
+// modelExport models the income from electricity exported by the property under the product requested
+// with --export_tariff.
+func modelExport(ctx context.Context, o *octonaut.Octonaut, ps octopus.Property, from, to time.Time) *tariffResult {
+	var em *octopus.ElectricityMeterPoint
+	for i := range ps.ElectricityMeterPoints {
+		if ps.ElectricityMeterPoints[i].Export() {
+			em = &ps.ElectricityMeterPoints[i]
+			break
+		}
+	}
+	if em == nil {
+		log.Fatalf("Property %d has no export MPAN", ps.ID)
+	}
+	ms := em.ActiveMeters()
+	if len(ms) == 0 {
+		log.Fatalf("Export MPAN %s has no meters", em.MPAN)
+	}
+
+	// Readings from all of the MPAN's meters are stitched together, unless --meter selects one of them rather
+	// than the import meter.
+	serial := ""
+	for _, m := range ms {
+		if m.SerialNumber == meter {
+			serial = meter
+		}
+	}
+	cons, err := o.ExportConsumption(ctx, em.MPAN, serial, from, to)
+	if err != nil {
+		log.Fatalf("ExportConsumption: %v", err)
+	}
+
+	// Export tariffs are always single register, so only the region is taken from the export agreement,
+	// or from the import one if the export MPAN doesn't have any.
+	as := em.Agreements
+	if len(as) == 0 {
+		for _, im := range ps.ElectricityMeterPoints {
+			if !im.Export() {
+				as = im.Agreements
+				break
+			}
+		}
+	}
+	agreement := currentAgreement(as)
+	t, err := o.ResolveTariff(ctx, exportTariff, "E", "1R", MustRegion(ctx, o, ps.ID, agreement.TariffCode), "")
+	if err != nil {
+		log.Fatalf("Failed to find export tariff: %v", err)
+	}
+	log.Infof("Using export TariffCode %q", t.Code)
+	res, err := modelTariff(ctx, o, cons, nil, exportTariff, t, from, to)
 	if err != nil {
-		log.Fatalf("TotalCost: %v", err)
+		log.Fatalf("%v", err)
 	}
-	log.Infof("Energy    : £%.2f (inc. VAT) (%.2f kWh)", cost.TotalCost/100.0, cost.TotalConsumption)
-	days := float64((end.Sub(start)) / (24 * time.Hour))
-	standing := 54.83 * days
-	log.Infof("Standing  : £%.2f (inc. VAT) (%.1f days)", standing/100.0, days)
-	totalCost := (cost.TotalCost + standing)
-	log.Infof("Total Cost: £%.2f (£%.2f/day, effective £%.2f/kWh)", totalCost/100.0, (totalCost/100.0)/days, (totalCost/100.0)/cost.TotalConsumption)
+	log.Infof("Export    : £%.2f income (%.2f kWh, effective £%.2f/kWh)", res.TotalCost/100.0, res.Consumption, res.EffectiveRate/100.0)
+	logBreakdown(res)
+	return res
+}
 
-	return cost
+func logResult(res *tariffResult) {
+	inc := "inc. VAT"
+	if res.vatMode == octonaut.VATExclusive {
+		inc = "exc. VAT"
+	}
+	log.Infof("Energy    : £%.2f (%s) (%.2f kWh)", res.EnergyCost/100.0, inc, res.Consumption)
+	log.Infof("Standing  : £%.2f (%s) (%d days)", res.StandingCost/100.0, inc, res.Days)
+	log.Infof("VAT       : £%.2f (%s, %.0f%%)", res.VAT/100.0, res.vatMode, res.vatMode.Rate()*100)
+	log.Infof("Total Cost: £%.2f (£%.2f/day, effective £%.2f/kWh)", res.TotalCost/100.0, (res.TotalCost/100.0)/float64(res.Days), res.EffectiveRate/100.0)
 }
 
-func writeCSV(name string, c *octonaut.Cost, s ...octonaut.IntervalStat) error {
-	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+// logBreakdown prints the result's costs broken down by the period requested with --breakdown, if any.
+func logBreakdown(res *tariffResult) {
+	if breakdown == "" {
+		return
+	}
+	p, err := octonaut.ParseBreakdownPeriod(breakdown)
 	if err != nil {
-		return fmt.Errorf("Open(%q): %v", name, err)
+		log.Fatalf("Invalid breakdown: %v", err)
 	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			log.Fatalf("Close(%q): %v", name, err)
+	bs, err := octonaut.Breakdown(res.cost, res.standing, p, MustLocation())
+	if err != nil {
+		log.Fatalf("Breakdown: %v", err)
+	}
+	if err := writeBreakdown(os.Stdout, compareFormat, bs); err != nil {
+		log.Fatalf("Failed to write breakdown: %v", err)
+	}
+}
+
+// writeBreakdown writes the breakdown buckets to w in the requested format.
+func writeBreakdown(w io.Writer, format string, bs []octonaut.BreakdownBucket) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "Period\tkWh\tEnergy\tStanding\tTotal\tp/kWh\t")
+		for _, b := range bs {
+			fmt.Fprintf(tw, "%s\t%.2f\t£%.2f\t£%.2f\t£%.2f\t%.2f\t\n", b.Label, b.Consumption, b.EnergyCost/100.0, b.StandingCost/100.0, b.TotalCost()/100.0, b.AverageRate())
 		}
-	}()
-	if err := c.ToCSV(f, s...); err != nil {
-		return fmt.Errorf("ToCSV: %v", err)
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"Period", "ConsumptionKWh", "EnergyCost", "StandingCost", "TotalCost", "AverageRate"}); err != nil {
+			return err
+		}
+		f := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+		for _, b := range bs {
+			if err := cw.Write([]string{b.Label, f(b.Consumption), f(b.EnergyCost), f(b.StandingCost), f(b.TotalCost()), f(b.AverageRate())}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		type bucket struct {
+			Period       string  `json:"period"`
+			Consumption  float64 `json:"consumption_kwh"`
+			EnergyCost   float64 `json:"energy_cost"`
+			StandingCost float64 `json:"standing_cost"`
+			TotalCost    float64 `json:"total_cost"`
+			AverageRate  float64 `json:"average_rate"`
+		}
+		js := make([]bucket, 0, len(bs))
+		for _, b := range bs {
+			js = append(js, bucket{b.Label, b.Consumption, b.EnergyCost, b.StandingCost, b.TotalCost(), b.AverageRate()})
+		}
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(js)
+	default:
+		return fmt.Errorf("unknown format %q", format)
 	}
-	return nil
 }
 
-func chargeStrategy(s string) (func(t time.Time) bool, error) {
-	bits := strings.Split(s, "-")
-	if len(bits) != 2 {
-		return nil, fmt.Errorf("invalid strategy format, must be <N>-<M>")
+// tariffResult holds the outcome of modelling consumption against a single tariff.
+// All costs are in pence, including VAT according to --vat, apart from export income which has none.
+type tariffResult struct {
+	Product       string  `json:"product"`
+	TariffCode    string  `json:"tariff_code"`
+	Consumption   float64 `json:"consumption_kwh"`
+	Days          int     `json:"days"`
+	EnergyCost    float64 `json:"energy_cost"`
+	StandingCost  float64 `json:"standing_cost"`
+	TotalCost     float64 `json:"total_cost"`
+	VAT           float64 `json:"vat"`
+	EffectiveRate float64 `json:"effective_rate"`
+	// OptimalEnergyCost is the energy cost had the battery been dispatched optimally, it's only set
+	// when modelling a battery with a fixed charging window against a single tariff.
+	OptimalEnergyCost *float64 `json:"optimal_energy_cost,omitempty"`
+
+	vatMode  octonaut.VATMode
+	cost     *octonaut.Cost
+	standing *octonaut.Standing
+	battery  *octonaut.LoadShiftStats
+}
+
+// batteryModel describes the battery to use for load shifting when modelling.
+type batteryModel struct {
+	battery octonaut.Battery
+	// window returns true for times when the battery should charge, or is nil if the battery
+	// should be dispatched optimally for the tariff.
+	window func(t time.Time) bool
+	// compareOptimal is set to also work out what optimal dispatch would have cost when the battery has
+	// a fixed charging window. It's left unset when comparing tariffs, where it isn't reported.
+	compareOptimal bool
+}
+
+// modelTariff syncs the rates for the given tariff and uses them to calculate the cost of the
+// provided consumption, optionally load shifted with a battery.
+func modelTariff(ctx context.Context, o *octonaut.Octonaut, cons octonaut.Consumption, bm *batteryModel, product string, t *octopus.Tariff, from, to time.Time) (*tariffResult, error) {
+	if err := o.SyncResolvedTariff(ctx, t, from, to); err != nil {
+		return nil, fmt.Errorf("SyncTariff (%s): %w", product, err)
+	}
+	tariffCode := t.Code
+	vm, err := octonaut.ParseVATMode(vat)
+	if err != nil {
+		return nil, err
+	}
+	// Export income isn't subject to VAT for domestic generators, whatever is charged on imports.
+	if octopus.IsExportTariff(product) {
+		vm = octonaut.VATExclusive
+	}
+	// RateFns must be used in order, so we need a fresh one for each time the consumption is costed.
+	newRates := func() (octonaut.RateFn, error) {
+		return unitRates(ctx, o, tariffCode, vm, from, to)
 	}
-	n, err := parseHour(bits[0])
+
+	var batteryStats *octonaut.LoadShiftStats
+	var optimal *octonaut.Cost
+	if bm != nil {
+		cons, batteryStats, optimal, err = applyBattery(ctx, bm, cons, newRates)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rates, err := newRates()
 	if err != nil {
-		return nil, fmt.Errorf("interval start: %v", err)
+		return nil, err
 	}
-	m, err := parseHour(bits[1])
+	start := octonaut.StartOfDay(cons.Intervals[0].Start, MustLocation())
+	end := octonaut.StartOfDay(cons.Intervals[len(cons.Intervals)-1].End, MustLocation())
+	cost, err := octonaut.TotalCost(ctx, cons, rates)
 	if err != nil {
-		return nil, fmt.Errorf("interval end: %v", err)
+		return nil, fmt.Errorf("TotalCost: %v", err)
 	}
-	return func(t time.Time) bool {
-		h := float64(t.Hour()) + float64(t.Minute())/60.0
-		if n <= m {
-			// range is within a single day, e.g. 5-10
-			return h >= n && h < m
-		} else {
-			// range crosses midnight boundary, e.g. 23-4
-			return h >= n || h < m
+
+	standing := &octonaut.Standing{}
+	// Export tariffs don't have standing charges.
+	if t.Link(octopus.StandingCharges) != "" {
+		standingRates, err := o.StandingCharges(ctx, tariffCode, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("StandingCharges: %v", err)
+		}
+		standing, err = octonaut.StandingCost(ctx, start, end, MustLocation(), octonaut.TariffVAT(*standingRates, vm))
+		if err != nil {
+			return nil, fmt.Errorf("StandingCost: %v", err)
 		}
+	}
+
+	r := &tariffResult{
+		Product:      product,
+		TariffCode:   tariffCode,
+		Consumption:  cost.TotalConsumption,
+		Days:         len(standing.DailyCosts),
+		EnergyCost:   cost.TotalCost,
+		StandingCost: standing.TotalCost,
+		TotalCost:    cost.TotalCost + standing.TotalCost,
+		vatMode:      vm,
+		cost:         cost,
+		standing:     standing,
+		battery:      batteryStats,
+	}
+	r.VAT = r.TotalCost - vm.ExcludingVAT(r.TotalCost)
+	if optimal != nil {
+		r.OptimalEnergyCost = &optimal.TotalCost
+	}
+	if r.Consumption > 0 {
+		r.EffectiveRate = r.TotalCost / r.Consumption
+	}
+	return r, nil
+}
+
+// applyBattery load shifts the consumption using the battery.
+// If the battery has a fixed charging window and bm.compareOptimal is set, the cost of the consumption had
+// the battery been dispatched optimally is also returned for comparison.
+func applyBattery(ctx context.Context, bm *batteryModel, cons octonaut.Consumption, newRates func() (octonaut.RateFn, error)) (octonaut.Consumption, *octonaut.LoadShiftStats, *octonaut.Cost, error) {
+	if bm.window != nil && !bm.compareOptimal {
+		tf, stats := octonaut.LoadShift(bm.battery, bm.window)
+		return octonaut.Apply(tf, cons), stats, nil, nil
+	}
+
+	rates, err := newRates()
+	if err != nil {
+		return cons, nil, nil, err
+	}
+	tf, optimalStats, err := octonaut.OptimalDispatch(ctx, bm.battery, cons, rates, MustLocation())
+	if err != nil {
+		return cons, nil, nil, fmt.Errorf("OptimalDispatch: %v", err)
+	}
+	optimalCons := octonaut.Apply(tf, cons)
+	if bm.window == nil {
+		return optimalCons, optimalStats, nil, nil
+	}
+
+	rates, err = newRates()
+	if err != nil {
+		return cons, nil, nil, err
+	}
+	optimal, err := octonaut.TotalCost(ctx, optimalCons, rates)
+	if err != nil {
+		return cons, nil, nil, fmt.Errorf("TotalCost: %v", err)
+	}
+	tf, stats := octonaut.LoadShift(bm.battery, bm.window)
+	return octonaut.Apply(tf, cons), stats, optimal, nil
+}
+
+// batteryFromFlags returns the battery configuration described by the --battery_* flags.
+func batteryFromFlags() (octonaut.Battery, error) {
+	switch {
+	case batteryCap <= 0:
+		return octonaut.Battery{}, errors.New("capacity must be > 0")
+	case batteryRate <= 0:
+		return octonaut.Battery{}, errors.New("rate must be > 0")
+	case batteryEfficiency <= 0 || batteryEfficiency > 1:
+		return octonaut.Battery{}, errors.New("efficiency must be 0 < N <= 1")
+	case batteryMinSoC < 0 || batteryMaxSoC > 1 || batteryMinSoC >= batteryMaxSoC:
+		return octonaut.Battery{}, errors.New("state of charge limits must be 0 <= min < max <= 1")
+	}
+	dr := batteryDischargeRate
+	if dr <= 0 {
+		dr = batteryRate
+	}
+	// Assume losses are split evenly between charging and discharging.
+	e := math.Sqrt(batteryEfficiency)
+	return octonaut.Battery{
+		Capacity:            batteryCap,
+		ChargeRate:          batteryRate,
+		DischargeRate:       dr,
+		ChargeEfficiency:    e,
+		DischargeEfficiency: e,
+		MinSoC:              batteryMinSoC,
+		MaxSoC:              batteryMaxSoC,
+		ServiceLimit:        serviceLimit,
 	}, nil
 }
 
-func parseHour(s string) (float64, error) {
-	i, err := strconv.ParseFloat(s, 5)
+// unitRates returns a RateFn for the locally stored unit rates of the given tariff code, taking
+// into account whether it's a single or two register tariff.
+func unitRates(ctx context.Context, o *octonaut.Octonaut, tariffCode string, vat octonaut.VATMode, from, to time.Time) (octonaut.RateFn, error) {
+	_, r, _, _, err := octopus.ParseTariffCode(tariffCode)
+	if err != nil {
+		return nil, err
+	}
+	if r != "2R" {
+		rates, err := o.TariffRates(ctx, tariffCode, octopus.StandardUnitRates, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("TariffRates: %v", err)
+		}
+		return octonaut.TariffVAT(*rates, vat), nil
+	}
+
+	// Economy 7 meters don't change their clocks for daylight saving time.
+	isNight, err := octonaut.ParseWindow(nightHours, time.UTC)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("invalid night hours: %v", err)
 	}
-	if i < 0 || i >= 24 {
-		return 0, fmt.Errorf("%f should be 0 <= N < 24", i)
+	day, err := o.TariffRates(ctx, tariffCode, octopus.DayUnitRates, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("TariffRates(day): %v", err)
+	}
+	night, err := o.TariffRates(ctx, tariffCode, octopus.NightUnitRates, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("TariffRates(night): %v", err)
 	}
-	return i, nil
+	return octonaut.DayNight(octonaut.TariffVAT(*day, vat), octonaut.TariffVAT(*night, vat), isNight), nil
+}
+
+// writeCSV writes the costs and stats to the named file, formatted according to the --csv_* flags.
+// The file is replaced if it already exists.
+func writeCSV(name string, c *octonaut.Cost, s ...octonaut.IntervalStat) error {
+	opts := octonaut.CSVOptions{Precision: &csvPrecision, Location: MustLocation()}
+	switch csvUnits {
+	case "pence":
+	case "pounds":
+		opts.Pounds = true
+	default:
+		return fmt.Errorf("unknown --csv_units %q", csvUnits)
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Open(%q): %v", name, err)
+	}
+	if err := c.ToCSV(f, opts, s...); err != nil {
+		f.Close()
+		return fmt.Errorf("ToCSV: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("Close(%q): %v", name, err)
+	}
+	return nil
 }
@@ -2,20 +2,64 @@ package cmd
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // productsCmd represents the products command
 var productsCmd = &cobra.Command{
-	Use:   "products",
-	Short: "List products and tariffs",
+	Use:   "products [product code]",
+	Short: "Lists products, or shows the tariffs and current prices offered by a single product",
+	Args:  cobra.MaximumNArgs(1),
 	Run:   doProducts,
 }
 
+var (
+	productsAvailableAt string
+	productsFrom        string
+	productsTo          string
+	productsFormat      string
+	productsBrand       string
+	productsDirection   string
+	productsTerm        int
+
+	productsVariable bool
+	productsGreen    bool
+	productsTracker  bool
+	productsBusiness bool
+	productsPrepay   bool
+)
+
 func init() {
 	rootCmd.AddCommand(productsCmd)
+
+	productsCmd.Flags().StringVar(&productsAvailableAt, "available_at", "", "List the products which were available on this date (YYYY-MM-DD) rather than today.")
+	productsCmd.Flags().StringVar(&productsFrom, "from", "", "List all of the products which were available at any time from this date (YYYY-MM-DD), including products which have since been withdrawn. Products which came and went between the start of each month are only listed if they were cached by an earlier listing.")
+	productsCmd.Flags().StringVar(&productsTo, "to", "", "Date to list products available until when using --from, or leave unset to use today (YYYY-MM-DD).")
+	productsCmd.Flags().StringVar(&productsFormat, "format", "table", "Output format. Valid options: table, csv, json.")
+	productsCmd.Flags().StringVar(&productsBrand, "brand", "", "Only list products from this brand, e.g. OCTOPUS_ENERGY.")
+	productsCmd.Flags().StringVar(&productsDirection, "direction", "", "Only list import or export products.")
+	productsCmd.Flags().IntVar(&productsTerm, "term", 0, "Only list products with this contract term in months.")
+	productsCmd.Flags().BoolVar(&productsVariable, "variable", false, "Only list variable products, or fixed ones with --variable=false.")
+	productsCmd.Flags().BoolVar(&productsGreen, "green", false, "Only list green products, or others with --green=false.")
+	productsCmd.Flags().BoolVar(&productsTracker, "tracker", false, "Only list tracker products, or others with --tracker=false.")
+	productsCmd.Flags().BoolVar(&productsBusiness, "business", false, "Only list business products, or domestic ones with --business=false.")
+	productsCmd.Flags().BoolVar(&productsPrepay, "prepay", false, "Only list prepay products, or others with --prepay=false.")
+	productsCmd.MarkFlagsMutuallyExclusive("available_at", "from")
 }
 
 func doProducts(cmd *cobra.Command, args []string) {
@@ -27,13 +71,273 @@ func doProducts(cmd *cobra.Command, args []string) {
 		}
 	}()
 
-	ps, err := o.Products(ctx, nil)
+	if len(args) == 1 {
+		d, err := o.Product(ctx, args[0])
+		if err != nil {
+			log.Fatalf("Product(%s): %v", args[0], err)
+		}
+		if err := writeProduct(os.Stdout, productsFormat, d, productsRegion(ctx, o)); err != nil {
+			log.Fatalf("Failed to write product: %v", err)
+		}
+		return
+	}
+
+	ps, err := listProducts(ctx, o)
 	if err != nil {
 		log.Fatalf("Products: %v", err)
 	}
-	for _, p := range ps.Results {
-		log.Infof("%s:", p.Code)
-		log.Infof("  %s", p.Description)
+	filters := productFilters(cmd.Flags())
+	r := []octopus.Product{}
+	for _, p := range ps {
+		if matchesAll(p, filters) {
+			r = append(r, p)
+		}
+	}
+	if err := writeProducts(os.Stdout, productsFormat, r); err != nil {
+		log.Fatalf("Failed to write products: %v", err)
+	}
+}
+
+// listProducts returns the products available at the time selected by the --available_at flag, or
+// at any time in the range selected by --from and --to, sorted by code.
+func listProducts(ctx context.Context, o *octonaut.Octonaut) ([]octopus.Product, error) {
+	loc := MustLocation()
+	var at *time.Time
+	switch {
+	case productsFrom != "":
+		from, to := mustParseDates(productsFrom, productsTo)
+		ps, err := o.ProductsBetween(ctx, from, to)
+		if err != nil {
+			return nil, err
+		}
+		return ps.Results, nil
+	case productsAvailableAt != "":
+		t, err := time.ParseInLocation(time.DateOnly, productsAvailableAt, loc)
+		if err != nil {
+			log.Fatalf("Invalid --available_at: %v", err)
+		}
+		at = &t
+	}
+	ps, err := o.Products(ctx, at)
+	if err != nil {
+		return nil, err
+	}
+	r := ps.Results
+	sort.Slice(r, func(i, j int) bool { return r[i].Code < r[j].Code })
+	return r, nil
+}
+
+// productFilters returns functions which select the products matching the filter flags which were set.
+// Boolean flags select products with the property when set, and those without it when set to false.
+func productFilters(fs *pflag.FlagSet) []func(p octopus.Product) bool {
+	r := []func(p octopus.Product) bool{}
+	for name, field := range map[string]func(p octopus.Product) bool{
+		"variable": func(p octopus.Product) bool { return p.IsVariable },
+		"green":    func(p octopus.Product) bool { return p.IsGreen },
+		"tracker":  func(p octopus.Product) bool { return p.IsTracker },
+		"business": func(p octopus.Product) bool { return p.IsBusiness },
+		"prepay":   func(p octopus.Product) bool { return p.IsPrepay },
+	} {
+		if !fs.Changed(name) {
+			continue
+		}
+		want, err := fs.GetBool(name)
+		if err != nil {
+			log.Fatalf("--%s: %v", name, err)
+		}
+		r = append(r, func(p octopus.Product) bool { return field(p) == want })
+	}
+	if productsBrand != "" {
+		r = append(r, func(p octopus.Product) bool { return strings.EqualFold(p.Brand, productsBrand) })
+	}
+	if productsDirection != "" {
+		r = append(r, func(p octopus.Product) bool { return strings.EqualFold(p.Direction, productsDirection) })
+	}
+	if fs.Changed("term") {
+		r = append(r, func(p octopus.Product) bool { return p.Term == productsTerm })
+	}
+	return r
+}
+
+func matchesAll(p octopus.Product, filters []func(p octopus.Product) bool) bool {
+	for _, f := range filters {
+		if !f(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// productType describes the kind of product, e.g. "variable, green".
+func productType(p octopus.Product) string {
+	r := []string{"fixed"}
+	if p.IsVariable {
+		r[0] = "variable"
+	}
+	for _, t := range []struct {
+		is   bool
+		name string
+	}{{p.IsTracker, "tracker"}, {p.IsGreen, "green"}, {p.IsPrepay, "prepay"}, {p.IsBusiness, "business"}, {p.IsRestricted, "restricted"}} {
+		if t.is {
+			r = append(r, t.name)
+		}
+	}
+	return strings.Join(r, ", ")
+}
+
+// availableTo formats the date until which the product is available, which is empty if it's open-ended.
+func availableTo(p octopus.Product) string {
+	if p.AvailableTo == nil {
+		return ""
+	}
+	return p.AvailableTo.In(MustLocation()).Format(time.DateOnly)
+}
+
+// csvAvailableTo formats the time until which the product is available in the same RFC 3339 form as the
+// CSV's available from column, which is empty if it's open-ended.
+func csvAvailableTo(p octopus.Product) string {
+	if p.AvailableTo == nil {
+		return ""
+	}
+	return p.AvailableTo.Format(time.RFC3339)
+}
+
+func writeProducts(w io.Writer, format string, ps []octopus.Product) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Code\tName\tDirection\tType\tTerm\tBrand\tAvailable from\tAvailable to\t")
+		for _, p := range ps {
+			term := ""
+			if p.Term > 0 {
+				term = fmt.Sprintf("%dm", p.Term)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", p.Code, p.DisplayName, p.Direction, productType(p), term, p.Brand, p.AvailableFrom.In(MustLocation()).Format(time.DateOnly), availableTo(p))
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"Code", "DisplayName", "FullName", "Direction", "IsVariable", "IsGreen", "IsTracker", "IsPrepay", "IsBusiness", "Term", "Brand", "AvailableFrom", "AvailableTo"}); err != nil {
+			return err
+		}
+		b := strconv.FormatBool
+		for _, p := range ps {
+			if err := cw.Write([]string{p.Code, p.DisplayName, p.FullName, p.Direction, b(p.IsVariable), b(p.IsGreen), b(p.IsTracker), b(p.IsPrepay), b(p.IsBusiness), strconv.Itoa(p.Term), p.Brand, p.AvailableFrom.Format(time.RFC3339), csvAvailableTo(p)}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(ps)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// productsRegion returns the region to show a product's tariffs for, which is that set with --region
+// or found for the account's property, or an empty string to show all regions.
+func productsRegion(ctx context.Context, o *octonaut.Octonaut) string {
+	if Region != "" {
+		return MustRegion(ctx, o, 0, "")
+	}
+	a, notFound, err := o.Account(ctx)
+	if err != nil || notFound {
+		return ""
+	}
+	r, _, notFound, err := o.Region(ctx, latestProperty(a).ID)
+	if err != nil || notFound {
+		return ""
 	}
+	return r
+}
+
+// productTariff is a tariff offered by a product, along with where and how it's offered.
+type productTariff struct {
+	Fuel          string `json:"fuel"`
+	Registers     string `json:"registers"`
+	Region        string `json:"region"`
+	PaymentMethod string `json:"payment_method"`
+	octopus.Tariff
+}
 
+// productTariffs returns the product's tariffs in the region, or in all regions if region is empty.
+func productTariffs(d octopus.ProductDetail, region string) []productTariff {
+	r := []productTariff{}
+	for _, tt := range octopus.TariffTypes {
+		for reg, byMethod := range *d.Tariffs(tt.Fuel, tt.Registers) {
+			reg = strings.TrimPrefix(reg, "_")
+			if region != "" && reg != region {
+				continue
+			}
+			for m, t := range byMethod {
+				r = append(r, productTariff{Fuel: tt.Fuel, Registers: tt.Registers, Region: reg, PaymentMethod: m, Tariff: t})
+			}
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Code != r[j].Code {
+			return r[i].Code < r[j].Code
+		}
+		return r[i].PaymentMethod < r[j].PaymentMethod
+	})
+	return r
+}
+
+// unitRatesIncVAT describes the tariff's current unit rates including VAT, in pence per kWh.
+func unitRatesIncVAT(t octopus.Tariff) string {
+	if t.Link(octopus.DayUnitRates) != "" {
+		return fmt.Sprintf("day %.2f, night %.2f", t.DayUnitRateIncVAT, t.NightUnitRateIncVAT)
+	}
+	return fmt.Sprintf("%.2f", t.StandardUnitRateIncVAT)
+}
+
+// writeProduct writes the details of the product, and the current prices of its tariffs in the region.
+func writeProduct(w io.Writer, format string, d octopus.ProductDetail, region string) error {
+	ts := productTariffs(d, region)
+	switch format {
+	case "table":
+		fmt.Fprintf(w, "%s: %s\n", d.Code, d.FullName)
+		fmt.Fprintf(w, "%s\n", d.Description)
+		fmt.Fprintf(w, "Type: %s, direction: %s, brand: %s", productType(d.Product), d.Direction, d.Brand)
+		if d.Term > 0 {
+			fmt.Fprintf(w, ", term: %d months", d.Term)
+		}
+		fmt.Fprintf(w, "\nAvailable from %s", d.AvailableFrom.In(MustLocation()).Format(time.DateOnly))
+		if to := availableTo(d.Product); to != "" {
+			fmt.Fprintf(w, " to %s", to)
+		}
+		fmt.Fprintf(w, "\nPrices as of %s, including VAT:\n\n", d.TariffsActiveAt.In(MustLocation()).Format(time.DateOnly))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Tariff\tRegion\tPayment method\tStanding p/day\tUnit p/kWh\t")
+		for _, t := range ts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%s\t\n", t.Code, t.Region, t.PaymentMethod, t.StandingChargeIncVAT, unitRatesIncVAT(t.Tariff))
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"TariffCode", "Fuel", "Registers", "Region", "PaymentMethod", "StandingChargeIncVAT", "StandardUnitRateIncVAT", "DayUnitRateIncVAT", "NightUnitRateIncVAT"}); err != nil {
+			return err
+		}
+		f := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+		for _, t := range ts {
+			if err := cw.Write([]string{t.Code, t.Fuel, t.Registers, t.Region, t.PaymentMethod, f(t.StandingChargeIncVAT), f(t.StandardUnitRateIncVAT), f(t.DayUnitRateIncVAT), f(t.NightUnitRateIncVAT)}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(struct {
+			octopus.Product
+			TariffsActiveAt time.Time       `json:"tariffs_active_at"`
+			Tariffs         []productTariff `json:"tariffs"`
+		}{d.Product, d.TariffsActiveAt, ts})
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
 }
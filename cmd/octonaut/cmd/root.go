@@ -3,9 +3,12 @@ package cmd
 import (
 	"context"
 	"database/sql"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 )
@@ -21,6 +24,12 @@ var (
 	Account  string
 	Key      string
 	DBPath   string
+
+	HTTPTimeout time.Duration
+	MaxRetries  int
+	TimeZone    string
+	Region      string
+	Offline     bool
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -37,6 +46,11 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&Account, "account", "", "Octopus Account e.g. A-123456.")
 	rootCmd.PersistentFlags().StringVar(&Key, "key", "", "Octopus API key.")
 	rootCmd.PersistentFlags().StringVar(&DBPath, "db", "./octonaut.sqlite3", "SQLite3 DB path and filename.")
+	rootCmd.PersistentFlags().DurationVar(&HTTPTimeout, "http_timeout", time.Minute, "Timeout for each request to the Octopus API.")
+	rootCmd.PersistentFlags().StringVar(&TimeZone, "timezone", "Europe/London", "Time zone used for dates, charging windows, and day boundaries.")
+	rootCmd.PersistentFlags().StringVar(&Region, "region", "", "Region letter (A-P) used in tariff codes, e.g. C for London. Overrides the region found when syncing, to model prices elsewhere.")
+	rootCmd.PersistentFlags().BoolVar(&Offline, "offline", false, "Don't use the Octopus API: read products and tariffs from the local cache, and use only the rates which have already been synced.")
+	rootCmd.PersistentFlags().IntVar(&MaxRetries, "retries", 5, "Number of times to retry throttled or failed requests to the Octopus API.")
 }
 
 func MustNewFromFlags(ctx context.Context) (*octonaut.Octonaut, func() error) {
@@ -50,10 +64,55 @@ func MustNewFromFlags(ctx context.Context) (*octonaut.Octonaut, func() error) {
 		u += "/"
 	}
 
-	r, err := octonaut.New(ctx, Account, Key, u, db)
+	r, err := octonaut.NewWithClient(ctx, &octopus.Client{
+		EndPoint:   u,
+		AccountID:  Account,
+		Key:        Key,
+		HTTPClient: &http.Client{Timeout: HTTPTimeout},
+		MaxRetries: MaxRetries,
+	}, db)
 	if err != nil {
 		log.Fatalf("New: %v", err)
 	}
+	r.Offline = Offline
 
 	return r, db.Close
 }
+
+// MustRegion returns the region used to build tariff codes for the property. This is the region set
+// with --region, or that found for the property when syncing, or failing those the region of
+// fallbackTariffCode, e.g. the property's current tariff.
+func MustRegion(ctx context.Context, o *octonaut.Octonaut, property int, fallbackTariffCode string) string {
+	if Region != "" {
+		r := strings.ToUpper(Region)
+		if _, ok := octopus.Regions[r]; !ok {
+			log.Fatalf("Invalid --region %q, must be one of A-P (excluding I and O)", Region)
+		}
+		return r
+	}
+	r, source, notFound, err := o.Region(ctx, property)
+	if err != nil {
+		log.Fatalf("Region: %v", err)
+	}
+	if !notFound {
+		log.Debugf("Using region %s from %s", r, source)
+		return r
+	}
+	if fallbackTariffCode != "" {
+		if _, _, _, r, err := octopus.ParseTariffCode(fallbackTariffCode); err == nil {
+			log.Warnf("Region of property %d unknown, using %s from tariff %s. Run the sync command to detect it, or set --region", property, r, fallbackTariffCode)
+			return r
+		}
+	}
+	log.Fatalf("Region of property %d unknown, run the sync command to detect it, or set --region", property)
+	return ""
+}
+
+// MustLocation returns the time zone selected with the --timezone flag.
+func MustLocation() *time.Location {
+	loc, err := time.LoadLocation(TimeZone)
+	if err != nil {
+		log.Fatalf("Invalid timezone %q: %v", TimeZone, err)
+	}
+	return loc
+}
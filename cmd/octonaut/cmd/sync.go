@@ -2,9 +2,11 @@ package cmd
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"time"
 
+	"github.com/AlCutter/octonaut/internal/octonaut"
+	"github.com/AlCutter/octonaut/internal/octopus"
 	"github.com/charmbracelet/log"
 	"github.com/spf13/cobra"
 )
@@ -17,12 +19,14 @@ var syncCmd = &cobra.Command{
 }
 
 var (
-	tariff string
+	tariff       string
+	syncGasUnits string
 )
 
 func init() {
 	rootCmd.AddCommand(syncCmd)
-	syncCmd.Flags().StringVar(&tariff, "tariff", "", "If set, specifies a tariff code to sync. Use the products command to list product codes.")
+	syncCmd.Flags().StringVar(&tariff, "tariff", "", "If set, syncs the rates of this product's electricity tariff for your region and meter, rather than your account. Use the products command to list product codes.")
+	syncCmd.Flags().StringVar(&syncGasUnits, "gas_units", "", "Units reported by your gas meters: kWh for SMETS1 meters, or m3 for SMETS2 meters. By default they're detected from the readings, set this if that gets it wrong.")
 }
 
 func doSync(command *cobra.Command, args []string) {
@@ -33,21 +37,80 @@ func doSync(command *cobra.Command, args []string) {
 			log.Warnf("close: %v", err)
 		}
 	}()
+	if Offline {
+		log.Fatalf("Can't sync with --offline")
+	}
 
 	if tariff != "" {
-		// TODO: fixme
-		log.Infof("Syncing tariff %s", tariff)
-		if err := o.SyncTariff(ctx, tariff, fmt.Sprintf("E-1R-%s-J", tariff), time.Time{}, time.Now()); err != nil {
-			log.Fatalf("SyncTariff(%s): %v", tariff, err)
-		}
+		syncProductTariff(ctx, o)
 		return
 	}
 
+	switch syncGasUnits {
+	case "", octonaut.GasUnitsM3, octonaut.GasUnitsKWh:
+		o.GasUnits = syncGasUnits
+	default:
+		log.Fatalf("Invalid --gas_units %q, must be m3 or kWh", syncGasUnits)
+	}
 	if err := o.Sync(ctx); err != nil {
+		if ae := (*octopus.AuthError)(nil); errors.As(err, &ae) {
+			log.Fatalf("Octopus rejected the account number or API key: %v", err)
+		}
 		log.Fatalf("Sync: %v", err)
 	}
 	_, _, err := o.Account(ctx)
 	if err != nil {
 		log.Fatalf("Account: %v", err)
 	}
+	// Cache products, so that tariffs can be found without using the API later.
+	if err := o.SyncProducts(ctx); err != nil {
+		log.Fatalf("SyncProducts: %v", err)
+	}
+}
+
+// syncProductTariff syncs the rates of the --tariff product's tariff for the latest property's region and
+// the register type of its current import agreement, or single register if it doesn't have one.
+func syncProductTariff(ctx context.Context, o *octonaut.Octonaut) {
+	a, notFound, err := o.Account(ctx)
+	if err != nil {
+		log.Fatalf("Account: %v", err)
+	}
+	region, registers := Region, "1R"
+	if notFound {
+		if region == "" {
+			log.Fatalf("Account %s not found locally, run the sync command without --tariff first, or set --region", Account)
+		}
+	} else {
+		p := latestProperty(a)
+		for _, em := range p.ElectricityMeterPoints {
+			if em.Export() || len(em.Agreements) == 0 {
+				continue
+			}
+			if _, r, _, _, err := octopus.ParseTariffCode(currentAgreement(em.Agreements).TariffCode); err == nil {
+				registers = r
+			}
+			break
+		}
+		region = MustRegion(ctx, o, p.ID, "")
+	}
+
+	t, err := o.ResolveTariff(ctx, tariff, "E", registers, region, "")
+	if err != nil {
+		log.Fatalf("Failed to find tariff: %v", err)
+	}
+	log.Infof("Syncing tariff %s", t.Code)
+	if err := o.SyncResolvedTariff(ctx, t, time.Time{}, time.Now()); err != nil {
+		log.Fatalf("SyncResolvedTariff(%s): %v", t.Code, err)
+	}
+}
+
+// latestProperty returns the property on the account which was most recently moved into.
+func latestProperty(a *octopus.Account) octopus.Property {
+	var r octopus.Property
+	for _, p := range a.Properties {
+		if p.MovedInAt.After(r.MovedInAt) || r.ID == 0 {
+			r = p
+		}
+	}
+	return r
 }
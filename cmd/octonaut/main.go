@@ -2,6 +2,8 @@ package main
 
 import (
 	"time"
+	// Embed the time zone database in case the system doesn't have one.
+	_ "time/tzdata"
 
 	"github.com/AlCutter/octonaut/cmd/octonaut/cmd"
 	"github.com/charmbracelet/log"
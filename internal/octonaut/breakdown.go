@@ -0,0 +1,156 @@
+package octonaut
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// BreakdownPeriod describes how costs should be grouped by Breakdown.
+type BreakdownPeriod string
+
+const (
+	// ByDay, ByWeek, ByMonth, and ByQuarter group costs into calendar periods.
+	// Weeks start on Monday.
+	ByDay     BreakdownPeriod = "day"
+	ByWeek    BreakdownPeriod = "week"
+	ByMonth   BreakdownPeriod = "month"
+	ByQuarter BreakdownPeriod = "quarter"
+	// ByHourOfDay and ByDayOfWeek group costs into a profile, e.g. all of the consumption between 5pm
+	// and 6pm on any day is added to the same bucket.
+	ByHourOfDay BreakdownPeriod = "hour"
+	ByDayOfWeek BreakdownPeriod = "weekday"
+)
+
+// ParseBreakdownPeriod returns the BreakdownPeriod named by s.
+func ParseBreakdownPeriod(s string) (BreakdownPeriod, error) {
+	switch p := BreakdownPeriod(s); p {
+	case ByDay, ByWeek, ByMonth, ByQuarter, ByHourOfDay, ByDayOfWeek:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown breakdown period %q", s)
+}
+
+// BreakdownBucket holds the consumption and costs which fell into a single bucket of a breakdown.
+// Costs are in pence.
+type BreakdownBucket struct {
+	// Label describes the bucket, e.g. "2024-03" or "17:00".
+	Label string
+	// Start and End are the bounds of calendar buckets, they're unset for profile buckets.
+	Start        time.Time
+	End          time.Time
+	Consumption  float64
+	EnergyCost   float64
+	StandingCost float64
+}
+
+// TotalCost returns the sum of the energy and standing costs in the bucket.
+func (b BreakdownBucket) TotalCost() float64 {
+	return b.EnergyCost + b.StandingCost
+}
+
+// AverageRate returns the average unit rate paid for the energy consumed in the bucket.
+func (b BreakdownBucket) AverageRate() float64 {
+	if b.Consumption == 0 {
+		return 0
+	}
+	return b.EnergyCost / b.Consumption
+}
+
+// Breakdown groups the interval costs and daily standing charges into buckets, using local time in loc.
+// standing may be nil if there are no standing charges.
+//
+// Calendar buckets are returned in time order, and only buckets which have consumption or standing
+// charges are present.
+// Profile buckets are always all returned, starting at midnight or on Monday respectively. When
+// breaking down by hour of day each day's standing charge is spread evenly over its hours.
+func Breakdown(c *Cost, standing *Standing, p BreakdownPeriod, loc *time.Location) ([]BreakdownBucket, error) {
+	if standing == nil {
+		standing = &Standing{}
+	}
+	switch p {
+	case ByHourOfDay:
+		r := make([]BreakdownBucket, 24)
+		for h := range r {
+			r[h].Label = fmt.Sprintf("%02d:00", h)
+		}
+		for _, ic := range c.IntervalCosts {
+			b := &r[ic.Start.In(loc).Hour()]
+			b.Consumption += ic.Consumption
+			b.EnergyCost += ic.Cost
+		}
+		for _, d := range standing.DailyCosts {
+			// Days may be 23 or 25 hours long when the clocks change.
+			perHour := d.Cost / d.End.Sub(d.Start).Hours()
+			for h := d.Start; h.Before(d.End); h = h.Add(time.Hour) {
+				r[h.In(loc).Hour()].StandingCost += perHour
+			}
+		}
+		return r, nil
+	case ByDayOfWeek:
+		r := make([]BreakdownBucket, 7)
+		for d := range r {
+			r[d].Label = time.Weekday((d + 1) % 7).String()
+		}
+		// Monday is first.
+		idx := func(t time.Time) int { return (int(t.In(loc).Weekday()) + 6) % 7 }
+		for _, ic := range c.IntervalCosts {
+			b := &r[idx(ic.Start)]
+			b.Consumption += ic.Consumption
+			b.EnergyCost += ic.Cost
+		}
+		for _, d := range standing.DailyCosts {
+			r[idx(d.Start)].StandingCost += d.Cost
+		}
+		return r, nil
+	case ByDay, ByWeek, ByMonth, ByQuarter:
+	default:
+		return nil, fmt.Errorf("unknown breakdown period %q", p)
+	}
+
+	buckets := map[int64]*BreakdownBucket{}
+	bucket := func(t time.Time) *BreakdownBucket {
+		s, e, l := calendarPeriod(t, p, loc)
+		b, ok := buckets[s.Unix()]
+		if !ok {
+			b = &BreakdownBucket{Label: l, Start: s, End: e}
+			buckets[s.Unix()] = b
+		}
+		return b
+	}
+	for _, ic := range c.IntervalCosts {
+		b := bucket(ic.Start)
+		b.Consumption += ic.Consumption
+		b.EnergyCost += ic.Cost
+	}
+	for _, d := range standing.DailyCosts {
+		bucket(d.Start).StandingCost += d.Cost
+	}
+
+	r := make([]BreakdownBucket, 0, len(buckets))
+	for _, b := range buckets {
+		r = append(r, *b)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Start.Before(r[j].Start) })
+	return r, nil
+}
+
+// calendarPeriod returns the start, end, and label of the calendar period of type p which contains t.
+func calendarPeriod(t time.Time, p BreakdownPeriod, loc *time.Location) (time.Time, time.Time, string) {
+	d := StartOfDay(t, loc)
+	switch p {
+	case ByWeek:
+		s := d.AddDate(0, 0, -((int(d.Weekday()) + 6) % 7))
+		y, w := s.ISOWeek()
+		return s, s.AddDate(0, 0, 7), fmt.Sprintf("%d-W%02d", y, w)
+	case ByMonth:
+		s := time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, loc)
+		return s, s.AddDate(0, 1, 0), s.Format("2006-01")
+	case ByQuarter:
+		q := (int(d.Month()) - 1) / 3
+		s := time.Date(d.Year(), time.Month(q*3+1), 1, 0, 0, 0, 0, loc)
+		return s, s.AddDate(0, 3, 0), fmt.Sprintf("%d-Q%d", d.Year(), q+1)
+	default:
+		return d, d.AddDate(0, 0, 1), d.Format(time.DateOnly)
+	}
+}
@@ -0,0 +1,111 @@
+package octonaut
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBreakdown(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	// Hourly consumption of 1kWh at 10p from Sunday 31st March, the day the clocks go forward,
+	// to Tuesday 2nd April, with a standing charge of 46p/day.
+	from := time.Date(2024, 3, 31, 0, 0, 0, 0, london)
+	to := time.Date(2024, 4, 2, 0, 0, 0, 0, london)
+	c := &Cost{}
+	for s := from; s.Before(to); s = s.Add(time.Hour) {
+		c.IntervalCosts = append(c.IntervalCosts, ConsumptionIntervalCost{
+			ConsumptionInterval: ConsumptionInterval{Start: s, End: s.Add(time.Hour), Consumption: 1},
+			Rate:                10,
+			Cost:                10,
+		})
+	}
+	s := &Standing{}
+	for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+		s.DailyCosts = append(s.DailyCosts, DailyStandingCharge{Start: d, End: d.AddDate(0, 0, 1), Cost: 46})
+	}
+
+	for _, test := range []struct {
+		period       BreakdownPeriod
+		wantLabels   []string
+		wantKWh      []float64
+		wantStanding []float64
+	}{
+		{
+			period:       ByDay,
+			wantLabels:   []string{"2024-03-31", "2024-04-01"},
+			wantKWh:      []float64{23, 24},
+			wantStanding: []float64{46, 46},
+		}, {
+			period:       ByWeek,
+			wantLabels:   []string{"2024-W13", "2024-W14"},
+			wantKWh:      []float64{23, 24},
+			wantStanding: []float64{46, 46},
+		}, {
+			period:       ByMonth,
+			wantLabels:   []string{"2024-03", "2024-04"},
+			wantKWh:      []float64{23, 24},
+			wantStanding: []float64{46, 46},
+		}, {
+			period:       ByQuarter,
+			wantLabels:   []string{"2024-Q1", "2024-Q2"},
+			wantKWh:      []float64{23, 24},
+			wantStanding: []float64{46, 46},
+		},
+	} {
+		t.Run(string(test.period), func(t *testing.T) {
+			bs, err := Breakdown(c, s, test.period, london)
+			if err != nil {
+				t.Fatalf("Breakdown: %v", err)
+			}
+			if got, want := len(bs), len(test.wantLabels); got != want {
+				t.Fatalf("got %d buckets, want %d: %+v", got, want, bs)
+			}
+			for i, b := range bs {
+				if b.Label != test.wantLabels[i] || b.Consumption != test.wantKWh[i] || b.StandingCost != test.wantStanding[i] {
+					t.Errorf("bucket %d: got %s %v kWh %vp standing, want %s %v kWh %vp standing", i, b.Label, b.Consumption, b.StandingCost, test.wantLabels[i], test.wantKWh[i], test.wantStanding[i])
+				}
+				if got := b.AverageRate(); got != 10 {
+					t.Errorf("bucket %d: got average rate %v, want 10", i, got)
+				}
+			}
+		})
+	}
+
+	t.Run("hour", func(t *testing.T) {
+		bs, err := Breakdown(c, s, ByHourOfDay, london)
+		if err != nil {
+			t.Fatalf("Breakdown: %v", err)
+		}
+		if got := len(bs); got != 24 {
+			t.Fatalf("got %d buckets, want 24", got)
+		}
+		// 01:00 doesn't exist on the day the clocks go forward.
+		if got := bs[1].Consumption; got != 1 {
+			t.Errorf("01:00 got %v kWh, want 1", got)
+		}
+		if got := bs[0].Consumption; got != 2 {
+			t.Errorf("00:00 got %v kWh, want 2", got)
+		}
+		total := 0.0
+		for _, b := range bs {
+			total += b.StandingCost
+		}
+		if math.Abs(total-92) > 1e-9 {
+			t.Errorf("got total standing %v, want 92", total)
+		}
+	})
+
+	t.Run("weekday", func(t *testing.T) {
+		bs, err := Breakdown(c, s, ByDayOfWeek, london)
+		if err != nil {
+			t.Fatalf("Breakdown: %v", err)
+		}
+		if bs[0].Label != "Monday" || bs[0].Consumption != 24 || bs[6].Label != "Sunday" || bs[6].Consumption != 23 {
+			t.Errorf("got %+v, want 24kWh on Monday and 23kWh on Sunday", bs)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package octonaut
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// dispatchLevels is the number of discrete steps the battery's usable capacity is divided into
+// when searching for the optimal schedule.
+const dispatchLevels = 100
+
+// OptimalDispatch returns a TransferFunc which charges and discharges the battery so as to minimise the cost
+// of the provided consumption under the given rates.
+//
+// The schedule is planned a day at a time, with days starting at midnight in the given location, as if the
+// rates and consumption for each day were known in advance as is the case for day-ahead tariffs like Agile,
+// using dynamic programming over the battery's state of charge.
+// The battery is never discharged to export to the grid, and like LoadShift it's always discharged as
+// far as it can be to keep consumption within the service limit.
+//
+// The returned TransferFunc must be applied to the same consumption.
+func OptimalDispatch(ctx context.Context, b Battery, cons Consumption, rates RateFn, loc *time.Location) (TransferFunc, *LoadShiftStats, error) {
+	prices := make([]float64, len(cons.Intervals))
+	for i, c := range cons.Intervals {
+		p, err := rates(ctx, c.Start, c.End)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rate for %v: %v", c.Start, err)
+		}
+		prices[i] = p
+	}
+
+	minCharge, maxCharge := b.MinSoC*b.Capacity, b.MaxSoC*b.Capacity
+	step := (maxCharge - minCharge) / dispatchLevels
+
+	// targets holds the planned state of charge at the end of each interval, keyed by interval start.
+	targets := make(map[int64]float64, len(cons.Intervals))
+	level := 0
+	for s := 0; s < len(cons.Intervals); {
+		day := StartOfDay(cons.Intervals[s].Start, loc)
+		e := s
+		for e < len(cons.Intervals) && StartOfDay(cons.Intervals[e].Start, loc).Equal(day) {
+			e++
+		}
+		plan := planDay(b, step, level, cons.Intervals[s:e], prices[s:e])
+		for i, l := range plan {
+			targets[cons.Intervals[s+i].Start.Unix()] = minCharge + float64(l)*step
+		}
+		level = plan[len(plan)-1]
+		s = e
+	}
+
+	target := func(t time.Time) float64 {
+		return targets[t.Unix()]
+	}
+	tf, stats := followSchedule(b, target)
+	return tf, stats, nil
+}
+
+// planDay returns the cost-minimising battery level at the end of each of the intervals, given the level at the start.
+func planDay(b Battery, step float64, start int, intervals []ConsumptionInterval, prices []float64) []int {
+	n := len(intervals)
+	inf := math.Inf(1)
+	// cost[t][l] is the minimum cost of intervals t onwards, starting interval t at level l.
+	cost := make([][]float64, n+1)
+	next := make([][]int, n)
+	cost[n] = make([]float64, dispatchLevels+1)
+	for t := n - 1; t >= 0; t-- {
+		cost[t] = make([]float64, dispatchLevels+1)
+		next[t] = make([]int, dispatchLevels+1)
+		c := intervals[t]
+		hours := float64(c.End.Sub(c.Start)) / float64(time.Hour)
+		limit := inf
+		if b.ServiceLimit > 0 {
+			limit = b.ServiceLimit * hours
+		}
+		maxIn := math.Min(b.ChargeRate*hours, math.Max(0, limit-c.Consumption))
+		maxOut := math.Min(b.DischargeRate*hours, c.Consumption)
+		up, down := 0, 0
+		if step > 0 {
+			up = int(math.Floor(maxIn * b.ChargeEfficiency / step))
+			down = int(math.Floor(maxOut / b.DischargeEfficiency / step))
+		}
+		// Consumption over the service limit must be supplied from the battery, if there's enough charge.
+		over := math.Max(0, c.Consumption-limit)
+		for l := 0; l <= dispatchLevels; l++ {
+			hi := min(dispatchLevels, l+up)
+			if over > 0 && step > 0 {
+				need := math.Min(math.Min(over, b.DischargeRate*hours), float64(l)*step*b.DischargeEfficiency)
+				hi = min(hi, l-min(down, int(math.Ceil(need/b.DischargeEfficiency/step-1e-9))))
+			}
+			best, bestNext := inf, l
+			for j := max(0, l-down); j <= hi; j++ {
+				grid := c.Consumption
+				if d := float64(j-l) * step; d > 0 {
+					grid += d / b.ChargeEfficiency
+				} else {
+					grid += d * b.DischargeEfficiency
+				}
+				if v := prices[t]*grid + cost[t+1][j]; v < best {
+					best, bestNext = v, j
+				}
+			}
+			cost[t][l], next[t][l] = best, bestNext
+		}
+	}
+
+	r := make([]int, n)
+	l := start
+	for t := 0; t < n; t++ {
+		l = next[t][l]
+		r[t] = l
+	}
+	return r
+}
+
+// followSchedule returns a TransferFunc which charges or discharges the battery towards the state of charge
+// returned by target for each interval, within the limits of the battery.
+func followSchedule(b Battery, target func(t time.Time) float64) (TransferFunc, *LoadShiftStats) {
+	minCharge, maxCharge := b.MinSoC*b.Capacity, b.MaxSoC*b.Capacity
+	charge := minCharge
+	stats := &LoadShiftStats{Battery: b}
+
+	tf := func(c ConsumptionInterval) ConsumptionInterval {
+		hours := float64(c.End.Sub(c.Start)) / float64(time.Hour)
+		limit := math.Inf(1)
+		if b.ServiceLimit > 0 {
+			limit = b.ServiceLimit * hours
+		}
+		r := c
+		batteryDelta, losses := float64(0), float64(0)
+		switch t := math.Max(minCharge, math.Min(maxCharge, target(c.Start))); {
+		case t > charge:
+			amt := math.Min(b.ChargeRate*hours, (t-charge)/b.ChargeEfficiency)
+			amt = math.Max(0, math.Min(amt, limit-r.Consumption))
+			batteryDelta = amt
+			losses = amt * (1 - b.ChargeEfficiency)
+			charge += amt * b.ChargeEfficiency
+		case t < charge:
+			out := math.Min(r.Consumption, math.Min(b.DischargeRate*hours, (charge-t)*b.DischargeEfficiency))
+			out = math.Max(0, out)
+			batteryDelta = -out
+			losses = out/b.DischargeEfficiency - out
+			charge -= out / b.DischargeEfficiency
+		}
+		r.Consumption += batteryDelta
+		stats.Intervals = append(stats.Intervals, LoadShiftIntervalStats{
+			BatteryDelta:     batteryDelta,
+			BatteryCharge:    charge,
+			BatteryFull:      charge >= maxCharge,
+			BatteryLosses:    losses,
+			OverServiceLimit: r.Consumption > limit,
+		})
+		return r
+	}
+	return tf, stats
+}
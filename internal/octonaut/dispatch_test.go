@@ -0,0 +1,97 @@
+package octonaut
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestOptimalDispatch(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Two cheap intervals, followed by an expensive, a mid-priced, and a very cheap one.
+	prices := []float64{5, 5, 30, 20, 1}
+	cons := Consumption{}
+	for i := range prices {
+		s := start.Add(time.Duration(i) * 30 * time.Minute)
+		cons.Intervals = append(cons.Intervals, ConsumptionInterval{Start: s, End: s.Add(30 * time.Minute), Consumption: 1})
+	}
+	rates := func(_ context.Context, from, _ time.Time) (float64, error) {
+		return prices[int(from.Sub(start)/(30*time.Minute))], nil
+	}
+
+	b := Battery{Capacity: 2, ChargeRate: 4, DischargeRate: 4, ChargeEfficiency: 1, DischargeEfficiency: 1, MaxSoC: 1}
+	tf, stats, err := OptimalDispatch(ctx, b, cons, rates, time.UTC)
+	if err != nil {
+		t.Fatalf("OptimalDispatch: %v", err)
+	}
+	got := Apply(tf, cons)
+	cost, err := TotalCost(ctx, got, rates)
+	if err != nil {
+		t.Fatalf("TotalCost: %v", err)
+	}
+	// The best plan is to fill the battery while it's cheap and use it for the two most
+	// expensive intervals: 2*5 + 2*5 + 0 + 0 + 1*1.
+	if want := 21.0; math.Abs(cost.TotalCost-want) > 1e-6 {
+		t.Errorf("got cost %f, want %f", cost.TotalCost, want)
+	}
+	if l := len(stats.Intervals); l != len(prices) {
+		t.Errorf("got %d stats intervals, want %d", l, len(prices))
+	}
+
+	// The optimal schedule should never do worse than a fixed charging window.
+	fixed, _ := LoadShift(b, func(t time.Time) bool { return t.Before(start.Add(time.Hour)) })
+	fixedCost, err := TotalCost(ctx, Apply(fixed, cons), rates)
+	if err != nil {
+		t.Fatalf("TotalCost: %v", err)
+	}
+	if fixedCost.TotalCost < cost.TotalCost {
+		t.Errorf("fixed window cost %f is less than optimal cost %f", fixedCost.TotalCost, cost.TotalCost)
+	}
+}
+
+func TestOptimalDispatchServiceLimit(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// The third interval's consumption is over the service limit, but it'd be cheaper to save the battery
+	// for the fourth.
+	prices := []float64{5, 5, 10, 30}
+	usage := []float64{0, 0, 3, 1}
+	cons := Consumption{}
+	for i := range prices {
+		s := start.Add(time.Duration(i) * 30 * time.Minute)
+		cons.Intervals = append(cons.Intervals, ConsumptionInterval{Start: s, End: s.Add(30 * time.Minute), Consumption: usage[i]})
+	}
+	rates := func(_ context.Context, from, _ time.Time) (float64, error) {
+		return prices[int(from.Sub(start)/(30*time.Minute))], nil
+	}
+
+	b := Battery{Capacity: 2, ChargeRate: 4, DischargeRate: 4, ChargeEfficiency: 1, DischargeEfficiency: 1, MaxSoC: 1, ServiceLimit: 2}
+	tf, stats, err := OptimalDispatch(ctx, b, cons, rates, time.UTC)
+	if err != nil {
+		t.Fatalf("OptimalDispatch: %v", err)
+	}
+	cost, err := TotalCost(ctx, Apply(tf, cons), rates)
+	if err != nil {
+		t.Fatalf("TotalCost: %v", err)
+	}
+	// The battery is filled within the limit, and must all be used to bring the third interval within it:
+	// 1*5 + 1*5 + 1*10 + 1*30.
+	if got := stats.Intervals[2]; math.Abs(got.BatteryDelta+2) > 1e-6 || got.OverServiceLimit {
+		t.Errorf("got %+v for the interval over the service limit, want 2 kWh discharged", got)
+	}
+	if want := 50.0; math.Abs(cost.TotalCost-want) > 1e-6 {
+		t.Errorf("got cost %f, want %f", cost.TotalCost, want)
+	}
+
+	// The same constraint applies to a fixed charging window, which should do no better.
+	fixed, _ := LoadShift(b, func(t time.Time) bool { return t.Before(start.Add(time.Hour)) })
+	fixedCost, err := TotalCost(ctx, Apply(fixed, cons), rates)
+	if err != nil {
+		t.Fatalf("TotalCost: %v", err)
+	}
+	if fixedCost.TotalCost < cost.TotalCost {
+		t.Errorf("fixed window cost %f is less than optimal cost %f", fixedCost.TotalCost, cost.TotalCost)
+	}
+}
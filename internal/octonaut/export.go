@@ -0,0 +1,177 @@
+package octonaut
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/parquet-go/parquet-go"
+)
+
+// ExportFormat is a file format which rows can be exported in.
+type ExportFormat string
+
+const (
+	// ExportCSV is RFC 4180 CSV, with a header row.
+	ExportCSV ExportFormat = "csv"
+	// ExportJSONL is JSON Lines, with one object per row.
+	ExportJSONL ExportFormat = "jsonl"
+	// ExportParquet is Apache Parquet, with timestamps stored as nanoseconds since the epoch in UTC.
+	ExportParquet ExportFormat = "parquet"
+)
+
+// ParseExportFormat returns the ExportFormat named by s.
+func ParseExportFormat(s string) (ExportFormat, error) {
+	switch f := ExportFormat(s); f {
+	case ExportCSV, ExportJSONL, ExportParquet:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown export format %q", s)
+}
+
+// The row types below describe the exported data. Columns are named by the json tags, and use the
+// same names in every format.
+
+// ConsumptionRow is the consumption in a single interval.
+type ConsumptionRow struct {
+	IntervalStart  time.Time `json:"interval_start" parquet:"interval_start"`
+	IntervalEnd    time.Time `json:"interval_end" parquet:"interval_end"`
+	ConsumptionKWh float64   `json:"consumption_kwh" parquet:"consumption_kwh"`
+}
+
+// RateRow is a unit rate in pence per kWh, or a standing charge in pence per day, for a period of time.
+type RateRow struct {
+	TariffCode  string     `json:"tariff_code" parquet:"tariff_code"`
+	RateType    string     `json:"rate_type" parquet:"rate_type"`
+	ValidFrom   time.Time  `json:"valid_from" parquet:"valid_from"`
+	ValidTo     *time.Time `json:"valid_to" parquet:"valid_to,optional"`
+	ValueExcVAT float64    `json:"value_exc_vat" parquet:"value_exc_vat"`
+	ValueIncVAT float64    `json:"value_inc_vat" parquet:"value_inc_vat"`
+}
+
+// CostRow is the cost of the consumption in a single interval.
+type CostRow struct {
+	IntervalStart  time.Time `json:"interval_start" parquet:"interval_start"`
+	IntervalEnd    time.Time `json:"interval_end" parquet:"interval_end"`
+	ConsumptionKWh float64   `json:"consumption_kwh" parquet:"consumption_kwh"`
+	RatePence      float64   `json:"rate_pence" parquet:"rate_pence"`
+	CostPence      float64   `json:"cost_pence" parquet:"cost_pence"`
+}
+
+// ConsumptionRows returns a row for each interval of consumption, with times in loc.
+func ConsumptionRows(c Consumption, loc *time.Location) []ConsumptionRow {
+	r := make([]ConsumptionRow, 0, len(c.Intervals))
+	for _, i := range c.Intervals {
+		r = append(r, ConsumptionRow{
+			IntervalStart:  i.Start.In(loc),
+			IntervalEnd:    i.End.In(loc),
+			ConsumptionKWh: i.Consumption,
+		})
+	}
+	return r
+}
+
+// RateRows returns a row for each of the rates, with times in loc.
+func RateRows(tariffCode, rateType string, t octopus.TariffRate, loc *time.Location) []RateRow {
+	r := make([]RateRow, 0, len(t.Results))
+	for _, ri := range t.Results {
+		row := RateRow{
+			TariffCode:  tariffCode,
+			RateType:    rateType,
+			ValidFrom:   ri.ValidFrom.In(loc),
+			ValueExcVAT: ri.ValueExcVat,
+			ValueIncVAT: ri.ValueIncVat,
+		}
+		if ri.ValidTo != nil {
+			to := ri.ValidTo.In(loc)
+			row.ValidTo = &to
+		}
+		r = append(r, row)
+	}
+	return r
+}
+
+// CostRows returns a row for each costed interval, with times in loc.
+func CostRows(c *Cost, loc *time.Location) []CostRow {
+	r := make([]CostRow, 0, len(c.IntervalCosts))
+	for _, ci := range c.IntervalCosts {
+		r = append(r, CostRow{
+			IntervalStart:  ci.Start.In(loc),
+			IntervalEnd:    ci.End.In(loc),
+			ConsumptionKWh: ci.Consumption,
+			RatePence:      ci.Rate,
+			CostPence:      ci.Cost,
+		})
+	}
+	return r
+}
+
+// WriteRows writes the rows to w in the given format. T must be one of the row types above.
+// Timestamps are written in RFC 3339 format, with the offset of their location, to CSV and JSON Lines.
+func WriteRows[T any](w io.Writer, f ExportFormat, rows []T) error {
+	switch f {
+	case ExportCSV:
+		return writeCSVRows(w, rows)
+	case ExportJSONL:
+		enc := json.NewEncoder(w)
+		for _, r := range rows {
+			if err := enc.Encode(r); err != nil {
+				return fmt.Errorf("Encode: %v", err)
+			}
+		}
+		return nil
+	case ExportParquet:
+		if err := parquet.Write(w, rows); err != nil {
+			return fmt.Errorf("parquet.Write: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown export format %q", f)
+}
+
+// writeCSVRows writes the rows as CSV, with a header row naming the columns as the json tags do.
+func writeCSVRows[T any](w io.Writer, rows []T) error {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	header := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		header = append(header, name)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if err := cw.Write(header); err != nil {
+		return fmt.Errorf("writing header: %v", err)
+	}
+	for _, r := range rows {
+		v := reflect.ValueOf(r)
+		rec := make([]string, 0, len(header))
+		for i := 0; i < v.NumField(); i++ {
+			switch f := v.Field(i).Interface().(type) {
+			case time.Time:
+				rec = append(rec, f.Format(time.RFC3339))
+			case *time.Time:
+				if f == nil {
+					rec = append(rec, "")
+				} else {
+					rec = append(rec, f.Format(time.RFC3339))
+				}
+			case float64:
+				rec = append(rec, strconv.FormatFloat(f, 'f', -1, 64))
+			default:
+				rec = append(rec, fmt.Sprint(f))
+			}
+		}
+		if err := cw.Write(rec); err != nil {
+			return fmt.Errorf("writing row: %v", err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,94 @@
+package octonaut
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestWriteRows(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	rows := RateRows("E-1R-AGILE-23-12-06-C", octopus.StandardUnitRates, octopus.TariffRate{
+		Results: []octopus.RateInterval{
+			{ValidFrom: from, ValidTo: &to, ValueExcVat: 20, ValueIncVat: 21},
+			{ValidFrom: to, ValueExcVat: 10.5, ValueIncVat: 11.025},
+		},
+	}, london)
+
+	t.Run("csv", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		if err := WriteRows(b, ExportCSV, rows); err != nil {
+			t.Fatalf("WriteRows: %v", err)
+		}
+		got, err := csv.NewReader(b).ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		want := [][]string{
+			{"tariff_code", "rate_type", "valid_from", "valid_to", "value_exc_vat", "value_inc_vat"},
+			{"E-1R-AGILE-23-12-06-C", "standard-unit-rates", "2024-06-01T01:00:00+01:00", "2024-06-02T01:00:00+01:00", "20", "21"},
+			{"E-1R-AGILE-23-12-06-C", "standard-unit-rates", "2024-06-02T01:00:00+01:00", "", "10.5", "11.025"},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %d rows, want %d: %q", len(got), len(want), got)
+		}
+		for i := range want {
+			if g, w := got[i], want[i]; !slices.Equal(g, w) {
+				t.Errorf("row %d: got %q, want %q", i, g, w)
+			}
+		}
+	})
+
+	t.Run("jsonl", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		if err := WriteRows(b, ExportJSONL, rows); err != nil {
+			t.Fatalf("WriteRows: %v", err)
+		}
+		s := bufio.NewScanner(b)
+		n := 0
+		for ; s.Scan(); n++ {
+			m := map[string]any{}
+			if err := json.Unmarshal(s.Bytes(), &m); err != nil {
+				t.Fatalf("line %d: Unmarshal(%q): %v", n, s.Text(), err)
+			}
+			if got, want := m["valid_from"], rows[n].ValidFrom.Format(time.RFC3339); got != want {
+				t.Errorf("line %d: got valid_from %v, want %v", n, got, want)
+			}
+		}
+		if n != len(rows) {
+			t.Errorf("got %d lines, want %d", n, len(rows))
+		}
+	})
+
+	t.Run("parquet", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		if err := WriteRows(b, ExportParquet, rows); err != nil {
+			t.Fatalf("WriteRows: %v", err)
+		}
+		got, err := parquet.Read[RateRow](bytes.NewReader(b.Bytes()), int64(b.Len()))
+		if err != nil {
+			t.Fatalf("parquet.Read: %v", err)
+		}
+		if len(got) != len(rows) {
+			t.Fatalf("got %d rows, want %d", len(got), len(rows))
+		}
+		for i, w := range rows {
+			g := got[i]
+			if g.TariffCode != w.TariffCode || !g.ValidFrom.Equal(w.ValidFrom) || (g.ValidTo == nil) != (w.ValidTo == nil) || g.ValueIncVAT != w.ValueIncVAT {
+				t.Errorf("row %d: got %+v, want %+v", i, g, w)
+			}
+		}
+	})
+}
@@ -0,0 +1,336 @@
+package octonaut
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+)
+
+// ImportFormat describes the layout of a CSV file of consumption readings.
+type ImportFormat string
+
+const (
+	// ImportOctopus is the CSV downloaded from the Octopus dashboard, with "Consumption (kWh)", "Start",
+	// and "End" columns.
+	ImportOctopus ImportFormat = "octopus"
+	// ImportHomeAssistant is a history download from Home Assistant for a single energy sensor, with
+	// "entity_id", "state", and "last_changed" columns. States are cumulative meter readings in kWh.
+	ImportHomeAssistant ImportFormat = "homeassistant"
+	// ImportGlow is a CSV export from Glow/Bright, with a timestamp column marking the start of each
+	// reading and a kWh column.
+	ImportGlow ImportFormat = "glow"
+	// ImportN3rgy is a CSV export from n3rgy, with "timestamp (UTC)" and "energyConsumption (kWh)"
+	// columns. Timestamps mark the end of each half-hour.
+	ImportN3rgy ImportFormat = "n3rgy"
+)
+
+// ParseImportFormat returns the ImportFormat named by s.
+func ParseImportFormat(s string) (ImportFormat, error) {
+	switch f := ImportFormat(s); f {
+	case ImportOctopus, ImportHomeAssistant, ImportGlow, ImportN3rgy:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown import format %q", s)
+}
+
+// ReadConsumptionCSV parses readings in the given format, in time order. The readings are as
+// they appear in the file, use HalfHourly to align them to half-hours.
+// Timestamps without a zone are taken to be in loc.
+func ReadConsumptionCSV(r io.Reader, f ImportFormat, loc *time.Location) ([]octopus.ConsumptionReading, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no header")
+	}
+	header, rows := rows[0], rows[1:]
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	var rs []octopus.ConsumptionReading
+	switch f {
+	case ImportOctopus:
+		rs, err = readIntervals(header, rows, loc)
+	case ImportHomeAssistant:
+		rs, err = readCumulative(header, rows, loc)
+	case ImportGlow:
+		rs, err = readPoints(header, rows, loc, []string{"timestamp", "time", "date"}, []string{"kwh", "value"}, 0, false)
+	case ImportN3rgy:
+		rs, err = readPoints(header, rows, time.UTC, []string{"timestamp"}, []string{"energyconsumption", "kwh"}, 30*time.Minute, true)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].IntervalStart.Before(rs[j].IntervalStart) })
+	return rs, nil
+}
+
+// readIntervals parses rows which each have the start, end, and consumption of an interval.
+func readIntervals(header []string, rows [][]string, loc *time.Location) ([]octopus.ConsumptionReading, error) {
+	cols, err := columns(header, []string{"consumption"}, []string{"start"}, []string{"end"})
+	if err != nil {
+		return nil, err
+	}
+	rs := []octopus.ConsumptionReading{}
+	for i, row := range rows {
+		if len(row) < len(header) {
+			return nil, fmt.Errorf("row %d: got %d fields, want %d", i+2, len(row), len(header))
+		}
+		kWh, err := strconv.ParseFloat(strings.TrimSpace(row[cols[0]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid consumption: %v", i+2, err)
+		}
+		start, err := parseTimestamp(row[cols[1]], loc)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+2, err)
+		}
+		end, err := parseTimestamp(row[cols[2]], loc)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+2, err)
+		}
+		rs = append(rs, octopus.ConsumptionReading{Consumption: kWh, IntervalStart: start, IntervalEnd: end})
+	}
+	return rs, nil
+}
+
+// readCumulative parses rows of cumulative meter readings, returning the consumption between each
+// pair of readings. Rows whose state isn't a number, e.g. "unavailable", are ignored, as are falls in
+// the reading, which happen when the meter or sensor is reset.
+func readCumulative(header []string, rows [][]string, loc *time.Location) ([]octopus.ConsumptionReading, error) {
+	cols, err := columns(header, []string{"entity_id"}, []string{"state"}, []string{"last_changed", "last_updated"})
+	if err != nil {
+		return nil, err
+	}
+	type point struct {
+		t time.Time
+		v float64
+	}
+	ps := []point{}
+	entity := ""
+	for i, row := range rows {
+		if len(row) < len(header) {
+			return nil, fmt.Errorf("row %d: got %d fields, want %d", i+2, len(row), len(header))
+		}
+		if e := row[cols[0]]; entity == "" {
+			entity = e
+		} else if e != entity {
+			return nil, fmt.Errorf("row %d: found readings for both %s and %s, export a single sensor", i+2, entity, e)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(row[cols[1]]), 64)
+		if err != nil {
+			continue
+		}
+		t, err := parseTimestamp(row[cols[2]], loc)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+2, err)
+		}
+		ps = append(ps, point{t: t, v: v})
+	}
+	sort.SliceStable(ps, func(i, j int) bool { return ps[i].t.Before(ps[j].t) })
+
+	rs := []octopus.ConsumptionReading{}
+	for i := 1; i < len(ps); i++ {
+		prev, cur := ps[i-1], ps[i]
+		if !cur.t.After(prev.t) || cur.v < prev.v {
+			continue
+		}
+		rs = append(rs, octopus.ConsumptionReading{Consumption: cur.v - prev.v, IntervalStart: prev.t, IntervalEnd: cur.t})
+	}
+	return rs, nil
+}
+
+// readPoints parses rows which each have a timestamp and the consumption over the res before it
+// (if atEnd is true) or after it. If res is zero it's taken to be the smallest gap between
+// timestamps, so that gaps in the data aren't smeared across the readings either side.
+func readPoints(header []string, rows [][]string, loc *time.Location, timeCols, valueCols []string, res time.Duration, atEnd bool) ([]octopus.ConsumptionReading, error) {
+	cols, err := columns(header, timeCols, valueCols)
+	if err != nil {
+		return nil, err
+	}
+	rs := []octopus.ConsumptionReading{}
+	for i, row := range rows {
+		if len(row) < len(header) {
+			return nil, fmt.Errorf("row %d: got %d fields, want %d", i+2, len(row), len(header))
+		}
+		t, err := parseTimestamp(row[cols[0]], loc)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+2, err)
+		}
+		kWh, err := strconv.ParseFloat(strings.TrimSpace(row[cols[1]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid consumption: %v", i+2, err)
+		}
+		rs = append(rs, octopus.ConsumptionReading{Consumption: kWh, IntervalStart: t})
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].IntervalStart.Before(rs[j].IntervalStart) })
+
+	if res == 0 && len(rs) < 2 {
+		res = 30 * time.Minute
+	}
+	if res == 0 {
+		for i := 1; i < len(rs); i++ {
+			if d := rs[i].IntervalStart.Sub(rs[i-1].IntervalStart); d > 0 && (res == 0 || d < res) {
+				res = d
+			}
+		}
+		if res == 0 {
+			return nil, fmt.Errorf("all readings have the same timestamp")
+		}
+	}
+	for i := range rs {
+		if atEnd {
+			rs[i].IntervalEnd = rs[i].IntervalStart
+			rs[i].IntervalStart = rs[i].IntervalEnd.Add(-res)
+		} else {
+			rs[i].IntervalEnd = rs[i].IntervalStart.Add(res)
+		}
+	}
+	return rs, nil
+}
+
+// columns returns the index of the header column for each of the wanted columns, which match if the
+// header starts with any of the given names, ignoring case.
+func columns(header []string, want ...[]string) ([]int, error) {
+	r := make([]int, len(want))
+next:
+	for i, names := range want {
+		for j, h := range header {
+			h = strings.ToLower(strings.TrimSpace(h))
+			for _, n := range names {
+				if strings.HasPrefix(h, n) {
+					r[i] = j
+					continue next
+				}
+			}
+		}
+		return nil, fmt.Errorf("no %q column in header %q", names[0], header)
+	}
+	return r, nil
+}
+
+// timestampLayouts are the layouts tried, in order, by parseTimestamp.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"02/01/2006 15:04:05",
+	"02/01/2006 15:04",
+}
+
+// parseTimestamp parses s using the first matching layout in timestampLayouts, using loc if there's no zone.
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, l := range timestampLayouts {
+		if t, err := time.ParseInLocation(l, s, loc); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
+
+// HalfHourly aligns readings of any length to half-hours, splitting longer readings evenly over the
+// half-hours they cover, and adding up shorter ones. Half-hours which aren't entirely covered by the
+// readings, e.g. at the start or end of the data, are dropped. If readings for the same interval
+// appear more than once, the last one is used.
+func HalfHourly(rs []octopus.ConsumptionReading) []octopus.ConsumptionReading {
+	const hh = 30 * time.Minute
+	type interval struct{ start, end int64 }
+	uniq := map[interval]octopus.ConsumptionReading{}
+	for _, r := range rs {
+		uniq[interval{r.IntervalStart.Unix(), r.IntervalEnd.Unix()}] = r
+	}
+
+	kWh, covered := map[int64]float64{}, map[int64]time.Duration{}
+	for _, r := range uniq {
+		d := r.IntervalEnd.Sub(r.IntervalStart)
+		if d <= 0 {
+			continue
+		}
+		for t := r.IntervalStart.Truncate(hh); t.Before(r.IntervalEnd); t = t.Add(hh) {
+			s, e := t, t.Add(hh)
+			if r.IntervalStart.After(s) {
+				s = r.IntervalStart
+			}
+			if r.IntervalEnd.Before(e) {
+				e = r.IntervalEnd
+			}
+			kWh[t.Unix()] += r.Consumption * float64(e.Sub(s)) / float64(d)
+			covered[t.Unix()] += e.Sub(s)
+		}
+	}
+
+	res := []octopus.ConsumptionReading{}
+	for t, c := range covered {
+		if c < hh {
+			continue
+		}
+		start := time.Unix(t, 0).UTC()
+		res = append(res, octopus.ConsumptionReading{
+			Consumption:   math.Round(kWh[t]*1e6) / 1e6,
+			IntervalStart: start,
+			IntervalEnd:   start.Add(hh),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].IntervalStart.Before(res[j].IntervalStart) })
+	return res
+}
+
+// ImportConsumption stores half-hourly electricity import readings from somewhere other than the
+// Octopus API against the given MPAN and meter, and returns how many were stored.
+// Readings for half-hours which already have consumption stored for the MPAN are skipped, unless
+// replace is set in which case they overwrite any stored for the same meter.
+func (o *Octonaut) ImportConsumption(ctx context.Context, mpan, meter string, rs []octopus.ConsumptionReading, replace bool) (int, error) {
+	q := `INSERT INTO Consumption (Account, MPAN, Meter, IntervalStart, IntervalEnd, kWh)
+		SELECT $account, $mpan, $meter, $start, $end, $kWh
+		WHERE NOT EXISTS (SELECT 1 FROM Consumption WHERE Account = $account AND MPAN = $mpan AND IntervalStart = $start)`
+	if replace {
+		q = `INSERT OR REPLACE INTO Consumption (Account, MPAN, Meter, IntervalStart, IntervalEnd, kWh)
+		VALUES($account, $mpan, $meter, $start, $end, $kWh)`
+	}
+
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n := 0
+	for _, r := range rs {
+		res, err := tx.ExecContext(ctx, q,
+			sql.Named("account", o.c.AccountID),
+			sql.Named("mpan", mpan),
+			sql.Named("meter", meter),
+			sql.Named("start", r.IntervalStart.Unix()),
+			sql.Named("end", r.IntervalEnd.Unix()),
+			sql.Named("kWh", r.Consumption))
+		if err != nil {
+			return 0, fmt.Errorf("insert consumption: %v", err)
+		}
+		c, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("RowsAffected: %v", err)
+		}
+		n += int(c)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %v", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,186 @@
+package octonaut
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+)
+
+func TestReadConsumptionCSV(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	hh := 30 * time.Minute
+	for _, test := range []struct {
+		name    string
+		format  ImportFormat
+		csv     string
+		want    []octopus.ConsumptionReading
+		wantErr bool
+	}{
+		{
+			name:   "octopus",
+			format: ImportOctopus,
+			csv: "\ufeffConsumption (kwh), Estimated Cost Inc. Tax (p), Standing Charge Inc. Tax (p), Start, End\n" +
+				"0.5, 12.1, 0, 2024-06-01T00:30:00+00:00, 2024-06-01T01:00:00+00:00\n" +
+				"0.25, 6.2, 0, 2024-06-01T00:00:00+00:00, 2024-06-01T00:30:00+00:00\n",
+			want: []octopus.ConsumptionReading{
+				{Consumption: 0.25, IntervalStart: start, IntervalEnd: start.Add(hh)},
+				{Consumption: 0.5, IntervalStart: start.Add(hh), IntervalEnd: start.Add(2 * hh)},
+			},
+		}, {
+			name:   "home assistant",
+			format: ImportHomeAssistant,
+			csv: "entity_id,state,last_changed\n" +
+				"sensor.energy,100.0,2024-06-01T00:00:00.000Z\n" +
+				"sensor.energy,unavailable,2024-06-01T00:10:00.000Z\n" +
+				"sensor.energy,100.5,2024-06-01T00:30:00.000Z\n" +
+				"sensor.energy,0.1,2024-06-01T00:45:00.000Z\n" +
+				"sensor.energy,0.4,2024-06-01T01:00:00.000Z\n",
+			want: []octopus.ConsumptionReading{
+				{Consumption: 0.5, IntervalStart: start, IntervalEnd: start.Add(hh)},
+				{Consumption: 0.30000000000000004, IntervalStart: start.Add(45 * time.Minute), IntervalEnd: start.Add(2 * hh)},
+			},
+		}, {
+			name:   "home assistant with several sensors",
+			format: ImportHomeAssistant,
+			csv: "entity_id,state,last_changed\n" +
+				"sensor.energy,100.0,2024-06-01T00:00:00.000Z\n" +
+				"sensor.gas,100.5,2024-06-01T00:30:00.000Z\n",
+			wantErr: true,
+		}, {
+			name:   "glow hourly in local time",
+			format: ImportGlow,
+			csv: "Timestamp,kWh\n" +
+				"2024-06-01 01:00:00,1.0\n" +
+				"2024-06-01 03:00:00,2.0\n" +
+				"2024-06-01 02:00:00,1.5\n",
+			want: []octopus.ConsumptionReading{
+				{Consumption: 1, IntervalStart: start, IntervalEnd: start.Add(2 * hh)},
+				{Consumption: 1.5, IntervalStart: start.Add(2 * hh), IntervalEnd: start.Add(4 * hh)},
+				{Consumption: 2, IntervalStart: start.Add(4 * hh), IntervalEnd: start.Add(6 * hh)},
+			},
+		}, {
+			name:   "n3rgy",
+			format: ImportN3rgy,
+			csv: "timestamp (UTC),energyConsumption (kWh)\n" +
+				"2024-06-01 00:30,0.2\n" +
+				"2024-06-01 02:00,0.3\n",
+			want: []octopus.ConsumptionReading{
+				{Consumption: 0.2, IntervalStart: start, IntervalEnd: start.Add(hh)},
+				{Consumption: 0.3, IntervalStart: start.Add(3 * hh), IntervalEnd: start.Add(4 * hh)},
+			},
+		}, {
+			name:    "missing column",
+			format:  ImportOctopus,
+			csv:     "Consumption (kwh), Start\n0.5, 2024-06-01T00:30:00+00:00\n",
+			wantErr: true,
+		}, {
+			name:    "bad timestamp",
+			format:  ImportGlow,
+			csv:     "Timestamp,kWh\nyesterday,1.0\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReadConsumptionCSV(strings.NewReader(test.csv), test.format, london)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("ReadConsumptionCSV: got err %v, want err %t", err, test.wantErr)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d readings, want %d: %+v", len(got), len(test.want), got)
+			}
+			for i, w := range test.want {
+				if g := got[i]; g.Consumption != w.Consumption || !g.IntervalStart.Equal(w.IntervalStart) || !g.IntervalEnd.Equal(w.IntervalEnd) {
+					t.Errorf("reading %d: got %+v, want %+v", i, g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestHalfHourly(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	hh := 30 * time.Minute
+	reading := func(from, to time.Duration, kWh float64) octopus.ConsumptionReading {
+		return octopus.ConsumptionReading{Consumption: kWh, IntervalStart: start.Add(from), IntervalEnd: start.Add(to)}
+	}
+	got := HalfHourly([]octopus.ConsumptionReading{
+		// An hour, which is split across two half-hours.
+		reading(0, 2*hh, 1),
+		// Repeated, e.g. from overlapping downloads.
+		reading(0, 2*hh, 1),
+		// Three ten minute readings, which are added up.
+		reading(2*hh, 2*hh+10*time.Minute, 0.1),
+		reading(2*hh+10*time.Minute, 2*hh+20*time.Minute, 0.2),
+		reading(2*hh+20*time.Minute, 3*hh, 0.3),
+		// A reading straddling half-hours, only the first of which is complete.
+		reading(3*hh, 4*hh+15*time.Minute, 0.6),
+	})
+	want := []float64{0.5, 0.5, 0.6, 0.4}
+	if len(got) != len(want) {
+		t.Fatalf("got %d half-hours, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if g := got[i]; g.Consumption != w || !g.IntervalStart.Equal(start.Add(time.Duration(i)*hh)) || g.IntervalEnd.Sub(g.IntervalStart) != hh {
+			t.Errorf("half-hour %d: got %+v, want %.1f kWh from %v", i, g, w, start.Add(time.Duration(i)*hh))
+		}
+	}
+}
+
+func TestImportConsumption(t *testing.T) {
+	ctx := context.Background()
+	o := newTestOctonaut(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hh := 30 * time.Minute
+
+	// The first two half-hours have been synced from the API.
+	if err := o.insertConsumption(ctx, electricityConsumption, "1000", "API", readings(start, 2, 1)); err != nil {
+		t.Fatalf("insertConsumption: %v", err)
+	}
+
+	imported := readings(start, 4, 2).Results
+	n, err := o.ImportConsumption(ctx, "1000", "CSV", imported, false)
+	if err != nil {
+		t.Fatalf("ImportConsumption: %v", err)
+	}
+	if got, want := n, 2; got != want {
+		t.Errorf("got %d imported, want %d", got, want)
+	}
+	// Importing again is a no-op.
+	if n, err := o.ImportConsumption(ctx, "1000", "CSV", imported, false); err != nil || n != 0 {
+		t.Errorf("ImportConsumption again: got %d, %v, want 0, nil", n, err)
+	}
+
+	c, err := o.Consumption(ctx, "1000", "", start, start.Add(4*hh))
+	if err != nil {
+		t.Fatalf("Consumption: %v", err)
+	}
+	want := []float64{1, 1, 2, 2}
+	if len(c.Intervals) != len(want) {
+		t.Fatalf("got %d intervals, want %d", len(c.Intervals), len(want))
+	}
+	for i, w := range want {
+		if got := c.Intervals[i].Consumption; got != w {
+			t.Errorf("interval %d: got %.1f kWh, want %.1f", i, got, w)
+		}
+	}
+
+	// Replacing only overwrites readings for the same meter.
+	n, err = o.ImportConsumption(ctx, "1000", "API", readings(start, 1, 3).Results, true)
+	if err != nil || n != 1 {
+		t.Fatalf("ImportConsumption(replace): got %d, %v, want 1, nil", n, err)
+	}
+	c, err = o.Consumption(ctx, "1000", "API", start, start.Add(hh))
+	if err != nil {
+		t.Fatalf("Consumption: %v", err)
+	}
+	if got, want := c.Intervals[0].Consumption, 3.0; got != want {
+		t.Errorf("got %.1f kWh after replacing, want %.1f", got, want)
+	}
+}
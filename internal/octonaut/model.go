@@ -1,6 +1,10 @@
 package octonaut
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,12 +19,61 @@ func Apply(tf TransferFunc, c Consumption) Consumption {
 	return r
 }
 
+// Units in which gas meters report their readings.
+const (
+	// GasUnitsM3 is used by SMETS2 meters, which measure the volume of gas.
+	GasUnitsM3 = "m3"
+	// GasUnitsKWh is used by SMETS1 meters, which report the energy content of the gas.
+	GasUnitsKWh = "kWh"
+)
+
+// maxHalfHourlyGasVolume is more gas, in m³, than a domestic boiler burns in half an hour (about 28 kWh), so
+// meters with larger half-hourly readings must be reporting kWh.
+const maxHalfHourlyGasVolume = 2.5
+
+// GasVolumeToKWh returns a TransferFunc which converts gas consumption measured in m³, as reported
+// by SMETS2 meters, into kWh using the given calorific value (in MJ/m³) and volume correction factor.
+func GasVolumeToKWh(calorificValue, correctionFactor float64) TransferFunc {
+	return func(c ConsumptionInterval) ConsumptionInterval {
+		r := c
+		r.Consumption = c.Consumption * correctionFactor * calorificValue / 3.6
+		return r
+	}
+}
+
+// Battery describes a battery energy storage system used for load shifting.
+type Battery struct {
+	// Capacity is the capacity of the battery in kWh.
+	Capacity float64
+	// ChargeRate is the maximum power in kW with which the battery can be charged.
+	ChargeRate float64
+	// DischargeRate is the maximum power in kW which the battery can supply.
+	DischargeRate float64
+	// ChargeEfficiency is the fraction of the energy drawn when charging which is stored in the battery.
+	ChargeEfficiency float64
+	// DischargeEfficiency is the fraction of the stored energy which is delivered when discharging.
+	DischargeEfficiency float64
+	// MinSoC is the fraction of Capacity which is held in reserve, the battery is never discharged below this.
+	MinSoC float64
+	// MaxSoC is the fraction of Capacity to which the battery may be charged.
+	MaxSoC float64
+	// ServiceLimit is the maximum power in kW which may be imported from the grid, or zero if unlimited.
+	ServiceLimit float64
+}
+
+// RoundTripEfficiency returns the fraction of energy drawn from the grid to charge the battery which
+// is delivered back when it's discharged.
+func (b Battery) RoundTripEfficiency() float64 {
+	return b.ChargeEfficiency * b.DischargeEfficiency
+}
+
 type LoadShiftStats struct {
+	Battery   Battery
 	Intervals []LoadShiftIntervalStats
 }
 
 func (l *LoadShiftStats) Headers() []string {
-	return []string{"BatteryCharge", "BatteryDelta", "BatteryFull"}
+	return []string{"BatteryCharge", "BatteryDelta", "BatteryFull", "BatteryLosses", "OverServiceLimit"}
 }
 
 func (l *LoadShiftStats) NumIntervals() int {
@@ -29,48 +82,117 @@ func (l *LoadShiftStats) NumIntervals() int {
 
 func (l *LoadShiftStats) Interval(i int) []any {
 	d := l.Intervals[i]
-	return []any{d.BatteryCharge, d.BatteryDelta, d.BatteryFull}
+	return []any{d.BatteryCharge, d.BatteryDelta, d.BatteryFull, d.BatteryLosses, d.OverServiceLimit}
+}
+
+// TotalLosses returns the total energy in kWh lost to charge/discharge inefficiencies.
+func (l *LoadShiftStats) TotalLosses() float64 {
+	r := float64(0)
+	for _, i := range l.Intervals {
+		r += i.BatteryLosses
+	}
+	return r
 }
 
 type LoadShiftIntervalStats struct {
+	// BatteryCharge is the energy stored in the battery at the end of the interval, in kWh.
 	BatteryCharge float64
-	BatteryDelta  float64
-	BatteryFull   bool
+	// BatteryDelta is the change in grid import caused by the battery during the interval, in kWh.
+	BatteryDelta float64
+	BatteryFull  bool
+	// BatteryLosses is the energy lost to charge/discharge inefficiencies during the interval, in kWh.
+	BatteryLosses float64
+	// OverServiceLimit is true if the battery was unable to keep grid import within the service limit.
+	OverServiceLimit bool
 }
 
-func LoadShift(capacity float64, chargeRate float64, serviceLimit float64, mayCharge func(t time.Time) bool) (TransferFunc, *LoadShiftStats) {
-	charge := float64(0)
-	stats := &LoadShiftStats{}
+// LoadShift returns a TransferFunc which models using the battery to shift consumption into the times at which
+// mayCharge returns true. The battery charges during those times, and supplies consumption otherwise.
+// Regardless of mayCharge, the battery will also discharge if necessary to keep grid import within the
+// service limit.
+func LoadShift(b Battery, mayCharge func(t time.Time) bool) (TransferFunc, *LoadShiftStats) {
+	minCharge, maxCharge := b.MinSoC*b.Capacity, b.MaxSoC*b.Capacity
+	charge := minCharge
+	stats := &LoadShiftStats{Battery: b}
 
 	tf := func(c ConsumptionInterval) ConsumptionInterval {
 		hours := float64(c.End.Sub(c.Start)) / float64(time.Hour)
+		limit := math.Inf(1)
+		if b.ServiceLimit > 0 {
+			limit = b.ServiceLimit * hours
+		}
 		r := c
-		batteryDelta := float64(0)
-		if mayCharge(c.Start) {
-			if charge < capacity {
-				amt := chargeRate * hours
-				space := capacity - charge
-				if amt > space {
-					amt = space
-				}
-				batteryDelta = amt
-			}
-		} else {
-			fromBattery := charge
-			if charge > r.Consumption {
-				fromBattery = r.Consumption
-			}
-			batteryDelta = -fromBattery
+		batteryDelta, losses := float64(0), float64(0)
+		charging := mayCharge(c.Start)
+		if charging && charge < maxCharge {
+			amt := math.Min(b.ChargeRate*hours, (maxCharge-charge)/b.ChargeEfficiency)
+			amt = math.Max(0, math.Min(amt, limit-r.Consumption))
+			batteryDelta = amt
+			losses = amt * (1 - b.ChargeEfficiency)
+			charge += amt * b.ChargeEfficiency
+		}
+		// Supply consumption from the battery outside of charging times, and at any time if
+		// that's necessary to stay within the service limit.
+		want := r.Consumption
+		if charging {
+			want = math.Max(0, r.Consumption-limit)
+		}
+		if batteryDelta == 0 && want > 0 {
+			out := math.Min(want, math.Min(b.DischargeRate*hours, (charge-minCharge)*b.DischargeEfficiency))
+			out = math.Max(0, out)
+			batteryDelta = -out
+			losses = out/b.DischargeEfficiency - out
+			charge -= out / b.DischargeEfficiency
 		}
-		charge += batteryDelta
 		r.Consumption += batteryDelta
 		stats.Intervals = append(stats.Intervals, LoadShiftIntervalStats{
-			BatteryDelta:  batteryDelta,
-			BatteryCharge: charge,
-			BatteryFull:   charge == capacity,
+			BatteryDelta:     batteryDelta,
+			BatteryCharge:    charge,
+			BatteryFull:      charge >= maxCharge,
+			BatteryLosses:    losses,
+			OverServiceLimit: r.Consumption > limit,
 		})
 
 		return r
 	}
 	return tf, stats
 }
+
+// ParseWindow parses a daily time window of the form <hour>-<hour>, e.g. "23.5-4.5" for 23:30 to 04:30, and returns
+// a function which returns true for times which fall within it in the given location.
+func ParseWindow(s string, loc *time.Location) (func(t time.Time) bool, error) {
+	bits := strings.Split(s, "-")
+	if len(bits) != 2 {
+		return nil, fmt.Errorf("invalid strategy format, must be <N>-<M>")
+	}
+	n, err := parseHour(bits[0])
+	if err != nil {
+		return nil, fmt.Errorf("interval start: %v", err)
+	}
+	m, err := parseHour(bits[1])
+	if err != nil {
+		return nil, fmt.Errorf("interval end: %v", err)
+	}
+	return func(t time.Time) bool {
+		t = t.In(loc)
+		h := float64(t.Hour()) + float64(t.Minute())/60.0
+		if n <= m {
+			// range is within a single day, e.g. 5-10
+			return h >= n && h < m
+		} else {
+			// range crosses midnight boundary, e.g. 23-4
+			return h >= n || h < m
+		}
+	}, nil
+}
+
+func parseHour(s string) (float64, error) {
+	i, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 || i >= 24 {
+		return 0, fmt.Errorf("%f should be 0 <= N < 24", i)
+	}
+	return i, nil
+}
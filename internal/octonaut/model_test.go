@@ -0,0 +1,143 @@
+package octonaut
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLoadShift(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Charge during the first two intervals, then discharge during the next two.
+	mayCharge := func(t time.Time) bool { return t.Before(start.Add(time.Hour)) }
+	cons := Consumption{}
+	for i := 0; i < 4; i++ {
+		s := start.Add(time.Duration(i) * 30 * time.Minute)
+		cons.Intervals = append(cons.Intervals, ConsumptionInterval{Start: s, End: s.Add(30 * time.Minute), Consumption: 1})
+	}
+
+	for _, test := range []struct {
+		name        string
+		battery     Battery
+		wantConsume []float64
+		wantCharge  []float64
+		wantOver    []bool
+	}{
+		{
+			name:        "lossless",
+			battery:     Battery{Capacity: 10, ChargeRate: 4, DischargeRate: 4, ChargeEfficiency: 1, DischargeEfficiency: 1, MaxSoC: 1},
+			wantConsume: []float64{3, 3, 0, 0},
+			wantCharge:  []float64{2, 4, 3, 2},
+			wantOver:    []bool{false, false, false, false},
+		}, {
+			name:        "efficiency",
+			battery:     Battery{Capacity: 10, ChargeRate: 4, DischargeRate: 4, ChargeEfficiency: 0.5, DischargeEfficiency: 1, MaxSoC: 1},
+			wantConsume: []float64{3, 3, 0, 0},
+			wantCharge:  []float64{1, 2, 1, 0},
+			wantOver:    []bool{false, false, false, false},
+		}, {
+			name:        "discharge rate",
+			battery:     Battery{Capacity: 10, ChargeRate: 4, DischargeRate: 1, ChargeEfficiency: 1, DischargeEfficiency: 1, MaxSoC: 1},
+			wantConsume: []float64{3, 3, 0.5, 0.5},
+			wantCharge:  []float64{2, 4, 3.5, 3},
+			wantOver:    []bool{false, false, false, false},
+		}, {
+			name:        "state of charge limits",
+			battery:     Battery{Capacity: 10, ChargeRate: 4, DischargeRate: 4, ChargeEfficiency: 1, DischargeEfficiency: 1, MinSoC: 0.1, MaxSoC: 0.3},
+			wantConsume: []float64{3, 1, 0, 0},
+			wantCharge:  []float64{3, 3, 2, 1},
+			wantOver:    []bool{false, false, false, false},
+		}, {
+			name:        "service limit",
+			battery:     Battery{Capacity: 10, ChargeRate: 8, DischargeRate: 4, ChargeEfficiency: 1, DischargeEfficiency: 1, MaxSoC: 1, ServiceLimit: 4},
+			wantConsume: []float64{2, 2, 0, 0},
+			wantCharge:  []float64{1, 2, 1, 0},
+			wantOver:    []bool{false, false, false, false},
+		}, {
+			name:        "service limit exceeded",
+			battery:     Battery{Capacity: 10, ChargeRate: 4, DischargeRate: 4, ChargeEfficiency: 1, DischargeEfficiency: 1, MaxSoC: 1, ServiceLimit: 1},
+			wantConsume: []float64{1, 1, 1, 1},
+			wantCharge:  []float64{0, 0, 0, 0},
+			wantOver:    []bool{true, true, true, true},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tf, stats := LoadShift(test.battery, mayCharge)
+			got := Apply(tf, cons)
+			for i, c := range got.Intervals {
+				if diff := c.Consumption - test.wantConsume[i]; math.Abs(diff) > 1e-9 {
+					t.Errorf("interval %d: got consumption %f, want %f", i, c.Consumption, test.wantConsume[i])
+				}
+				if diff := stats.Intervals[i].BatteryCharge - test.wantCharge[i]; math.Abs(diff) > 1e-9 {
+					t.Errorf("interval %d: got charge %f, want %f", i, stats.Intervals[i].BatteryCharge, test.wantCharge[i])
+				}
+				if got := stats.Intervals[i].OverServiceLimit; got != test.wantOver[i] {
+					t.Errorf("interval %d: got OverServiceLimit %t, want %t", i, got, test.wantOver[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseWindowClockChanges(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	w, err := ParseWindow("23.5-4.5", london)
+	if err != nil {
+		t.Fatalf("ParseWindow: %v", err)
+	}
+	for _, test := range []struct {
+		at   time.Time
+		want bool
+	}{
+		// GMT: local time is UTC.
+		{at: time.Date(2024, 1, 10, 23, 30, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 1, 10, 4, 0, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 1, 10, 4, 30, 0, 0, time.UTC), want: false},
+		{at: time.Date(2024, 1, 10, 23, 0, 0, 0, time.UTC), want: false},
+		// BST: local time is UTC+1.
+		{at: time.Date(2024, 7, 10, 22, 30, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 7, 10, 23, 0, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 7, 10, 3, 0, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 7, 10, 3, 30, 0, 0, time.UTC), want: false},
+		{at: time.Date(2024, 7, 10, 22, 0, 0, 0, time.UTC), want: false},
+		// The night the clocks go back has an extra hour at 01:00 local time.
+		{at: time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 10, 27, 4, 30, 0, 0, time.UTC), want: false},
+		// The night the clocks go forward skips 01:00 local time.
+		{at: time.Date(2024, 3, 31, 0, 30, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 3, 31, 3, 0, 0, 0, time.UTC), want: true},
+		{at: time.Date(2024, 3, 31, 3, 30, 0, 0, time.UTC), want: false},
+	} {
+		if got := w(test.at); got != test.want {
+			t.Errorf("window(%v = %v local) = %t, want %t", test.at, test.at.In(london), got, test.want)
+		}
+	}
+}
+
+func TestGasVolumeToKWh(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name                           string
+		m3, calorificValue, correction float64
+		want                           float64
+	}{
+		{name: "typical", m3: 1, calorificValue: 39.5, correction: 1.02264, want: 11.2206},
+		{name: "no correction", m3: 3.6, calorificValue: 40, correction: 1, want: 40},
+		{name: "no gas", m3: 0, calorificValue: 39.5, correction: 1.02264, want: 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			in := ConsumptionInterval{Start: start, End: start.Add(30 * time.Minute), Consumption: test.m3}
+			got := GasVolumeToKWh(test.calorificValue, test.correction)(in)
+			if math.Abs(got.Consumption-test.want) > 0.0001 {
+				t.Errorf("got %f kWh, want %f", got.Consumption, test.want)
+			}
+			if !got.Start.Equal(in.Start) || !got.End.Equal(in.End) {
+				t.Errorf("got interval %v - %v, want %v - %v", got.Start, got.End, in.Start, in.End)
+			}
+		})
+	}
+}
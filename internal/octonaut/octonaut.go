@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,6 +15,13 @@ import (
 )
 
 type Octonaut struct {
+	// Offline stops the API being used: products and tariffs are read from the local cache, and
+	// tariff rates aren't synced, so only those already stored are used.
+	Offline bool
+	// GasUnits, if set, is recorded as the units reported by each gas meter when syncing, rather than
+	// detecting them from the meter's readings. It must be GasUnitsM3 or GasUnitsKWh.
+	GasUnits string
+
 	c  *octopus.Client
 	db *sql.DB
 
@@ -21,21 +29,26 @@ type Octonaut struct {
 }
 
 func New(ctx context.Context, a, k, ep string, db *sql.DB) (*Octonaut, error) {
-	if !strings.HasSuffix(ep, "/") {
-		ep += "/"
+	return NewWithClient(ctx, &octopus.Client{
+		EndPoint:  ep,
+		AccountID: a,
+		Key:       k,
+	}, db)
+}
+
+// NewWithClient creates a new Octonaut which uses the provided client to talk to the Octopus API.
+func NewWithClient(ctx context.Context, c *octopus.Client, db *sql.DB) (*Octonaut, error) {
+	if !strings.HasSuffix(c.EndPoint, "/") {
+		c.EndPoint += "/"
 	}
 
 	r := &Octonaut{
-		c: &octopus.Client{
-			EndPoint:  ep,
-			AccountID: a,
-			Key:       k,
-		},
+		c:  c,
 		db: db,
 	}
 
-	if err := initDB(ctx, r.db); err != nil {
-		return nil, fmt.Errorf("initDB: %v", err)
+	if err := migrate(ctx, r.db); err != nil {
+		return nil, fmt.Errorf("migrate: %v", err)
 	}
 
 	/*
@@ -48,41 +61,10 @@ func New(ctx context.Context, a, k, ep string, db *sql.DB) (*Octonaut, error) {
 	return r, nil
 }
 
-func initDB(ctx context.Context, db *sql.DB) error {
-	if _, err := db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS Account(
-			Number	string NOT NULL PRIMARY KEY,
-			JSON	string
-		);
-		`); err != nil {
-		return fmt.Errorf("failed to create Account table: %v", err)
-	}
-	if _, err := db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS Consumption(
-			Account			string NOT NULL,
-			MPAN			string NOT NULL,
-			Meter			string NOT NULL,
-			IntervalStart	Timestamp NOT NULL,
-			IntervalEnd		Timestamp,
-			kWh				REAL NOT NULL,
-			PRIMARY KEY (Account, MPAN, Meter, IntervalStart));
-		`); err != nil {
-		return fmt.Errorf("create Consumption table failed: %v", err)
-	}
-	if _, err := db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS TariffRate(
-			Code			string NOT NULL,
-			ValidFrom		Timestamp NOT NULL,
-			ValidTo			Timestamp,
-			UnitCostIncVAT	REAL NOT NULL,
-			PRIMARY KEY (Code, ValidFrom ASC));
-		`); err != nil {
-		return fmt.Errorf("create TariffRate table failed: %v", err)
-	}
-	return nil
-}
-
 func (o Octonaut) Sync(ctx context.Context) error {
+	if o.Offline {
+		return errors.New("can't sync while offline")
+	}
 	log.Infof("Syncing %s", o.c.AccountID)
 	a, err := o.c.Account(ctx)
 	if err != nil {
@@ -95,22 +77,27 @@ func (o Octonaut) Sync(ctx context.Context) error {
 
 	for _, p := range a.Properties {
 		log.Infof(" + Syncing property %d", p.ID)
+		o.syncRegion(ctx, p)
 		for _, em := range p.ElectricityMeterPoints {
-			log.Infof(" | + Syncing MPAN %s", em.MPAN)
+			t := electricityConsumption
+			if em.Export() {
+				log.Infof(" | + Syncing export MPAN %s", em.MPAN)
+				t = exportConsumption
+			} else {
+				log.Infof(" | + Syncing MPAN %s", em.MPAN)
+			}
 			for _, m := range em.Meters {
 				if m.SerialNumber != "" {
-					log.Infof(" | | + Syncing Meter %s", m.SerialNumber)
-					lastReading, err := o.consumptionMostRecent(ctx, em.MPAN, m.SerialNumber)
-					if err != nil {
-						log.Warnf("Error reading local consumption date: %v", err)
-						lastReading = p.MovedInAt
-					}
-					log.Infof(" | | | + Syncing Consumption since %v", lastReading)
-					c, err := o.c.Consumption(ctx, em.MPAN, m.SerialNumber, lastReading, time.Now())
-					log.Infof(" | | | | Got %d records", len(c.Results))
-					if err := o.insertConsumption(ctx, em.MPAN, m.SerialNumber, c); err != nil {
-						log.Warnf("Failed to store consumption data: %v", err)
-					}
+					o.syncMeter(ctx, t, em.MPAN, m.SerialNumber, p.MovedInAt, o.c.Consumption)
+				}
+			}
+		}
+		for _, gm := range p.GasMeterPoints {
+			log.Infof(" | + Syncing MPRN %s", gm.MPRN)
+			for _, m := range gm.Meters {
+				if m.SerialNumber != "" {
+					o.syncMeter(ctx, gasConsumption, gm.MPRN, m.SerialNumber, p.MovedInAt, o.c.GasConsumption)
+					o.syncGasUnits(ctx, gm.MPRN, m.SerialNumber)
 				}
 			}
 		}
@@ -119,6 +106,140 @@ func (o Octonaut) Sync(ctx context.Context) error {
 	return nil
 }
 
+// consumptionTable describes a table which holds readings from meters.
+type consumptionTable struct {
+	// name is the name of the table.
+	name string
+	// point is the name of the column which identifies the meter point.
+	point string
+	// value is the name of the column which holds the consumption reading.
+	value string
+}
+
+var (
+	electricityConsumption = consumptionTable{name: "Consumption", point: "MPAN", value: "kWh"}
+	exportConsumption      = consumptionTable{name: "ExportConsumption", point: "MPAN", value: "kWh"}
+	gasConsumption         = consumptionTable{name: "GasConsumption", point: "MPRN", value: "Reading"}
+)
+
+type consumptionFetcher func(ctx context.Context, point string, serial string, from time.Time, to time.Time) (octopus.Consumption, error)
+
+func (o *Octonaut) syncMeter(ctx context.Context, t consumptionTable, point, serial string, movedInAt time.Time, fetch consumptionFetcher) {
+	log.Infof(" | | + Syncing Meter %s", serial)
+	lastReading, err := o.consumptionMostRecent(ctx, t, point, serial)
+	if err != nil {
+		log.Warnf("Error reading local consumption date: %v", err)
+		lastReading = movedInAt
+	}
+	log.Infof(" | | | + Syncing Consumption since %v", lastReading)
+	c, err := fetch(ctx, point, serial, lastReading, time.Now())
+	if err != nil {
+		log.Warnf("Failed to fetch consumption data: %v", err)
+		return
+	}
+	log.Infof(" | | | | Got %d records", len(c.Results))
+	if err := o.insertConsumption(ctx, t, point, serial, c); err != nil {
+		log.Warnf("Failed to store consumption data: %v", err)
+	}
+}
+
+// syncGasUnits records the units which the gas meter reports its readings in. Unless they're set with
+// GasUnits, they're detected from the stored readings: SMETS1 meters report kWh while SMETS2 meters report
+// m³, and no domestic boiler burns more than maxHalfHourlyGasVolume m³ in half an hour.
+// Units which were previously set are kept, but detected ones are updated as more readings are synced.
+func (o *Octonaut) syncGasUnits(ctx context.Context, mprn, serial string) {
+	units, source := o.GasUnits, "set"
+	if units == "" {
+		_, prev, notFound, err := o.GasMeterUnits(ctx, mprn, serial)
+		if err != nil {
+			log.Warnf("Failed to read gas meter units: %v", err)
+			return
+		}
+		if !notFound && prev == "set" {
+			return
+		}
+		var n int
+		var max sql.NullFloat64
+		if err := o.db.QueryRowContext(ctx, `SELECT COUNT(*), MAX(Reading) FROM GasConsumption WHERE Account = ? AND MPRN = ? AND Meter = ?`, o.c.AccountID, mprn, serial).Scan(&n, &max); err != nil {
+			log.Warnf("Failed to read gas readings: %v", err)
+			return
+		}
+		if n == 0 {
+			return
+		}
+		units, source = GasUnitsM3, "detected"
+		if max.Float64 > maxHalfHourlyGasVolume {
+			units = GasUnitsKWh
+		}
+	}
+	log.Infof(" | | | Meter reports %s (%s)", units, source)
+	if source == "detected" {
+		// Low readings, e.g. from a summer without heating, look like volumes even from a meter reporting kWh.
+		log.Warnf("Guessed that gas meter %s reports %s from its readings, if that's wrong set its units with sync --gas_units=kWh (SMETS1) or --gas_units=m3 (SMETS2)", serial, units)
+	}
+	if _, err := o.db.ExecContext(ctx, `INSERT OR REPLACE INTO GasMeter VALUES(?, ?, ?, ?, ?)`, o.c.AccountID, mprn, serial, units, source); err != nil {
+		log.Warnf("Failed to store gas meter units: %v", err)
+	}
+}
+
+// GasMeterUnits returns the units which the gas meter reports its readings in, either GasUnitsM3 or GasUnitsKWh,
+// and whether they were "set" or "detected" when syncing.
+// The bool is true if the units aren't known, e.g. because the meter hasn't been synced.
+func (o *Octonaut) GasMeterUnits(ctx context.Context, mprn, meter string) (string, string, bool, error) {
+	var units, source string
+	err := o.db.QueryRowContext(ctx, `SELECT Units, Source FROM GasMeter WHERE Account = ? AND MPRN = ? AND Meter = ?`, o.c.AccountID, mprn, meter).Scan(&units, &source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", true, nil
+	}
+	if err != nil {
+		return "", "", false, fmt.Errorf("Scan: %v", err)
+	}
+	return units, source, false, nil
+}
+
+// syncRegion determines the region of the property from its postcode, or failing that the distributor
+// of its import MPAN, and stores it.
+func (o *Octonaut) syncRegion(ctx context.Context, p octopus.Property) {
+	region, source, err := "", "postcode "+p.Postcode, errors.New("property has no postcode")
+	if p.Postcode != "" {
+		region, err = o.c.Region(ctx, p.Postcode)
+	}
+	if err != nil {
+		log.Warnf("Failed to find region from postcode: %v", err)
+		for _, em := range p.ElectricityMeterPoints {
+			if em.Export() || em.MPAN == "" {
+				continue
+			}
+			if region, err = octopus.RegionFromMPAN(em.MPAN); err == nil {
+				source = "MPAN " + em.MPAN
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Warnf("Failed to find region of property %d, use --region to set it: %v", p.ID, err)
+		return
+	}
+	log.Infof(" | Region %s (%s) from %s", region, octopus.Regions[region], source)
+	if _, err := o.db.ExecContext(ctx, `INSERT OR REPLACE INTO PropertyRegion VALUES(?, ?, ?, ?)`, o.c.AccountID, p.ID, region, source); err != nil {
+		log.Warnf("Failed to store region: %v", err)
+	}
+}
+
+// Region returns the region (GSP group) of the property, as used in tariff codes, and where it was found.
+// The bool is true if the region of the property isn't known.
+func (o *Octonaut) Region(ctx context.Context, property int) (string, string, bool, error) {
+	var region, source string
+	err := o.db.QueryRowContext(ctx, `SELECT Region, Source FROM PropertyRegion WHERE Account = ? AND Property = ?`, o.c.AccountID, property).Scan(&region, &source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", true, nil
+	}
+	if err != nil {
+		return "", "", false, fmt.Errorf("Scan: %v", err)
+	}
+	return region, source, false, nil
+}
+
 func (o *Octonaut) upsertAccount(ctx context.Context, a octopus.Account) error {
 	j, err := json.Marshal(a)
 	if err != nil {
@@ -131,7 +252,7 @@ func (o *Octonaut) upsertAccount(ctx context.Context, a octopus.Account) error {
 	return nil
 }
 
-func (o *Octonaut) insertConsumption(ctx context.Context, mpan, serial string, c octopus.Consumption) error {
+func (o *Octonaut) insertConsumption(ctx context.Context, t consumptionTable, point, serial string, c octopus.Consumption) error {
 	tx, err := o.db.Begin()
 	if err != nil {
 		return err
@@ -144,9 +265,9 @@ func (o *Octonaut) insertConsumption(ctx context.Context, mpan, serial string, c
 
 	for _, cr := range c.Results {
 		if _, err := tx.ExecContext(ctx,
-			`INSERT OR REPLACE INTO Consumption VALUES(?, ?, ?, ?, ?, ?)`,
+			fmt.Sprintf(`INSERT OR REPLACE INTO %s VALUES(?, ?, ?, ?, ?, ?)`, t.name),
 			o.account.Number,
-			mpan,
+			point,
 			serial,
 			cr.IntervalStart.Unix(), cr.IntervalEnd.Unix(), cr.Consumption); err != nil {
 			return fmt.Errorf("insert/update account: %v", err)
@@ -156,32 +277,120 @@ func (o *Octonaut) insertConsumption(ctx context.Context, mpan, serial string, c
 	return tx.Commit()
 }
 
-func (o *Octonaut) consumptionMostRecent(ctx context.Context, mpan, serial string) (time.Time, error) {
-	r := o.db.QueryRowContext(ctx, "SELECT datetime(MAX(IntervalStart)) FROM Consumption WHERE Account = ? AND MPAN = ? AND Meter = ? ", o.account.Number, mpan, serial)
-	var start sql.NullTime
+func (o *Octonaut) consumptionMostRecent(ctx context.Context, t consumptionTable, point, serial string) (time.Time, error) {
+	r := o.db.QueryRowContext(ctx, fmt.Sprintf("SELECT MAX(IntervalStart) FROM %s WHERE Account = ? AND %s = ? AND Meter = ? ", t.name, t.point), o.account.Number, point, serial)
+	var start sql.NullInt64
 	if err := r.Scan(&start); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return time.Time{}, nil
 		}
-		return time.Time{}, fmt.Errorf("failed to scan latest Consumption.at: %v", err)
+		return time.Time{}, fmt.Errorf("failed to scan latest %s.at: %v", t.name, err)
+	}
+	if !start.Valid {
+		return time.Time{}, nil
 	}
-	return start.Time, nil
+	return time.Unix(start.Int64, 0).UTC(), nil
 }
 
+// SyncTariff fetches and stores the unit rates and standing charges for the given electricity or gas tariff
+// between from and to. Both day and night unit rates are fetched for two register (2R) tariffs.
+// Nothing is fetched when offline.
 func (o *Octonaut) SyncTariff(ctx context.Context, product, tariffCode string, from time.Time, to time.Time) error {
-	t, err := o.c.TariffRates(ctx, product, "electricity", tariffCode, "standard-unit-rates", from, to)
+	if o.Offline {
+		log.Debugf("Offline, using stored rates for %s", tariffCode)
+		return nil
+	}
+	f, registers, _, _, err := octopus.ParseTariffCode(tariffCode)
 	if err != nil {
-		return fmt.Errorf("TariffRates: %v", err)
+		return err
+	}
+	fuel := "electricity"
+	if f == "G" {
+		fuel = "gas"
+	}
+	rateTypes := []string{octopus.StandardUnitRates}
+	if registers == "2R" {
+		rateTypes = []string{octopus.DayUnitRates, octopus.NightUnitRates}
+	}
+	for _, rt := range rateTypes {
+		t, err := o.c.TariffRates(ctx, product, fuel, tariffCode, rt, from, to)
+		if err != nil {
+			return fmt.Errorf("TariffRates(%s): %w", rt, err)
+		}
+
+		// Fixed and variable tariffs may have different prices depending on how the customer pays,
+		// which would otherwise overlap.
+		if err := o.upsertTariff(ctx, tariffCode, rt, t.ForPaymentMethod(octopus.DirectDebit)); err != nil {
+			return fmt.Errorf("Upsert: %v", err)
+		}
 	}
 
-	if err := o.upsertTariff(ctx, tariffCode, t); err != nil {
-		return fmt.Errorf("Upsert: %v", err)
+	if octopus.IsExportTariff(product) {
+		// Export tariffs don't have standing charges.
+		return nil
+	}
+	sc, err := o.c.StandingCharges(ctx, product, fuel, tariffCode, from, to)
+	if err != nil {
+		return fmt.Errorf("StandingCharges: %w", err)
+	}
+	if err := o.upsertStandingCharges(ctx, tariffCode, sc.ForPaymentMethod(octopus.DirectDebit)); err != nil {
+		return fmt.Errorf("Upsert standing charges: %v", err)
 	}
 
 	return nil
 }
 
-func (o *Octonaut) upsertTariff(ctx context.Context, tariffCode string, t octopus.TariffRate) error {
+// ResolveTariff returns the tariff which the product offers for the fuel ("E" or "G"), register type ("1R"
+// or "2R"), region and payment method (e.g. octopus.Prepayment), preferring direct debit prices if
+// paymentMethod is empty.
+// The error wraps octopus.ErrNotOffered if the product doesn't offer such a tariff.
+func (o *Octonaut) ResolveTariff(ctx context.Context, product, fuel, registers, region, paymentMethod string) (*octopus.Tariff, error) {
+	p, err := o.Product(ctx, product)
+	if err != nil {
+		return nil, fmt.Errorf("Product(%s): %w", product, err)
+	}
+	return p.Tariff(fuel, registers, region, paymentMethod)
+}
+
+// SyncResolvedTariff fetches and stores the unit rates and standing charges of a tariff returned by
+// ResolveTariff between from and to, using the links in its details. Only the rates for the tariff's
+// payment method are stored, e.g. the non direct debit ones for prepayment tariffs.
+// Nothing is fetched when offline.
+func (o *Octonaut) SyncResolvedTariff(ctx context.Context, t *octopus.Tariff, from time.Time, to time.Time) error {
+	if o.Offline {
+		log.Debugf("Offline, using stored rates for %s", t.Code)
+		return nil
+	}
+	rateTypes := t.UnitRateTypes()
+	if len(rateTypes) == 0 {
+		return fmt.Errorf("tariff %s has no unit rates", t.Code)
+	}
+	for _, rt := range rateTypes {
+		r, err := o.c.LinkedRates(ctx, t.Link(rt), from, to)
+		if err != nil {
+			return fmt.Errorf("LinkedRates(%s): %w", rt, err)
+		}
+		if err := o.upsertTariff(ctx, t.Code, rt, r.ForPaymentMethod(t.RatePaymentMethod())); err != nil {
+			return fmt.Errorf("Upsert: %v", err)
+		}
+	}
+
+	// Export tariffs don't have standing charges.
+	l := t.Link(octopus.StandingCharges)
+	if l == "" {
+		return nil
+	}
+	sc, err := o.c.LinkedRates(ctx, l, from, to)
+	if err != nil {
+		return fmt.Errorf("LinkedRates(%s): %w", octopus.StandingCharges, err)
+	}
+	if err := o.upsertStandingCharges(ctx, t.Code, sc.ForPaymentMethod(t.RatePaymentMethod())); err != nil {
+		return fmt.Errorf("Upsert standing charges: %v", err)
+	}
+	return nil
+}
+
+func (o *Octonaut) upsertStandingCharges(ctx context.Context, tariffCode string, t octopus.TariffRate) error {
 	tx, err := o.db.Begin()
 	if err != nil {
 		return err
@@ -193,9 +402,41 @@ func (o *Octonaut) upsertTariff(ctx context.Context, tariffCode string, t octopu
 	}()
 
 	for _, r := range t.Results {
+		// Current standing charges are usually open-ended, so store a NULL ValidTo for those.
+		var validTo any
+		if r.ValidTo != nil {
+			validTo = r.ValidTo.Unix()
+		}
 		if _, err := tx.ExecContext(ctx,
-			`INSERT OR REPLACE INTO TariffRate VALUES(?, ?, ?, ?)`,
-			tariffCode, r.ValidFrom.Unix(), r.ValidTo.Unix(), r.ValueIncVat); err != nil {
+			`INSERT OR REPLACE INTO StandingCharge (Code, ValidFrom, ValidTo, DailyCostIncVAT, DailyCostExcVAT) VALUES(?, ?, ?, ?, ?)`,
+			tariffCode, r.ValidFrom.Unix(), validTo, r.ValueIncVat, r.ValueExcVat); err != nil {
+			return fmt.Errorf("insert/update standingcharge: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (o *Octonaut) upsertTariff(ctx context.Context, tariffCode, rateType string, t octopus.TariffRate) error {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, r := range t.Results {
+		// Fixed and Tracker tariffs' current rates may be open-ended, so store a NULL ValidTo for those.
+		var validTo any
+		if r.ValidTo != nil {
+			validTo = r.ValidTo.Unix()
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT OR REPLACE INTO TariffRate (Code, RateType, ValidFrom, ValidTo, UnitCostIncVAT, UnitCostExcVAT) VALUES(?, ?, ?, ?, ?, ?)`,
+			tariffCode, rateType, r.ValidFrom.Unix(), validTo, r.ValueIncVat, r.ValueExcVat); err != nil {
 			return fmt.Errorf("insert/update tariffrate: %v", err)
 		}
 	}
@@ -203,6 +444,14 @@ func (o *Octonaut) upsertTariff(ctx context.Context, tariffCode string, t octopu
 	return tx.Commit()
 }
 
+// nullTime returns a pointer to the time, or nil if it's NULL.
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
 func (o *Octonaut) Account(ctx context.Context) (*octopus.Account, bool, error) {
 	r := o.db.QueryRowContext(ctx, "SELECT JSON from Account WHERE Number = ?", o.c.AccountID)
 	var j []byte
@@ -219,7 +468,7 @@ func (o *Octonaut) Account(ctx context.Context) (*octopus.Account, bool, error)
 	// Now check for invalid meters and remove them
 	for pi, p := range a.Properties {
 		fem := []octopus.ElectricityMeterPoint{}
-		for ei, em := range p.ElectricityMeterPoints {
+		for _, em := range p.ElectricityMeterPoints {
 			if em.MPAN == "" {
 				continue
 			}
@@ -231,39 +480,40 @@ func (o *Octonaut) Account(ctx context.Context) (*octopus.Account, bool, error)
 				fms = append(fms, m)
 			}
 			if len(fms) > 0 {
-				a.Properties[pi].ElectricityMeterPoints[ei].Meters = fms
+				em.Meters = fms
 				fem = append(fem, em)
 			}
 		}
+		a.Properties[pi].ElectricityMeterPoints = fem
 	}
 	return a, false, nil
 }
 
-func (o *Octonaut) Products(ctx context.Context, at *time.Time) (octopus.Products, error) {
-	return o.c.Products(ctx, at)
-}
-
-func (o *Octonaut) TariffRates(ctx context.Context, tariffCode string, from, to time.Time) (*octopus.TariffRate, error) {
+// TariffRates returns the locally stored unit rates of the given type (e.g. octopus.StandardUnitRates) for
+// the tariff code between from and to.
+func (o *Octonaut) TariffRates(ctx context.Context, tariffCode, rateType string, from, to time.Time) (*octopus.TariffRate, error) {
 	r := octopus.TariffRate{}
 	q := `
-		SELECT ValidFrom, ValidTo, UnitCostIncVAT FROM TariffRate WHERE Code = $code AND ValidFrom <= $from AND ValidTo >= $from
+		SELECT ValidFrom, ValidTo, UnitCostIncVAT, UnitCostExcVAT FROM TariffRate WHERE Code = $code AND RateType = $type AND ValidFrom <= $from AND (ValidTo IS NULL OR ValidTo >= $from)
 		UNION
-		SELECT ValidFrom, ValidTo, UnitCostIncVAT FROM TariffRate WHERE Code = $code AND ValidFrom > $from AND ValidFrom <= $to
+		SELECT ValidFrom, ValidTo, UnitCostIncVAT, UnitCostExcVAT FROM TariffRate WHERE Code = $code AND RateType = $type AND ValidFrom > $from AND ValidFrom <= $to
 		ORDER BY ValidFrom ASC`
 	args := []any{
 		sql.Named("code", tariffCode),
+		sql.Named("type", rateType),
 		sql.Named("from", from.Unix()),
 		sql.Named("to", to.Unix())}
 	rows, err := o.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil, fmt.Errorf("QueryContext: %v", err)
 	}
+	defer rows.Close()
 	var last *time.Time
 	for rows.Next() {
 		var start time.Time
 		var end sql.NullTime
-		var k float64
-		if err := rows.Scan(&start, &end, &k); err != nil {
+		var inc, exc float64
+		if err := rows.Scan(&start, &end, &inc, &exc); err != nil {
 			return nil, fmt.Errorf("Scan: %v", err)
 		}
 
@@ -272,11 +522,18 @@ func (o *Octonaut) TariffRates(ctx context.Context, tariffCode string, from, to
 		}
 		r.Results = append(r.Results, octopus.RateInterval{
 			ValidFrom:   start,
-			ValidTo:     end.Time,
-			ValueIncVat: k,
+			ValidTo:     nullTime(end),
+			ValueIncVat: inc,
+			ValueExcVat: exc,
 		})
 		from = start
-		last = &(end.Time)
+		last = nil
+		if end.Valid {
+			last = &(end.Time)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 	if len(r.Results) == 0 {
 		return nil, errors.New("no data")
@@ -285,16 +542,169 @@ func (o *Octonaut) TariffRates(ctx context.Context, tariffCode string, from, to
 	return &r, nil
 }
 
+// StandingCharges returns the locally stored daily standing charges for the given tariff code which
+// were valid at any point between from and to.
+func (o *Octonaut) StandingCharges(ctx context.Context, tariffCode string, from, to time.Time) (*octopus.TariffRate, error) {
+	r := octopus.TariffRate{}
+	q := `
+		SELECT ValidFrom, ValidTo, DailyCostIncVAT, DailyCostExcVAT FROM StandingCharge WHERE Code = $code AND ValidFrom <= $to AND (ValidTo IS NULL OR ValidTo > $from)
+		ORDER BY ValidFrom ASC`
+	args := []any{
+		sql.Named("code", tariffCode),
+		sql.Named("from", from.Unix()),
+		sql.Named("to", to.Unix())}
+	rows, err := o.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var start time.Time
+		var end sql.NullTime
+		var inc, exc float64
+		if err := rows.Scan(&start, &end, &inc, &exc); err != nil {
+			return nil, fmt.Errorf("Scan: %v", err)
+		}
+		r.Results = append(r.Results, octopus.RateInterval{
+			ValidFrom:   start,
+			ValidTo:     nullTime(end),
+			ValueIncVat: inc,
+			ValueExcVat: exc,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.Results) == 0 {
+		return nil, errors.New("no data")
+	}
+	log.Infof("%d StandingCharges", len(r.Results))
+	return &r, nil
+}
+
+// Consumption returns the locally stored electricity consumption for the given MPAN and meter, for the
+// intervals which overlap the half-open period [from, to), so that the interval starting at to is excluded.
+// If meter is empty, readings from all of the meters which have been installed on the MPAN are
+// stitched together.
 func (o *Octonaut) Consumption(ctx context.Context, mpan, meter string, from time.Time, to time.Time) (Consumption, error) {
+	return o.consumption(ctx, electricityConsumption, mpan, meter, from, to)
+}
+
+// ConsumptionSource identifies where consumption for a period of time should be taken from.
+type ConsumptionSource struct {
+	Property int
+	MPAN     string
+	// Meter is the serial number of the meter to use, or empty to use all meters on the MPAN.
+	Meter string
+	From  time.Time
+	// To is the end of the period, or the zero time if the period is ongoing.
+	To time.Time
+}
+
+// ImportSources returns the sources of electricity import consumption over the history of the account, in
+// time order, using the dates on which each property was moved into and out of. Only the first import MPAN
+// of each property is used.
+func ImportSources(a *octopus.Account) []ConsumptionSource {
+	return importSources(a, "")
+}
+
+// MPANSources returns the sources of electricity import consumption from the given MPAN, in time order,
+// using the dates on which each property it's found on was moved into and out of.
+func MPANSources(a *octopus.Account, mpan string) []ConsumptionSource {
+	return importSources(a, mpan)
+}
+
+// importSources returns the sources of import consumption from the given MPAN, or from the first import
+// MPAN of each property if mpan is empty.
+func importSources(a *octopus.Account, mpan string) []ConsumptionSource {
+	ps := append([]octopus.Property{}, a.Properties...)
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].MovedInAt.Before(ps[j].MovedInAt)
+	})
+	r := []ConsumptionSource{}
+	for _, p := range ps {
+		for _, em := range p.ElectricityMeterPoints {
+			if em.MPAN == "" || em.Export() || (mpan != "" && em.MPAN != mpan) {
+				continue
+			}
+			s := ConsumptionSource{
+				Property: p.ID,
+				MPAN:     em.MPAN,
+				From:     p.MovedInAt,
+			}
+			if p.MovedOutAt != nil {
+				s.To = *p.MovedOutAt
+			}
+			r = append(r, s)
+			if mpan == "" {
+				break
+			}
+		}
+	}
+	return r
+}
+
+// StitchedConsumption returns the electricity consumption for the half-open period [from, to), taken from
+// each of the sources in turn for the period it covers.
+func (o *Octonaut) StitchedConsumption(ctx context.Context, sources []ConsumptionSource, from time.Time, to time.Time) (Consumption, error) {
 	r := Consumption{}
-	q := `
-		SELECT IntervalStart, IntervalEnd, kWh FROM Consumption WHERE Account = $account AND MPAN = $mpan AND Meter = $meter AND IntervalStart <= $from AND IntervalEnd > $from
-		UNION
-		SELECT IntervalStart, IntervalEnd, kWh FROM Consumption WHERE Account = $account AND MPAN = $mpan AND Meter = $meter AND IntervalStart > $from AND IntervalStart <= $to
-		ORDER BY IntervalStart ASC`
+	for _, s := range sources {
+		f, t := from, to
+		if s.From.After(f) {
+			f = s.From
+		}
+		if !s.To.IsZero() && !s.To.After(t) {
+			t = s.To
+		}
+		if !f.Before(t) {
+			continue
+		}
+		c, err := o.Consumption(ctx, s.MPAN, s.Meter, f, t)
+		if err != nil {
+			log.Warnf("No consumption for MPAN %s between %v and %v: %v", s.MPAN, f, t, err)
+			continue
+		}
+		for _, i := range c.Intervals {
+			// Skip intervals which overlap with those from the previous source.
+			if l := len(r.Intervals); l > 0 && i.Start.Before(r.Intervals[l-1].End) {
+				continue
+			}
+			r.Intervals = append(r.Intervals, i)
+		}
+	}
+	if len(r.Intervals) == 0 {
+		return r, errors.New("no data")
+	}
+	return r, nil
+}
+
+// ExportConsumption returns the locally stored readings of electricity exported via the given meter, for
+// the half-open period [from, to). If meter is empty, readings from all of the meters which have been
+// installed on the MPAN are stitched together.
+func (o *Octonaut) ExportConsumption(ctx context.Context, mpan, meter string, from time.Time, to time.Time) (Consumption, error) {
+	return o.consumption(ctx, exportConsumption, mpan, meter, from, to)
+}
+
+// GasConsumption returns the locally stored readings from the given gas meter for the half-open period
+// [from, to), in the units reported by the meter, see GasMeterUnits.
+func (o *Octonaut) GasConsumption(ctx context.Context, mprn, meter string, from time.Time, to time.Time) (Consumption, error) {
+	return o.consumption(ctx, gasConsumption, mprn, meter, from, to)
+}
+
+func (o *Octonaut) consumption(ctx context.Context, t consumptionTable, point, meter string, from time.Time, to time.Time) (Consumption, error) {
+	r := Consumption{}
+	// The period is half-open, like the rates it'll be priced with: rates synced up to to don't cover the
+	// interval starting at to, and adjacent periods mustn't both include it.
+	// When stitching together readings from all meters on a meter point, prefer the largest reading
+	// for each interval since an old meter may continue to report zeros after it's been replaced.
+	q := fmt.Sprintf(`
+		SELECT IntervalStart, IntervalEnd, MAX(%[3]s) FROM %[1]s
+		WHERE Account = $account AND %[2]s = $point AND ($meter = '' OR Meter = $meter) AND IntervalEnd > $from AND IntervalStart < $to
+		GROUP BY IntervalStart
+		ORDER BY IntervalStart ASC`, t.name, t.point, t.value)
 	args := []any{
 		sql.Named("account", o.c.AccountID),
-		sql.Named("mpan", mpan),
+		sql.Named("point", point),
 		sql.Named("meter", meter),
 		sql.Named("from", from.Unix()),
 		sql.Named("to", to.Unix())}
@@ -302,6 +712,7 @@ func (o *Octonaut) Consumption(ctx context.Context, mpan, meter string, from tim
 	if err != nil {
 		return r, fmt.Errorf("QueryContext: %v", err)
 	}
+	defer rows.Close()
 	var last *time.Time
 	for rows.Next() {
 		var start time.Time
@@ -329,6 +740,9 @@ func (o *Octonaut) Consumption(ctx context.Context, mpan, meter string, from tim
 		})
 		last = &(end.Time)
 	}
+	if err := rows.Err(); err != nil {
+		return r, err
+	}
 	if len(r.Intervals) == 0 {
 		return r, errors.New("no data")
 	}
@@ -0,0 +1,511 @@
+package octonaut
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/AlCutter/octonaut/internal/octopus/fake"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestOctonaut(t *testing.T) *Octonaut {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "octonaut.sqlite3"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	o, err := New(context.Background(), "A-1234", "key", "http://localhost/", db)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	o.account = &octopus.Account{Number: "A-1234"}
+	return o
+}
+
+// readings returns consumption with n half-hourly readings of kWh starting at start.
+func readings(start time.Time, n int, kWh float64) octopus.Consumption {
+	r := octopus.Consumption{}
+	for i := 0; i < n; i++ {
+		s := start.Add(time.Duration(i) * 30 * time.Minute)
+		r.Results = append(r.Results, octopus.ConsumptionReading{Consumption: kWh, IntervalStart: s, IntervalEnd: s.Add(30 * time.Minute)})
+	}
+	return r
+}
+
+func TestStitchedConsumption(t *testing.T) {
+	ctx := context.Background()
+	o := newTestOctonaut(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hh := 30 * time.Minute
+	movedOut := start.Add(4 * hh)
+
+	// The first property had its meter exchanged, with the old meter reporting zeros afterwards.
+	for _, r := range []struct {
+		mpan, meter string
+		start       time.Time
+		n           int
+		kWh         float64
+	}{
+		{mpan: "1000", meter: "OLD", start: start, n: 2, kWh: 1},
+		{mpan: "1000", meter: "OLD", start: start.Add(2 * hh), n: 2, kWh: 0},
+		{mpan: "1000", meter: "NEW", start: start.Add(2 * hh), n: 3, kWh: 2},
+		{mpan: "2000", meter: "M2", start: movedOut, n: 2, kWh: 3},
+	} {
+		if err := o.insertConsumption(ctx, electricityConsumption, r.mpan, r.meter, readings(r.start, r.n, r.kWh)); err != nil {
+			t.Fatalf("insertConsumption: %v", err)
+		}
+	}
+
+	a := &octopus.Account{
+		Properties: []octopus.Property{
+			{
+				ID:                     2,
+				MovedInAt:              movedOut,
+				ElectricityMeterPoints: []octopus.ElectricityMeterPoint{{MPAN: "2000"}},
+			}, {
+				ID:         1,
+				MovedInAt:  start,
+				MovedOutAt: &movedOut,
+				ElectricityMeterPoints: []octopus.ElectricityMeterPoint{
+					{MPAN: "9000", IsExport: true},
+					{MPAN: "1000"},
+					{MPAN: "1001"},
+				},
+			},
+		},
+	}
+	sources := ImportSources(a)
+	if got, want := len(sources), 2; got != want {
+		t.Fatalf("got %d sources, want %d", got, want)
+	}
+	if sources[0].MPAN != "1000" || sources[1].MPAN != "2000" {
+		t.Fatalf("got sources %+v, want MPANs 1000 then 2000", sources)
+	}
+	// Any of a property's import MPANs may be selected.
+	if got := MPANSources(a, "1001"); len(got) != 1 || got[0].Property != 1 || !got[0].From.Equal(start) || !got[0].To.Equal(movedOut) {
+		t.Errorf("MPANSources(1001): got %+v, want property 1 from %v to %v", got, start, movedOut)
+	}
+
+	c, err := o.StitchedConsumption(ctx, sources, start, start.Add(6*hh))
+	if err != nil {
+		t.Fatalf("StitchedConsumption: %v", err)
+	}
+	want := []float64{1, 1, 2, 2, 3, 3}
+	if got := len(c.Intervals); got != len(want) {
+		t.Fatalf("got %d intervals, want %d: %+v", got, len(want), c.Intervals)
+	}
+	for i, w := range want {
+		if got := c.Intervals[i]; got.Consumption != w || !got.Start.Equal(start.Add(time.Duration(i)*hh)) {
+			t.Errorf("interval %d: got %v kWh at %v, want %v kWh at %v", i, got.Consumption, got.Start, w, start.Add(time.Duration(i)*hh))
+		}
+	}
+}
+
+func TestConsumptionHalfOpen(t *testing.T) {
+	ctx := context.Background()
+	o := newTestOctonaut(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hh := 30 * time.Minute
+	if err := o.insertConsumption(ctx, electricityConsumption, "1000", "M1", readings(start, 4, 1)); err != nil {
+		t.Fatalf("insertConsumption: %v", err)
+	}
+
+	// Adjacent periods share their boundary, but the interval starting there belongs only to the second.
+	for _, test := range []struct {
+		from, to   time.Time
+		wantStarts []time.Time
+	}{
+		{from: start, to: start.Add(2 * hh), wantStarts: []time.Time{start, start.Add(hh)}},
+		{from: start.Add(2 * hh), to: start.Add(4 * hh), wantStarts: []time.Time{start.Add(2 * hh), start.Add(3 * hh)}},
+	} {
+		c, err := o.Consumption(ctx, "1000", "M1", test.from, test.to)
+		if err != nil {
+			t.Fatalf("Consumption(%v, %v): %v", test.from, test.to, err)
+		}
+		var got []time.Time
+		for _, i := range c.Intervals {
+			got = append(got, i.Start)
+		}
+		if !slices.EqualFunc(got, test.wantStarts, time.Time.Equal) {
+			t.Errorf("Consumption(%v, %v): got intervals starting %v, want %v", test.from, test.to, got, test.wantStarts)
+		}
+	}
+}
+
+func TestStandingChargeChanges(t *testing.T) {
+	ctx := context.Background()
+	o := newTestOctonaut(t)
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	jan1, jan3 := time.Date(2024, 1, 1, 0, 0, 0, 0, london), time.Date(2024, 1, 3, 0, 0, 0, 0, london)
+	// The charge goes up from the 3rd, with the new one being open-ended.
+	if err := o.upsertStandingCharges(ctx, "E-1R-TEST-C", octopus.TariffRate{Results: []octopus.RateInterval{
+		{ValidFrom: jan1, ValidTo: &jan3, ValueExcVat: 50, ValueIncVat: 52.5},
+		{ValidFrom: jan3, ValueExcVat: 60, ValueIncVat: 63},
+	}}); err != nil {
+		t.Fatalf("upsertStandingCharges: %v", err)
+	}
+
+	// Midday on the 1st isn't the start of a day, so the first day charged is the 2nd.
+	from, to := jan1.Add(12*time.Hour), time.Date(2024, 1, 5, 0, 0, 0, 0, london)
+	sc, err := o.StandingCharges(ctx, "E-1R-TEST-C", from, to)
+	if err != nil {
+		t.Fatalf("StandingCharges: %v", err)
+	}
+	if got, want := len(sc.Results), 2; got != want {
+		t.Fatalf("got %d standing charges, want %d", got, want)
+	}
+	s, err := StandingCost(ctx, from, to, london, TariffVAT(*sc, VATExclusive))
+	if err != nil {
+		t.Fatalf("StandingCost: %v", err)
+	}
+	want := []float64{50, 60, 60}
+	got := []float64{}
+	for _, d := range s.DailyCosts {
+		got = append(got, d.Cost)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got daily charges %v, want %v", got, want)
+	}
+	if !s.DailyCosts[0].Start.Equal(jan1.AddDate(0, 0, 1)) {
+		t.Errorf("first day charged starts at %v, want %v", s.DailyCosts[0].Start, jan1.AddDate(0, 0, 1))
+	}
+	if got, want := s.TotalCost, 170.0; got != want {
+		t.Errorf("got total %v, want %v", got, want)
+	}
+}
+
+func TestTariffRatesVAT(t *testing.T) {
+	ctx := context.Background()
+	o := newTestOctonaut(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Minute)
+	in := octopus.TariffRate{Results: []octopus.RateInterval{
+		{ValidFrom: start, ValidTo: &end, ValueExcVat: 20, ValueIncVat: 21},
+	}}
+	if err := o.upsertTariff(ctx, "E-1R-TEST-A", octopus.StandardUnitRates, in); err != nil {
+		t.Fatalf("upsertTariff: %v", err)
+	}
+	if err := o.upsertStandingCharges(ctx, "E-1R-TEST-A", in); err != nil {
+		t.Fatalf("upsertStandingCharges: %v", err)
+	}
+	rates, err := o.TariffRates(ctx, "E-1R-TEST-A", octopus.StandardUnitRates, start, start.Add(30*time.Minute))
+	if err != nil {
+		t.Fatalf("TariffRates: %v", err)
+	}
+	sc, err := o.StandingCharges(ctx, "E-1R-TEST-A", start, start.Add(30*time.Minute))
+	if err != nil {
+		t.Fatalf("StandingCharges: %v", err)
+	}
+	for _, got := range []octopus.RateInterval{rates.Results[0], sc.Results[0]} {
+		if got.ValueExcVat != 20 || got.ValueIncVat != 21 {
+			t.Errorf("got %v exc. VAT, %v inc. VAT, want 20 and 21", got.ValueExcVat, got.ValueIncVat)
+		}
+	}
+}
+
+// rateServer returns a fake API server which serves the given JSON for all unit rate and standing charge requests.
+func rateServer(t *testing.T, unitRates, standingCharges string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/standard-unit-rates/"):
+			fmt.Fprint(w, unitRates)
+		case strings.HasSuffix(r.URL.Path, "/standing-charges/"):
+			fmt.Fprint(w, standingCharges)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTariffHistories(t *testing.T) {
+	ctx := context.Background()
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	// Consumption of 1kWh every half hour for the 1st - 3rd April 2024, UK time.
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, london)
+	to := time.Date(2024, 4, 4, 0, 0, 0, 0, london)
+
+	// The API returns the most recent rates first.
+	for _, test := range []struct {
+		name            string
+		product         string
+		unitRates       string
+		standingCharges string
+		wantEnergy      float64
+		wantStanding    float64
+	}{
+		{
+			// Tracker rates change daily at midnight UK time, i.e. 23:00 UTC during BST, and the current
+			// rate is open-ended.
+			name:    "tracker",
+			product: "SILVER-23-12-06",
+			unitRates: `{"count": 3, "results": [
+				{"value_exc_vat": 20, "value_inc_vat": 21, "valid_from": "2024-04-02T23:00:00Z", "valid_to": null},
+				{"value_exc_vat": 19, "value_inc_vat": 19.95, "valid_from": "2024-04-01T23:00:00Z", "valid_to": "2024-04-02T23:00:00Z"},
+				{"value_exc_vat": 18, "value_inc_vat": 18.9, "valid_from": "2024-03-31T23:00:00Z", "valid_to": "2024-04-01T23:00:00Z"}
+			]}`,
+			standingCharges: `{"count": 1, "results": [
+				{"value_exc_vat": 40, "value_inc_vat": 42, "valid_from": "2024-01-01T00:00:00Z", "valid_to": null}
+			]}`,
+			wantEnergy:   48 * (18.9 + 19.95 + 21),
+			wantStanding: 3 * 42,
+		}, {
+			// Fixed tariffs have a single open-ended rate, with different prices for those not paying by direct debit.
+			name:    "fixed",
+			product: "COOP-FIX-12M-24-01-01",
+			unitRates: `{"count": 2, "results": [
+				{"value_exc_vat": 24, "value_inc_vat": 25.2, "valid_from": "2024-01-01T00:00:00Z", "valid_to": null, "payment_method": "DIRECT_DEBIT"},
+				{"value_exc_vat": 25, "value_inc_vat": 26.25, "valid_from": "2024-01-01T00:00:00Z", "valid_to": null, "payment_method": "NON_DIRECT_DEBIT"}
+			]}`,
+			standingCharges: `{"count": 2, "results": [
+				{"value_exc_vat": 40, "value_inc_vat": 42, "valid_from": "2024-01-01T00:00:00Z", "valid_to": null, "payment_method": "DIRECT_DEBIT"},
+				{"value_exc_vat": 50, "value_inc_vat": 52.5, "valid_from": "2024-01-01T00:00:00Z", "valid_to": null, "payment_method": "NON_DIRECT_DEBIT"}
+			]}`,
+			wantEnergy:   144 * 25.2,
+			wantStanding: 3 * 42,
+		}, {
+			// Variable tariffs' prices change with the price cap, here on 1st April, UK time.
+			name:    "variable",
+			product: "VAR-22-11-01",
+			unitRates: `{"count": 2, "results": [
+				{"value_exc_vat": 23, "value_inc_vat": 24.15, "valid_from": "2024-03-31T23:00:00Z", "valid_to": null, "payment_method": "DIRECT_DEBIT"},
+				{"value_exc_vat": 27, "value_inc_vat": 28.35, "valid_from": "2024-01-01T00:00:00Z", "valid_to": "2024-03-31T23:00:00Z", "payment_method": "DIRECT_DEBIT"}
+			]}`,
+			standingCharges: `{"count": 2, "results": [
+				{"value_exc_vat": 57, "value_inc_vat": 59.85, "valid_from": "2024-03-31T23:00:00Z", "valid_to": null, "payment_method": "DIRECT_DEBIT"},
+				{"value_exc_vat": 51, "value_inc_vat": 53.55, "valid_from": "2024-01-01T00:00:00Z", "valid_to": "2024-03-31T23:00:00Z", "payment_method": "DIRECT_DEBIT"}
+			]}`,
+			wantEnergy:   144 * 24.15,
+			wantStanding: 3 * 59.85,
+		}, {
+			// A mixed history of closed and open-ended rates, with the rates and standing charges changing
+			// during the period.
+			name:    "mixed",
+			product: "VAR-22-11-01",
+			unitRates: `{"count": 3, "results": [
+				{"value_exc_vat": 22, "value_inc_vat": 23.1, "valid_from": "2024-04-02T23:00:00Z", "valid_to": null, "payment_method": "DIRECT_DEBIT"},
+				{"value_exc_vat": 24, "value_inc_vat": 25.2, "valid_from": "2024-04-02T12:00:00Z", "valid_to": "2024-04-02T23:00:00Z"},
+				{"value_exc_vat": 23, "value_inc_vat": 24.15, "valid_from": "2024-03-01T00:00:00Z", "valid_to": "2024-04-02T12:00:00Z", "payment_method": "DIRECT_DEBIT"}
+			]}`,
+			standingCharges: `{"count": 2, "results": [
+				{"value_exc_vat": 57, "value_inc_vat": 59.85, "valid_from": "2024-04-01T23:00:00Z", "valid_to": null},
+				{"value_exc_vat": 51, "value_inc_vat": 53.55, "valid_from": "2024-01-01T00:00:00Z", "valid_to": "2024-04-01T23:00:00Z"}
+			]}`,
+			// The 2nd April has 26 half hours at the old rate and 22 at the new one.
+			wantEnergy:   48*24.15 + 26*24.15 + 22*25.2 + 48*23.1,
+			wantStanding: 53.55 + 2*59.85,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			o := newTestOctonaut(t)
+			o.c.EndPoint = rateServer(t, test.unitRates, test.standingCharges).URL + "/"
+
+			tariffCode := octopus.BuildTariffCode("E", "1R", test.product, "A")
+			if err := o.SyncTariff(ctx, test.product, tariffCode, from, to); err != nil {
+				t.Fatalf("SyncTariff: %v", err)
+			}
+			rates, err := o.TariffRates(ctx, tariffCode, octopus.StandardUnitRates, from, to)
+			if err != nil {
+				t.Fatalf("TariffRates: %v", err)
+			}
+			cons := Consumption{}
+			for s := from; s.Before(to); s = s.Add(30 * time.Minute) {
+				cons.Intervals = append(cons.Intervals, ConsumptionInterval{Start: s, End: s.Add(30 * time.Minute), Consumption: 1})
+			}
+			cost, err := TotalCost(ctx, cons, Tariff(*rates))
+			if err != nil {
+				t.Fatalf("TotalCost: %v", err)
+			}
+			if math.Abs(cost.TotalCost-test.wantEnergy) > 1e-6 {
+				t.Errorf("got energy cost %v, want %v", cost.TotalCost, test.wantEnergy)
+			}
+
+			sc, err := o.StandingCharges(ctx, tariffCode, from, to)
+			if err != nil {
+				t.Fatalf("StandingCharges: %v", err)
+			}
+			standing, err := StandingCost(ctx, from, to, london, Tariff(*sc))
+			if err != nil {
+				t.Fatalf("StandingCost: %v", err)
+			}
+			if math.Abs(standing.TotalCost-test.wantStanding) > 1e-6 {
+				t.Errorf("got standing cost %v, want %v", standing.TotalCost, test.wantStanding)
+			}
+		})
+	}
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	s := fake.New(fake.DefaultKey)
+	s.MaxPageSize = 500
+	from, to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	s.Generate(fake.Options{From: from, To: to})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "octonaut.sqlite3"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	c := &octopus.Client{EndPoint: srv.URL, AccountID: fake.DefaultAccount, Key: fake.DefaultKey, MaxRetries: 3, RetryBackoff: time.Millisecond}
+	o, err := NewWithClient(ctx, c, db)
+	if err != nil {
+		t.Fatalf("NewWithClient: %v", err)
+	}
+
+	// Transient failures should be retried.
+	s.Fail(2, http.StatusServiceUnavailable)
+	if err := o.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if _, notFound, err := o.Account(ctx); err != nil || notFound {
+		t.Fatalf("Account: notFound %t, err %v", notFound, err)
+	}
+	if region, source, notFound, err := o.Region(ctx, 1); err != nil || notFound || region != fake.Region {
+		t.Errorf("Region: got %q from %q, notFound %t, err %v, want %q", region, source, notFound, err, fake.Region)
+	}
+	for _, test := range []struct {
+		name  string
+		fetch func() (Consumption, error)
+	}{
+		{name: "import", fetch: func() (Consumption, error) { return o.Consumption(ctx, fake.ImportMPAN, "", from, to) }},
+		{name: "export", fetch: func() (Consumption, error) {
+			return o.ExportConsumption(ctx, fake.ExportMPAN, fake.ExportMeter, from, to)
+		}},
+		{name: "gas", fetch: func() (Consumption, error) { return o.GasConsumption(ctx, fake.GasMPRN, fake.GasMeter, from, to) }},
+	} {
+		cons, err := test.fetch()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if got, want := len(cons.Intervals), 14*48; got != want {
+			t.Errorf("%s: got %d intervals, want %d", test.name, got, want)
+		}
+	}
+
+	// Syncing again should only fetch the account, its region, and readings since the last sync, which
+	// fit on a single page per meter.
+	before := s.Requests()
+	if err := o.Sync(ctx); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if got, want := s.Requests()-before, 5; got != want {
+		t.Errorf("second Sync made %d requests, want %d", got, want)
+	}
+
+	c.Key = "sk_live_wrong"
+	if err := o.Sync(ctx); !errors.As(err, new(*octopus.AuthError)) {
+		t.Errorf("Sync with wrong key: got err %v, want AuthError", err)
+	}
+}
+
+func TestConsumptionMostRecent(t *testing.T) {
+	ctx := context.Background()
+	o := newTestOctonaut(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Meters without readings are synced from the start.
+	if got, err := o.consumptionMostRecent(ctx, electricityConsumption, "1000", "M1"); err != nil || !got.IsZero() {
+		t.Fatalf("consumptionMostRecent without readings: got %v, %v, want zero time", got, err)
+	}
+	for _, table := range []consumptionTable{electricityConsumption, exportConsumption, gasConsumption} {
+		if err := o.insertConsumption(ctx, table, "1000", "M1", readings(start, 48, 0.5)); err != nil {
+			t.Fatalf("insertConsumption(%s): %v", table.name, err)
+		}
+		// IntervalStart is stored as a Unix timestamp, which must be read back as one rather than
+		// as a date, otherwise every sync starts again from the beginning.
+		got, err := o.consumptionMostRecent(ctx, table, "1000", "M1")
+		if err != nil {
+			t.Fatalf("consumptionMostRecent(%s): %v", table.name, err)
+		}
+		if want := start.Add(47 * 30 * time.Minute); !got.Equal(want) {
+			t.Errorf("consumptionMostRecent(%s) = %v, want %v", table.name, got, want)
+		}
+	}
+	// Readings from other meters don't count.
+	if got, err := o.consumptionMostRecent(ctx, electricityConsumption, "1000", "M2"); err != nil || !got.IsZero() {
+		t.Errorf("consumptionMostRecent for another meter: got %v, %v, want zero time", got, err)
+	}
+}
+
+func TestSyncGasUnits(t *testing.T) {
+	ctx := context.Background()
+	o := newTestOctonaut(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	const mprn = "1000"
+
+	if _, _, notFound, err := o.GasMeterUnits(ctx, mprn, "G1"); err != nil || !notFound {
+		t.Fatalf("GasMeterUnits before syncing: notFound %t, err %v, want notFound", notFound, err)
+	}
+	// Gas meters without readings can't be detected.
+	o.syncGasUnits(ctx, mprn, "G1")
+	if _, _, notFound, err := o.GasMeterUnits(ctx, mprn, "G1"); err != nil || !notFound {
+		t.Fatalf("GasMeterUnits without readings: notFound %t, err %v, want notFound", notFound, err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		meter      string
+		set        string
+		peak       float64
+		wantUnits  string
+		wantSource string
+	}{
+		// 1.5 m³ in half an hour is a boiler running flat out.
+		{name: "SMETS2", meter: "G1", peak: 1.5, wantUnits: GasUnitsM3, wantSource: "detected"},
+		{name: "SMETS1", meter: "G2", peak: 8.2, wantUnits: GasUnitsKWh, wantSource: "detected"},
+		{name: "set", meter: "G3", set: GasUnitsKWh, peak: 1.5, wantUnits: GasUnitsKWh, wantSource: "set"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cons := readings(start, 48, 0.1)
+			cons.Results[36].Consumption = test.peak
+			if err := o.insertConsumption(ctx, gasConsumption, mprn, test.meter, cons); err != nil {
+				t.Fatalf("insertConsumption: %v", err)
+			}
+			o.GasUnits = test.set
+			o.syncGasUnits(ctx, mprn, test.meter)
+			units, source, notFound, err := o.GasMeterUnits(ctx, mprn, test.meter)
+			if err != nil || notFound {
+				t.Fatalf("GasMeterUnits: notFound %t, err %v", notFound, err)
+			}
+			if units != test.wantUnits || source != test.wantSource {
+				t.Errorf("got %s (%s), want %s (%s)", units, source, test.wantUnits, test.wantSource)
+			}
+		})
+	}
+
+	// Units which were set are kept when syncing again, but detected ones follow the readings.
+	o.GasUnits = ""
+	if err := o.insertConsumption(ctx, gasConsumption, mprn, "G1", readings(start.AddDate(0, 0, 1), 1, 12)); err != nil {
+		t.Fatalf("insertConsumption: %v", err)
+	}
+	for meter, want := range map[string]string{"G1": GasUnitsKWh, "G3": GasUnitsKWh} {
+		o.syncGasUnits(ctx, mprn, meter)
+		if got, _, _, err := o.GasMeterUnits(ctx, mprn, meter); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q after syncing again", meter, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package octonaut
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/charmbracelet/log"
+)
+
+// productMaxAge is how long the cached list of current products, and the details of each product, are
+// used before they're refreshed from the API.
+const productMaxAge = 24 * time.Hour
+
+// ErrNotCached is returned when working offline if the requested data hasn't been cached locally.
+var ErrNotCached = errors.New("not cached locally, run the sync command while online")
+
+// Products returns the products which were available at the given time, or which are available now if
+// at is nil.
+// The list of current products is cached for up to a day, and all lists are read from the cache when offline.
+func (o *Octonaut) Products(ctx context.Context, at *time.Time) (octopus.Products, error) {
+	if !o.Offline && at == nil {
+		listed, err := o.productsListed(ctx)
+		if err != nil {
+			return octopus.Products{}, err
+		}
+		if time.Since(listed) >= productMaxAge {
+			return o.syncProducts(ctx)
+		}
+	}
+	if o.Offline || at == nil {
+		return o.cachedProducts(ctx, at)
+	}
+
+	ps, err := o.c.Products(ctx, at)
+	if err != nil {
+		return octopus.Products{}, err
+	}
+	if err := o.upsertProducts(ctx, ps.Results, false); err != nil {
+		return octopus.Products{}, fmt.Errorf("failed to cache products: %v", err)
+	}
+	return ps, nil
+}
+
+// ProductsBetween returns the products which were available at any time in the half-open period [from, to),
+// sorted by code.
+// The API only lists the products available at a single time, so unless offline the products available at
+// from, the start of each following month, and to are fetched first to fill the cache. The cache is then
+// searched for every product whose availability overlaps the period, which includes products found by
+// earlier listings, but products which came and went between those times and were never cached are missed.
+func (o *Octonaut) ProductsBetween(ctx context.Context, from, to time.Time) (octopus.Products, error) {
+	if !o.Offline {
+		for d := from; ; d = time.Date(d.Year(), d.Month()+1, 1, 0, 0, 0, 0, d.Location()) {
+			if !d.Before(to) {
+				d = to
+			}
+			at := d
+			if _, err := o.Products(ctx, &at); err != nil {
+				return octopus.Products{}, err
+			}
+			if d.Equal(to) {
+				break
+			}
+		}
+	}
+	rows, err := o.db.QueryContext(ctx, `SELECT JSON FROM Product WHERE AvailableFrom < $to AND (AvailableTo IS NULL OR AvailableTo > $from) ORDER BY Code`,
+		sql.Named("from", from.Unix()), sql.Named("to", to.Unix()))
+	if err != nil {
+		return octopus.Products{}, fmt.Errorf("QueryContext: %v", err)
+	}
+	defer rows.Close()
+	return scanProducts(rows)
+}
+
+// Product returns the details of the product, including the tariffs it offers.
+// Cached details are used if they were fetched less than a day ago, or when offline, or if refreshing
+// older ones fails.
+func (o *Octonaut) Product(ctx context.Context, code string) (octopus.ProductDetail, error) {
+	d, fetched, err := o.cachedProduct(ctx, code)
+	if err != nil && !errors.Is(err, ErrNotCached) {
+		return octopus.ProductDetail{}, err
+	}
+	if o.Offline || (err == nil && time.Since(fetched) < productMaxAge) {
+		return d, err
+	}
+	fresh, syncErr := o.syncProduct(ctx, code)
+	if syncErr != nil && err == nil {
+		log.Warnf("Failed to refresh details of product %s, using those cached at %v: %v", code, fetched, syncErr)
+		return d, nil
+	}
+	return fresh, syncErr
+}
+
+// SyncProducts refreshes the cached list of current products, and fetches the details of those which
+// haven't been cached, or were cached more than a day ago.
+func (o *Octonaut) SyncProducts(ctx context.Context) error {
+	if o.Offline {
+		return errors.New("can't sync products while offline")
+	}
+	ps, err := o.syncProducts(ctx)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, p := range ps.Results {
+		_, fetched, err := o.cachedProduct(ctx, p.Code)
+		if err == nil && time.Since(fetched) < productMaxAge {
+			continue
+		}
+		if _, err := o.syncProduct(ctx, p.Code); err != nil {
+			if nf := (*octopus.NotFoundError)(nil); errors.As(err, &nf) {
+				log.Warnf("Product %s is listed but has no details: %v", p.Code, err)
+				continue
+			}
+			return err
+		}
+		n++
+	}
+	log.Infof(" + Cached %d products, fetched details of %d", len(ps.Results), n)
+	return nil
+}
+
+// syncProducts fetches and caches the list of current products.
+func (o *Octonaut) syncProducts(ctx context.Context) (octopus.Products, error) {
+	ps, err := o.c.Products(ctx, nil)
+	if err != nil {
+		return octopus.Products{}, err
+	}
+	if err := o.upsertProducts(ctx, ps.Results, true); err != nil {
+		return octopus.Products{}, fmt.Errorf("failed to cache products: %v", err)
+	}
+	return ps, nil
+}
+
+// syncProduct fetches and caches the details of the product.
+func (o *Octonaut) syncProduct(ctx context.Context, code string) (octopus.ProductDetail, error) {
+	d, err := o.c.Product(ctx, code)
+	if err != nil {
+		return octopus.ProductDetail{}, err
+	}
+	if err := o.upsertProductDetail(ctx, d); err != nil {
+		return octopus.ProductDetail{}, fmt.Errorf("failed to cache product %s: %v", code, err)
+	}
+	return d, nil
+}
+
+// productsListed returns when the list of current products was last cached, or the zero time if it
+// never has been.
+func (o *Octonaut) productsListed(ctx context.Context) (time.Time, error) {
+	var listed sql.NullInt64
+	if err := o.db.QueryRowContext(ctx, `SELECT MAX(Listed) FROM Product`).Scan(&listed); err != nil {
+		return time.Time{}, fmt.Errorf("failed to scan latest Product.Listed: %v", err)
+	}
+	if !listed.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(listed.Int64, 0).UTC(), nil
+}
+
+// cachedProducts returns the cached products which were available at the given time, or those in the
+// most recently cached list of current products if at is nil.
+func (o *Octonaut) cachedProducts(ctx context.Context, at *time.Time) (octopus.Products, error) {
+	q, args := `SELECT JSON FROM Product WHERE Listed = (SELECT MAX(Listed) FROM Product) ORDER BY Code`, []any{}
+	if at != nil {
+		q = `SELECT JSON FROM Product WHERE AvailableFrom <= $at AND (AvailableTo IS NULL OR AvailableTo > $at) ORDER BY Code`
+		args = append(args, sql.Named("at", at.Unix()))
+	}
+	rows, err := o.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return octopus.Products{}, fmt.Errorf("QueryContext: %v", err)
+	}
+	defer rows.Close()
+	return scanProducts(rows)
+}
+
+// scanProducts reads products from the JSON column of rows, failing with ErrNotCached if there aren't any.
+func scanProducts(rows *sql.Rows) (octopus.Products, error) {
+	r := octopus.Products{}
+	for rows.Next() {
+		var j []byte
+		if err := rows.Scan(&j); err != nil {
+			return octopus.Products{}, fmt.Errorf("Scan: %v", err)
+		}
+		p := octopus.Product{}
+		if err := json.Unmarshal(j, &p); err != nil {
+			return octopus.Products{}, fmt.Errorf("Unmarshal: %v", err)
+		}
+		r.Results = append(r.Results, p)
+	}
+	if err := rows.Err(); err != nil {
+		return octopus.Products{}, err
+	}
+	if len(r.Results) == 0 {
+		return octopus.Products{}, fmt.Errorf("products: %w", ErrNotCached)
+	}
+	r.Count = len(r.Results)
+	return r, nil
+}
+
+// cachedProduct returns the cached details of the product, and when they were fetched.
+func (o *Octonaut) cachedProduct(ctx context.Context, code string) (octopus.ProductDetail, time.Time, error) {
+	var j []byte
+	var activeAt, fetched sql.NullTime
+	err := o.db.QueryRowContext(ctx, `SELECT JSON, TariffsActiveAt, DetailFetched FROM Product WHERE Code = ? AND DetailFetched IS NOT NULL`, code).Scan(&j, &activeAt, &fetched)
+	if errors.Is(err, sql.ErrNoRows) {
+		return octopus.ProductDetail{}, time.Time{}, fmt.Errorf("product %s: %w", code, ErrNotCached)
+	}
+	if err != nil {
+		return octopus.ProductDetail{}, time.Time{}, fmt.Errorf("Scan: %v", err)
+	}
+	d := octopus.ProductDetail{TariffsActiveAt: activeAt.Time}
+	if err := json.Unmarshal(j, &d.Product); err != nil {
+		return octopus.ProductDetail{}, time.Time{}, fmt.Errorf("Unmarshal: %v", err)
+	}
+
+	rows, err := o.db.QueryContext(ctx, `SELECT Fuel, Registers, Region, PaymentMethod, JSON FROM ProductTariff WHERE Product = ?`, code)
+	if err != nil {
+		return octopus.ProductDetail{}, time.Time{}, fmt.Errorf("QueryContext: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var fuel, registers, region, method string
+		if err := rows.Scan(&fuel, &registers, &region, &method, &j); err != nil {
+			return octopus.ProductDetail{}, time.Time{}, fmt.Errorf("Scan: %v", err)
+		}
+		t := octopus.Tariff{}
+		if err := json.Unmarshal(j, &t); err != nil {
+			return octopus.ProductDetail{}, time.Time{}, fmt.Errorf("Unmarshal: %v", err)
+		}
+		ts := d.Tariffs(fuel, registers)
+		if ts == nil {
+			return octopus.ProductDetail{}, time.Time{}, fmt.Errorf("invalid cached %s-%s tariff %s", fuel, registers, t.Code)
+		}
+		if *ts == nil {
+			*ts = octopus.RegionalTariffs{}
+		}
+		if (*ts)["_"+region] == nil {
+			(*ts)["_"+region] = map[string]octopus.Tariff{}
+		}
+		(*ts)["_"+region][method] = t
+	}
+	if err := rows.Err(); err != nil {
+		return octopus.ProductDetail{}, time.Time{}, err
+	}
+
+	// Fill in the links of each tariff.
+	for _, tt := range octopus.TariffTypes {
+		for _, byMethod := range *d.Tariffs(tt.Fuel, tt.Registers) {
+			for m, t := range byMethod {
+				if t.Links, err = o.tariffLinks(ctx, t.Code); err != nil {
+					return octopus.ProductDetail{}, time.Time{}, err
+				}
+				byMethod[m] = t
+			}
+		}
+	}
+	return d, fetched.Time, nil
+}
+
+// tariffLinks returns the cached links to the tariff's rates.
+func (o *Octonaut) tariffLinks(ctx context.Context, tariffCode string) ([]octopus.Link, error) {
+	rows, err := o.db.QueryContext(ctx, `SELECT Rel, Href FROM TariffLink WHERE Code = ? ORDER BY Rel`, tariffCode)
+	if err != nil {
+		return nil, fmt.Errorf("QueryContext: %v", err)
+	}
+	defer rows.Close()
+	r := []octopus.Link{}
+	for rows.Next() {
+		l := octopus.Link{Method: "GET"}
+		if err := rows.Scan(&l.Rel, &l.Href); err != nil {
+			return nil, fmt.Errorf("Scan: %v", err)
+		}
+		r = append(r, l)
+	}
+	return r, rows.Err()
+}
+
+// upsertProducts caches the products, current should be set if they're the list of currently available
+// products.
+func (o *Octonaut) upsertProducts(ctx context.Context, ps []octopus.Product, current bool) error {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var listed any
+	if current {
+		listed = time.Now().Unix()
+	}
+	for _, p := range ps {
+		if err := upsertProduct(ctx, tx, p, listed); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// upsertProduct caches the product, leaving when it was last listed unchanged if listed is nil.
+func upsertProduct(ctx context.Context, tx *sql.Tx, p octopus.Product, listed any) error {
+	j, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+	var availableTo any
+	if p.AvailableTo != nil {
+		availableTo = p.AvailableTo.Unix()
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO Product (Code, JSON, AvailableFrom, AvailableTo, Listed) VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(Code) DO UPDATE SET
+			JSON = excluded.JSON,
+			AvailableFrom = excluded.AvailableFrom,
+			AvailableTo = excluded.AvailableTo,
+			Listed = COALESCE(excluded.Listed, Listed)`,
+		p.Code, j, p.AvailableFrom.Unix(), availableTo, listed); err != nil {
+		return fmt.Errorf("insert/update product: %v", err)
+	}
+	return nil
+}
+
+// upsertProductDetail caches the product, replacing the tariffs it offers and their links, so that those
+// it no longer offers aren't found.
+func (o *Octonaut) upsertProductDetail(ctx context.Context, d octopus.ProductDetail) error {
+	tx, err := o.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := upsertProduct(ctx, tx, d.Product, nil); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE Product SET TariffsActiveAt = ?, DetailFetched = ? WHERE Code = ?`, d.TariffsActiveAt.Unix(), time.Now().Unix(), d.Code); err != nil {
+		return fmt.Errorf("update product: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM TariffLink WHERE Code IN (SELECT Code FROM ProductTariff WHERE Product = ?)`, d.Code); err != nil {
+		return fmt.Errorf("delete tariff links: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ProductTariff WHERE Product = ?`, d.Code); err != nil {
+		return fmt.Errorf("delete product tariffs: %v", err)
+	}
+	for _, tt := range octopus.TariffTypes {
+		for region, byMethod := range *d.Tariffs(tt.Fuel, tt.Registers) {
+			for method, t := range byMethod {
+				links := t.Links
+				// Links are stored separately, by tariff code.
+				t.Links = nil
+				j, err := json.Marshal(t)
+				if err != nil {
+					return fmt.Errorf("marshal: %v", err)
+				}
+				if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO ProductTariff VALUES(?, ?, ?, ?, ?, ?, ?)`,
+					d.Code, tt.Fuel, tt.Registers, strings.TrimPrefix(region, "_"), method, t.Code, j); err != nil {
+					return fmt.Errorf("insert product tariff: %v", err)
+				}
+				for _, l := range links {
+					if _, err := tx.ExecContext(ctx, `INSERT OR REPLACE INTO TariffLink VALUES(?, ?, ?)`, t.Code, l.Rel, l.Href); err != nil {
+						return fmt.Errorf("insert tariff link: %v", err)
+					}
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
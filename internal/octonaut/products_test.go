@@ -0,0 +1,279 @@
+package octonaut
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+	"github.com/AlCutter/octonaut/internal/octopus/fake"
+)
+
+func TestProductCache(t *testing.T) {
+	ctx := context.Background()
+	s := fake.New(fake.DefaultKey)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Generate(fake.Options{From: from, To: from.AddDate(0, 0, 7)})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "octonaut.sqlite3"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	o, err := NewWithClient(ctx, &octopus.Client{EndPoint: srv.URL, AccountID: fake.DefaultAccount, Key: fake.DefaultKey}, db)
+	if err != nil {
+		t.Fatalf("NewWithClient: %v", err)
+	}
+
+	if err := o.SyncProducts(ctx); err != nil {
+		t.Fatalf("SyncProducts: %v", err)
+	}
+	online, err := o.c.Product(ctx, fake.Go)
+	if err != nil {
+		t.Fatalf("Client.Product: %v", err)
+	}
+	past := from.AddDate(0, -6, 0)
+	if _, err := o.Products(ctx, &past); err != nil {
+		t.Fatalf("Products(%v): %v", past, err)
+	}
+
+	// Syncing again, and fetching current products and their details, should all use the cache.
+	before := s.Requests()
+	if err := o.SyncProducts(ctx); err != nil {
+		t.Fatalf("SyncProducts: %v", err)
+	}
+	if _, err := o.Products(ctx, nil); err != nil {
+		t.Fatalf("Products: %v", err)
+	}
+	if _, err := o.Product(ctx, fake.Go); err != nil {
+		t.Fatalf("Product: %v", err)
+	}
+	if got, want := s.Requests()-before, 1; got != want {
+		t.Errorf("made %d requests, want %d to refresh the list of products", got, want)
+	}
+
+	o.Offline = true
+	for _, test := range []struct {
+		name string
+		at   *time.Time
+		want []string
+	}{
+		{name: "current", want: []string{fake.ImportAgile, fake.ExportAgile, fake.Variable, fake.Go, fake.Tracker, fake.Fixed, fake.BusinessVar}},
+		{name: "past", at: &past, want: []string{fake.OldAgile}},
+	} {
+		ps, err := o.Products(ctx, test.at)
+		if err != nil {
+			t.Fatalf("%s: Products: %v", test.name, err)
+		}
+		got := []string{}
+		for _, p := range ps.Results {
+			got = append(got, p.Code)
+		}
+		slices.Sort(test.want)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got products %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	tariff, err := o.ResolveTariff(ctx, fake.Go, "E", "1R", fake.Region, "")
+	if err != nil {
+		t.Fatalf("ResolveTariff: %v", err)
+	}
+	want, err := online.Tariff("E", "1R", fake.Region, "")
+	if err != nil {
+		t.Fatalf("Tariff: %v", err)
+	}
+	if tariff.Code != want.Code || tariff.StandardUnitRateIncVAT != want.StandardUnitRateIncVAT || tariff.Link(octopus.StandardUnitRates) != want.Link(octopus.StandardUnitRates) {
+		t.Errorf("got cached tariff %+v, want %+v", tariff, want)
+	}
+	if _, err := o.ResolveTariff(ctx, fake.Go, "E", "2R", fake.Region, ""); !errors.Is(err, octopus.ErrNotOffered) {
+		t.Errorf("ResolveTariff(2R): got err %v, want ErrNotOffered", err)
+	}
+	if _, err := o.Product(ctx, "UNKNOWN-24-01-01"); !errors.Is(err, ErrNotCached) {
+		t.Errorf("Product(unknown): got err %v, want ErrNotCached", err)
+	}
+	before = s.Requests()
+	if err := o.SyncResolvedTariff(ctx, tariff, from, from.AddDate(0, 0, 1)); err != nil {
+		t.Errorf("SyncResolvedTariff: %v", err)
+	}
+	if got := s.Requests() - before; got != 0 {
+		t.Errorf("made %d requests while offline", got)
+	}
+
+	// Refreshing a product which has stopped offering a tariff removes it, and its links, from the cache.
+	variable, err := o.c.Product(ctx, fake.Variable)
+	if err != nil {
+		t.Fatalf("Client.Product: %v", err)
+	}
+	e7, err := variable.Tariff("E", "2R", fake.Region, "")
+	if err != nil {
+		t.Fatalf("Tariff(2R): %v", err)
+	}
+	if err := o.upsertProductDetail(ctx, variable); err != nil {
+		t.Fatalf("upsertProductDetail: %v", err)
+	}
+	if _, err := o.ResolveTariff(ctx, fake.Variable, "E", "2R", fake.Region, ""); err != nil {
+		t.Fatalf("ResolveTariff(2R): %v", err)
+	}
+	variable.DualRegisterElectricityTariffs = nil
+	if err := o.upsertProductDetail(ctx, variable); err != nil {
+		t.Fatalf("upsertProductDetail: %v", err)
+	}
+	if _, err := o.ResolveTariff(ctx, fake.Variable, "E", "2R", fake.Region, ""); !errors.Is(err, octopus.ErrNotOffered) {
+		t.Errorf("ResolveTariff(2R) after refresh: got err %v, want ErrNotOffered", err)
+	}
+	if ls, err := o.tariffLinks(ctx, e7.Code); err != nil || len(ls) != 0 {
+		t.Errorf("tariffLinks(%s) after refresh: got %v, %v, want none", e7.Code, ls, err)
+	}
+	if _, err := o.ResolveTariff(ctx, fake.Variable, "E", "1R", fake.Region, ""); err != nil {
+		t.Errorf("ResolveTariff(1R) after refresh: %v", err)
+	}
+
+	// Stale details are still used if they can't be refreshed, but a product which was never cached can't be.
+	o.Offline = false
+	if _, err := db.Exec(`UPDATE Product SET DetailFetched = ? WHERE Code = ?`, time.Now().Add(-2*productMaxAge).Unix(), fake.Go); err != nil {
+		t.Fatalf("failed to age cached product: %v", err)
+	}
+	s.Fail(2, http.StatusInternalServerError)
+	before = s.Requests()
+	if d, err := o.Product(ctx, fake.Go); err != nil || d.Code != fake.Go {
+		t.Errorf("Product(%s) with stale cache: got %+v, %v, want cached details", fake.Go, d, err)
+	}
+	if got := s.Requests() - before; got != 1 {
+		t.Errorf("made %d requests, want 1 to try to refresh the stale details", got)
+	}
+	if _, err := o.Product(ctx, "UNKNOWN-24-01-01"); err == nil {
+		t.Errorf("Product(unknown) with failing API succeeded, want error")
+	}
+}
+
+func TestProductsBetween(t *testing.T) {
+	ctx := context.Background()
+	s := fake.New(fake.DefaultKey)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Generate(fake.Options{From: from, To: from.AddDate(0, 0, 7)})
+	// A product which came and went between the starts of months.
+	const blip = "BLIP-23-12-10"
+	blipFrom, blipTo := time.Date(2023, 12, 10, 0, 0, 0, 0, time.UTC), time.Date(2023, 12, 20, 0, 0, 0, 0, time.UTC)
+	s.AddProduct(octopus.Product{Code: blip, FullName: "Blip", Direction: "IMPORT", AvailableFrom: blipFrom, AvailableTo: &blipTo})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "octonaut.sqlite3"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	o, err := NewWithClient(ctx, &octopus.Client{EndPoint: srv.URL, AccountID: fake.DefaultAccount, Key: fake.DefaultKey}, db)
+	if err != nil {
+		t.Fatalf("NewWithClient: %v", err)
+	}
+
+	current := []string{fake.ImportAgile, fake.ExportAgile, fake.Variable, fake.Go, fake.Tracker, fake.Fixed, fake.BusinessVar}
+	codes := func(name string, start, end time.Time) []string {
+		t.Helper()
+		ps, err := o.ProductsBetween(ctx, start, end)
+		if err != nil {
+			t.Fatalf("%s: ProductsBetween: %v", name, err)
+		}
+		got := []string{}
+		for _, p := range ps.Results {
+			got = append(got, p.Code)
+		}
+		return got
+	}
+	for _, test := range []struct {
+		name     string
+		from, to time.Time
+		cache    *time.Time
+		offline  bool
+		want     []string
+	}{
+		{name: "withdrawn and current", from: from.AddDate(0, -2, 0), to: from.AddDate(0, 1, 0), want: append([]string{fake.OldAgile}, current...)},
+		{name: "after cached listing", from: from.AddDate(0, -2, 0), to: from.AddDate(0, 1, 0), cache: &blipFrom, want: append([]string{fake.OldAgile, blip}, current...)},
+		{name: "offline", from: from.AddDate(0, -2, 0), to: from.AddDate(0, 1, 0), offline: true, want: append([]string{fake.OldAgile, blip}, current...)},
+		{name: "before blip", from: from.AddDate(0, -2, 0), to: blipFrom, offline: true, want: []string{fake.OldAgile}},
+		{name: "after withdrawal", from: from, to: from.AddDate(0, 1, 0), offline: true, want: current},
+	} {
+		if test.cache != nil {
+			if _, err := o.Products(ctx, test.cache); err != nil {
+				t.Fatalf("%s: Products: %v", test.name, err)
+			}
+		}
+		o.Offline = test.offline
+		before := s.Requests()
+		got := codes(test.name, test.from, test.to)
+		if test.offline && s.Requests() != before {
+			t.Errorf("%s: made %d requests while offline", test.name, s.Requests()-before)
+		}
+		slices.Sort(test.want)
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got products %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSyncResolvedTariffPaymentMethod(t *testing.T) {
+	ctx := context.Background()
+	s := fake.New(fake.DefaultKey)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Generate(fake.Options{From: from, To: from.AddDate(0, 0, 7)})
+	// Prepay products only have prices for customers who don't pay by direct debit.
+	const prepay = "PREPAY-VAR-18-09-21"
+	s.AddProduct(octopus.Product{Code: prepay, FullName: "Prepay Flexible", IsVariable: true, IsPrepay: true, Direction: "IMPORT", AvailableFrom: from})
+	prepayCode := octopus.BuildTariffCode("E", "1R", prepay, fake.Region)
+	s.AddRates(prepayCode, octopus.StandardUnitRates, []octopus.RateInterval{{ValidFrom: from, ValueExcVat: 25, ValueIncVat: 26.25, PaymentMethod: octopus.NonDirectDebit}})
+	s.AddRates(prepayCode, octopus.StandingCharges, []octopus.RateInterval{{ValidFrom: from, ValueExcVat: 50, ValueIncVat: 52.5, PaymentMethod: octopus.NonDirectDebit}})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "octonaut.sqlite3"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer db.Close()
+	o, err := NewWithClient(ctx, &octopus.Client{EndPoint: srv.URL, AccountID: fake.DefaultAccount, Key: fake.DefaultKey}, db)
+	if err != nil {
+		t.Fatalf("NewWithClient: %v", err)
+	}
+
+	to := from.AddDate(0, 0, 1)
+	for _, test := range []struct {
+		product       string
+		wantMethod    string
+		wantUnitRates int
+	}{
+		{product: prepay, wantMethod: octopus.NonDirectDebit, wantUnitRates: 1},
+		// Flexible has rates for both payment methods, of which only the direct debit ones are stored.
+		{product: fake.Variable, wantMethod: octopus.DirectDebit, wantUnitRates: 1},
+	} {
+		tariff, err := o.ResolveTariff(ctx, test.product, "E", "1R", fake.Region, "")
+		if err != nil {
+			t.Fatalf("ResolveTariff(%s): %v", test.product, err)
+		}
+		if got := tariff.RatePaymentMethod(); got != test.wantMethod {
+			t.Errorf("%s: got rates for %q, want %q", test.product, got, test.wantMethod)
+		}
+		if err := o.SyncResolvedTariff(ctx, tariff, from, to); err != nil {
+			t.Fatalf("SyncResolvedTariff(%s): %v", tariff.Code, err)
+		}
+		rates, err := o.TariffRates(ctx, tariff.Code, octopus.StandardUnitRates, from, to)
+		if err != nil {
+			t.Fatalf("TariffRates(%s): %v", tariff.Code, err)
+		}
+		if got := len(rates.Results); got != test.wantUnitRates {
+			t.Errorf("%s: got %d unit rates, want %d", tariff.Code, got, test.wantUnitRates)
+		}
+		if _, err := o.StandingCharges(ctx, tariff.Code, from, to); err != nil {
+			t.Errorf("StandingCharges(%s): %v", tariff.Code, err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package octonaut
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration upgrades the database schema by a single version.
+type migration func(ctx context.Context, tx *sql.Tx) error
+
+// migrations are applied in order to bring a database up to date, the schema version of a database
+// is the number of migrations which have been applied to it.
+//
+// Migrations must never be changed or removed once released, add a new one instead.
+var migrations = []migration{
+	migrateV1,
+	migrateV2,
+	migrateV3,
+	migrateV4,
+	migrateV5,
+	migrateV6,
+}
+
+// SchemaVersion is the version of the database schema used by this version of octonaut.
+var SchemaVersion = len(migrations)
+
+// migrate applies any migrations which the database is missing, refusing to touch databases which
+// were created by a newer version of octonaut.
+// Each migration is applied in its own transaction.
+func migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS SchemaVersion(
+			Version	INTEGER NOT NULL
+		);
+		`); err != nil {
+		return fmt.Errorf("failed to create SchemaVersion table: %v", err)
+	}
+	v, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+	if v > SchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than this version of octonaut supports (%d), please upgrade octonaut", v, SchemaVersion)
+	}
+	for ; v < SchemaVersion; v++ {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %v", err)
+		}
+		if err := migrations[v](ctx, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration to version %d failed: %v", v+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM SchemaVersion`); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to clear schema version: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO SchemaVersion (Version) VALUES ($1)`, v+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to set schema version: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration to version %d: %v", v+1, err)
+		}
+	}
+	return nil
+}
+
+// schemaVersion returns the schema version of the database, databases from before versioning was
+// introduced are version 0.
+func schemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var v sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(Version) FROM SchemaVersion`).Scan(&v); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return int(v.Int64), nil
+}
+
+// migrateV1 creates the original schema.
+// Tables are only created if they don't already exist, so that databases from before versioning was
+// introduced are adopted as-is.
+func migrateV1(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS Account(
+			Number	string NOT NULL PRIMARY KEY,
+			JSON	string
+		);
+		`); err != nil {
+		return fmt.Errorf("failed to create Account table: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS Consumption(
+			Account			string NOT NULL,
+			MPAN			string NOT NULL,
+			Meter			string NOT NULL,
+			IntervalStart	Timestamp NOT NULL,
+			IntervalEnd		Timestamp,
+			kWh				REAL NOT NULL,
+			PRIMARY KEY (Account, MPAN, Meter, IntervalStart));
+		`); err != nil {
+		return fmt.Errorf("create Consumption table failed: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS GasConsumption(
+			Account			string NOT NULL,
+			MPRN			string NOT NULL,
+			Meter			string NOT NULL,
+			IntervalStart	Timestamp NOT NULL,
+			IntervalEnd		Timestamp,
+			Reading			REAL NOT NULL,
+			PRIMARY KEY (Account, MPRN, Meter, IntervalStart));
+		`); err != nil {
+		return fmt.Errorf("create GasConsumption table failed: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS ExportConsumption(
+			Account			string NOT NULL,
+			MPAN			string NOT NULL,
+			Meter			string NOT NULL,
+			IntervalStart	Timestamp NOT NULL,
+			IntervalEnd		Timestamp,
+			kWh				REAL NOT NULL,
+			PRIMARY KEY (Account, MPAN, Meter, IntervalStart));
+		`); err != nil {
+		return fmt.Errorf("create ExportConsumption table failed: %v", err)
+	}
+	// TariffRate only caches data from the API, so if it's from before rate types were
+	// distinguished simply drop it and let it be re-synced.
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info('TariffRate') WHERE name = 'RateType'`).Scan(&n); err != nil {
+		return fmt.Errorf("failed to inspect TariffRate table: %v", err)
+	}
+	if n == 0 {
+		if _, err := tx.ExecContext(ctx, `DROP TABLE IF EXISTS TariffRate`); err != nil {
+			return fmt.Errorf("failed to drop old TariffRate table: %v", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS TariffRate(
+			Code			string NOT NULL,
+			RateType		string NOT NULL,
+			ValidFrom		Timestamp NOT NULL,
+			ValidTo			Timestamp,
+			UnitCostIncVAT	REAL NOT NULL,
+			PRIMARY KEY (Code, RateType, ValidFrom ASC));
+		`); err != nil {
+		return fmt.Errorf("create TariffRate table failed: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS StandingCharge(
+			Code			string NOT NULL,
+			ValidFrom		Timestamp NOT NULL,
+			ValidTo			Timestamp,
+			DailyCostIncVAT	REAL NOT NULL,
+			PRIMARY KEY (Code, ValidFrom ASC));
+		`); err != nil {
+		return fmt.Errorf("create StandingCharge table failed: %v", err)
+	}
+	return nil
+}
+
+// migrateV2 adds VAT exclusive prices to the tariff rates and standing charges.
+// The tables only cache data from the API, so existing rows, which don't have VAT exclusive prices, are
+// dropped to be re-synced.
+func migrateV2(ctx context.Context, tx *sql.Tx) error {
+	for _, q := range []string{
+		`DELETE FROM TariffRate`,
+		`ALTER TABLE TariffRate ADD COLUMN UnitCostExcVAT REAL NOT NULL DEFAULT 0`,
+		`DELETE FROM StandingCharge`,
+		`ALTER TABLE StandingCharge ADD COLUMN DailyCostExcVAT REAL NOT NULL DEFAULT 0`,
+	} {
+		if _, err := tx.ExecContext(ctx, q); err != nil {
+			return fmt.Errorf("%q failed: %v", q, err)
+		}
+	}
+	return nil
+}
+
+// migrateV3 replaces the year 1 ValidTo timestamps which used to be stored for open-ended unit rates with NULL.
+func migrateV3(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE TariffRate SET ValidTo = NULL WHERE ValidTo < 0`); err != nil {
+		return fmt.Errorf("failed to fix open-ended TariffRates: %v", err)
+	}
+	return nil
+}
+
+// migrateV4 adds the PropertyRegion table, which holds the region (GSP group) of each property.
+func migrateV4(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS PropertyRegion(
+			Account		string NOT NULL,
+			Property	INTEGER NOT NULL,
+			Region		string NOT NULL,
+			Source		string NOT NULL,
+			PRIMARY KEY (Account, Property));
+		`); err != nil {
+		return fmt.Errorf("create PropertyRegion table failed: %v", err)
+	}
+	return nil
+}
+
+// migrateV5 adds tables which cache products, the tariffs they offer in each region, and the links to
+// those tariffs' rates.
+func migrateV5(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS Product(
+			Code			string NOT NULL PRIMARY KEY,
+			JSON			string NOT NULL,
+			AvailableFrom	Timestamp NOT NULL,
+			AvailableTo		Timestamp,
+			Listed			Timestamp,
+			TariffsActiveAt	Timestamp,
+			DetailFetched	Timestamp);
+		`); err != nil {
+		return fmt.Errorf("create Product table failed: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS ProductTariff(
+			Product			string NOT NULL,
+			Fuel			string NOT NULL,
+			Registers		string NOT NULL,
+			Region			string NOT NULL,
+			PaymentMethod	string NOT NULL,
+			Code			string NOT NULL,
+			JSON			string NOT NULL,
+			PRIMARY KEY (Product, Fuel, Registers, Region, PaymentMethod));
+		`); err != nil {
+		return fmt.Errorf("create ProductTariff table failed: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS TariffLink(
+			Code	string NOT NULL,
+			Rel		string NOT NULL,
+			Href	string NOT NULL,
+			PRIMARY KEY (Code, Rel));
+		`); err != nil {
+		return fmt.Errorf("create TariffLink table failed: %v", err)
+	}
+	return nil
+}
+
+// migrateV6 adds the GasMeter table, which holds the units each gas meter reports its readings in.
+func migrateV6(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS GasMeter(
+			Account		string NOT NULL,
+			MPRN		string NOT NULL,
+			Meter		string NOT NULL,
+			Units		string NOT NULL,
+			Source		string NOT NULL,
+			PRIMARY KEY (Account, MPRN, Meter));
+		`); err != nil {
+		return fmt.Errorf("create GasMeter table failed: %v", err)
+	}
+	return nil
+}
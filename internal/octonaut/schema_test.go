@@ -0,0 +1,141 @@
+package octonaut
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// openFixture returns a database populated by the named SQL file in testdata.
+func openFixture(t *testing.T, name string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "octonaut.sqlite3"))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if name == "" {
+		return db
+	}
+	s, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if _, err := db.Exec(string(s)); err != nil {
+		t.Fatalf("failed to load fixture %q: %v", name, err)
+	}
+	return db
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return n
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name     string
+		fixture  string
+		wantRows map[string]int
+		// check, if set, makes further checks of the migrated database.
+		check func(t *testing.T, db *sql.DB)
+	}{
+		{
+			name:     "empty",
+			wantRows: map[string]int{"Account": 0, "Consumption": 0, "TariffRate": 0, "StandingCharge": 0},
+		}, {
+			name:    "original",
+			fixture: "original.sql",
+			// Tariff rates from before rate types were stored are dropped, to be re-synced.
+			wantRows: map[string]int{"Account": 1, "Consumption": 2, "TariffRate": 0, "StandingCharge": 0},
+		}, {
+			name:    "unversioned",
+			fixture: "unversioned.sql",
+			// Cached rates without VAT exclusive prices are dropped, to be re-synced.
+			wantRows: map[string]int{"Account": 1, "Consumption": 2, "TariffRate": 0, "StandingCharge": 0},
+		}, {
+			name:    "v2",
+			fixture: "v2.sql",
+			// Rates with VAT exclusive prices are kept, but the year 1 ValidTo of the open-ended one is
+			// replaced with NULL.
+			wantRows: map[string]int{"Account": 1, "Consumption": 2, "TariffRate": 2, "StandingCharge": 1},
+			check: func(t *testing.T, db *sql.DB) {
+				rows, err := db.Query(`SELECT CAST(ValidFrom AS INTEGER), CAST(ValidTo AS INTEGER), UnitCostExcVAT FROM TariffRate ORDER BY ValidFrom`)
+				if err != nil {
+					t.Fatalf("Query: %v", err)
+				}
+				defer rows.Close()
+				type rate struct {
+					from   int64
+					to     sql.NullInt64
+					excVAT float64
+				}
+				got := []rate{}
+				for rows.Next() {
+					var r rate
+					if err := rows.Scan(&r.from, &r.to, &r.excVAT); err != nil {
+						t.Fatalf("Scan: %v", err)
+					}
+					got = append(got, r)
+				}
+				if err := rows.Err(); err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+				want := []rate{
+					{from: 1704067200, to: sql.NullInt64{Int64: 1711929600, Valid: true}, excVAT: 24},
+					{from: 1711929600, excVAT: 25},
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("got TariffRates %+v, want %+v", got, want)
+				}
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db := openFixture(t, test.fixture)
+			// Opening a second time must be a no-op.
+			for i := 0; i < 2; i++ {
+				if _, err := New(ctx, "A-1234", "key", "http://localhost/", db); err != nil {
+					t.Fatalf("New: %v", err)
+				}
+				v, err := schemaVersion(ctx, db)
+				if err != nil {
+					t.Fatalf("schemaVersion: %v", err)
+				}
+				if v != SchemaVersion {
+					t.Errorf("got schema version %d, want %d", v, SchemaVersion)
+				}
+				for table, want := range test.wantRows {
+					if got := countRows(t, db, table); got != want {
+						t.Errorf("got %d rows in %s, want %d", got, table, want)
+					}
+				}
+				if test.check != nil {
+					test.check(t, db)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	ctx := context.Background()
+	db := openFixture(t, "")
+	if _, err := New(ctx, "A-1234", "key", "http://localhost/", db); err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE SchemaVersion SET Version = $1`, SchemaVersion+1); err != nil {
+		t.Fatalf("failed to bump schema version: %v", err)
+	}
+	if _, err := New(ctx, "A-1234", "key", "http://localhost/", db); err == nil {
+		t.Fatal("New succeeded with a database from a newer version, want error")
+	}
+}
@@ -0,0 +1,26 @@
+-- Schema and data as written by the first release of octonaut.
+CREATE TABLE Account(
+	Number	string NOT NULL PRIMARY KEY,
+	JSON	string
+);
+CREATE TABLE Consumption(
+	Account			string NOT NULL,
+	MPAN			string NOT NULL,
+	Meter			string NOT NULL,
+	IntervalStart	Timestamp NOT NULL,
+	IntervalEnd		Timestamp,
+	kWh				REAL NOT NULL,
+	PRIMARY KEY (Account, MPAN, Meter, IntervalStart));
+CREATE TABLE TariffRate(
+	Code			string NOT NULL,
+	ValidFrom		Timestamp NOT NULL,
+	ValidTo			Timestamp,
+	UnitCostIncVAT	REAL NOT NULL,
+	PRIMARY KEY (Code, ValidFrom ASC));
+
+INSERT INTO Account (Number, JSON) VALUES ('A-1234', '{"number":"A-1234"}');
+INSERT INTO Consumption (Account, MPAN, Meter, IntervalStart, IntervalEnd, kWh) VALUES
+	('A-1234', '1000', 'M1', 1704067200, 1704069000, 1.5),
+	('A-1234', '1000', 'M1', 1704069000, 1704070800, 2.5);
+INSERT INTO TariffRate (Code, ValidFrom, ValidTo, UnitCostIncVAT) VALUES
+	('E-1R-AGILE-23-12-06-A', 1704067200, 1704069000, 20.5);
@@ -0,0 +1,59 @@
+-- Schema and data as written by octonaut at schema version 2, when open-ended unit rates were stored with
+-- a ValidTo of the zero time, i.e. the start of year 1.
+CREATE TABLE SchemaVersion(
+	Version	INTEGER NOT NULL
+);
+INSERT INTO SchemaVersion (Version) VALUES (2);
+CREATE TABLE Account(
+	Number	string NOT NULL PRIMARY KEY,
+	JSON	string
+);
+CREATE TABLE Consumption(
+	Account			string NOT NULL,
+	MPAN			string NOT NULL,
+	Meter			string NOT NULL,
+	IntervalStart	Timestamp NOT NULL,
+	IntervalEnd		Timestamp,
+	kWh				REAL NOT NULL,
+	PRIMARY KEY (Account, MPAN, Meter, IntervalStart));
+CREATE TABLE GasConsumption(
+	Account			string NOT NULL,
+	MPRN			string NOT NULL,
+	Meter			string NOT NULL,
+	IntervalStart	Timestamp NOT NULL,
+	IntervalEnd		Timestamp,
+	Reading			REAL NOT NULL,
+	PRIMARY KEY (Account, MPRN, Meter, IntervalStart));
+CREATE TABLE ExportConsumption(
+	Account			string NOT NULL,
+	MPAN			string NOT NULL,
+	Meter			string NOT NULL,
+	IntervalStart	Timestamp NOT NULL,
+	IntervalEnd		Timestamp,
+	kWh				REAL NOT NULL,
+	PRIMARY KEY (Account, MPAN, Meter, IntervalStart));
+CREATE TABLE TariffRate(
+	Code			string NOT NULL,
+	RateType		string NOT NULL,
+	ValidFrom		Timestamp NOT NULL,
+	ValidTo			Timestamp,
+	UnitCostIncVAT	REAL NOT NULL,
+	UnitCostExcVAT	REAL NOT NULL DEFAULT 0,
+	PRIMARY KEY (Code, RateType, ValidFrom ASC));
+CREATE TABLE StandingCharge(
+	Code			string NOT NULL,
+	ValidFrom		Timestamp NOT NULL,
+	ValidTo			Timestamp,
+	DailyCostIncVAT	REAL NOT NULL,
+	DailyCostExcVAT	REAL NOT NULL DEFAULT 0,
+	PRIMARY KEY (Code, ValidFrom ASC));
+
+INSERT INTO Account (Number, JSON) VALUES ('A-1234', '{"number":"A-1234"}');
+INSERT INTO Consumption (Account, MPAN, Meter, IntervalStart, IntervalEnd, kWh) VALUES
+	('A-1234', '1000', 'M1', 1704067200, 1704069000, 1.5),
+	('A-1234', '1000', 'M1', 1704069000, 1704070800, 2.5);
+INSERT INTO TariffRate (Code, RateType, ValidFrom, ValidTo, UnitCostIncVAT, UnitCostExcVAT) VALUES
+	('E-1R-COOP-FIX-12M-24-01-01-A', 'standard-unit-rates', 1704067200, 1711929600, 25.2, 24),
+	('E-1R-COOP-FIX-12M-24-01-01-A', 'standard-unit-rates', 1711929600, -62135596800, 26.25, 25);
+INSERT INTO StandingCharge (Code, ValidFrom, ValidTo, DailyCostIncVAT, DailyCostExcVAT) VALUES
+	('E-1R-COOP-FIX-12M-24-01-01-A', 1704067200, NULL, 46.2, 44);
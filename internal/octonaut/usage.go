@@ -1,11 +1,13 @@
 package octonaut
 
 import (
-	"bytes"
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
-	"strings"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/AlCutter/octonaut/internal/octopus"
@@ -29,54 +31,229 @@ func FlatRate(kWhCost float64) RateFn {
 	}
 }
 
+// Tariff returns a RateFn which uses the VAT inclusive prices of the tariff's rates, as charged to domestic customers.
 func Tariff(t octopus.TariffRate) RateFn {
+	return TariffVAT(t, VATDomestic)
+}
+
+// TariffVAT returns a RateFn which uses the tariff's rates with VAT applied according to the given mode.
+func TariffVAT(t octopus.TariffRate, m VATMode) RateFn {
+	switch m {
+	case VATDomestic:
+		// Use the API's VAT inclusive prices as-is, since they're what appear on domestic bills.
+		return tariff(t, func(r octopus.RateInterval) float64 { return r.ValueIncVat })
+	default:
+		vat := 1 + m.Rate()
+		return tariff(t, func(r octopus.RateInterval) float64 { return r.ValueExcVat * vat })
+	}
+}
+
+// VATMode describes how VAT is applied to prices.
+type VATMode string
+
+const (
+	// VATDomestic is the reduced rate of 5% charged on domestic energy.
+	VATDomestic VATMode = "domestic"
+	// VATBusiness is the standard rate of 20% charged on business energy.
+	VATBusiness VATMode = "business"
+	// VATExclusive excludes VAT entirely.
+	VATExclusive VATMode = "exclusive"
+)
+
+// ParseVATMode returns the VATMode named by s.
+func ParseVATMode(s string) (VATMode, error) {
+	switch m := VATMode(s); m {
+	case VATDomestic, VATBusiness, VATExclusive:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown VAT mode %q", s)
+}
+
+// Rate returns the rate of VAT applied under the mode, e.g. 0.05 for 5%.
+func (m VATMode) Rate() float64 {
+	switch m {
+	case VATDomestic:
+		return 0.05
+	case VATBusiness:
+		return 0.2
+	default:
+		return 0
+	}
+}
+
+// ExcludingVAT returns the portion of the VAT inclusive amount v which isn't VAT.
+func (m VATMode) ExcludingVAT(v float64) float64 {
+	return v / (1 + m.Rate())
+}
+
+func tariff(t octopus.TariffRate, value func(octopus.RateInterval) float64) RateFn {
 	i := 0
+	validTo := func(r octopus.RateInterval) int64 {
+		if r.ValidTo == nil {
+			// Open-ended rate.
+			return math.MaxInt64
+		}
+		return r.ValidTo.Unix()
+	}
 	return func(_ context.Context, from, to time.Time) (float64, error) {
 		fromU, toU := from.Unix(), to.Unix()
+		for i < len(t.Results) && validTo(t.Results[i]) <= fromU {
+			i++
+		}
 		if i >= len(t.Results) {
-			return 0, fmt.Errorf("no more tariff entries")
-		}
-		for i < len(t.Results) {
-			rFromU, rToU := t.Results[i].ValidFrom.Unix(), t.Results[i].ValidTo.Unix()
-			switch {
-			case toU < rFromU:
-				return 0, fmt.Errorf("interval [%d] -> [%d] is before current rate interval [%d] ->  [%d]", fromU, toU, rFromU, rToU)
-			case fromU >= rToU:
-				i++
-				continue
-			case fromU >= rFromU && toU <= rToU:
-				v := t.Results[i].ValueIncVat
-				return v, nil
-			default:
-				return 0, fmt.Errorf("What happened? from %d to %d,  rate valid from %d to %d", fromU, toU, rFromU, rToU)
+			return 0, fmt.Errorf("no more tariff entries, but need %d -> %d", fromU, toU)
+		}
+		if rFromU := t.Results[i].ValidFrom.Unix(); rFromU > fromU {
+			return 0, fmt.Errorf("interval [%d] -> [%d] is before current rate interval [%d] -> [%d]", fromU, toU, rFromU, validTo(t.Results[i]))
+		}
+		if toU <= fromU {
+			return value(t.Results[i]), nil
+		}
+
+		// Rates may be any length, from half-hourly Agile rates to daily Tracker rates or open-ended fixed ones,
+		// and needn't line up with the interval, so use the average of the rates weighted by how much of the
+		// interval each covers.
+		total, covered := float64(0), fromU
+		for j := i; j < len(t.Results) && covered < toU; j++ {
+			r := t.Results[j]
+			if rFromU := r.ValidFrom.Unix(); rFromU > covered {
+				return 0, fmt.Errorf("no rate between [%d] and [%d]", covered, rFromU)
+			}
+			end := min(validTo(r), toU)
+			total += value(r) * float64(end-covered)
+			covered = end
+		}
+		if covered < toU {
+			return 0, fmt.Errorf("no more tariff entries, but need %d -> %d", covered, toU)
+		}
+		return total / float64(toU-fromU), nil
+	}
+}
+
+// DayNight returns a RateFn for two register tariffs such as Economy 7, which charges
+// intervals starting during the night at the night rate, and all others at the day rate.
+func DayNight(day, night RateFn, isNight func(t time.Time) bool) RateFn {
+	return func(ctx context.Context, from, to time.Time) (float64, error) {
+		if isNight(from) {
+			return night(ctx, from, to)
+		}
+		return day(ctx, from, to)
+	}
+}
+
+// RatePeriod is a RateFn which applies to a period of time.
+type RatePeriod struct {
+	From time.Time
+	// To is the end of the period, or the zero time if it's open-ended.
+	To   time.Time
+	Rate RateFn
+}
+
+// Contains returns true if t falls within the period, which includes its start but not its end.
+func (p RatePeriod) Contains(t time.Time) bool {
+	return !t.Before(p.From) && (p.To.IsZero() || t.Before(p.To))
+}
+
+// ErrNotScheduled is returned by a Schedule for intervals which start outside all of its periods.
+var ErrNotScheduled = errors.New("no rate scheduled")
+
+// Schedule returns a RateFn which uses the rate of whichever period contains the start of each interval,
+// e.g. to price consumption under the series of tariffs which were in force over time.
+// The periods must be in time order. Intervals which no period contains fail with ErrNotScheduled, use
+// SplitScheduled to set them aside first.
+func Schedule(periods []RatePeriod) RateFn {
+	return func(ctx context.Context, from, to time.Time) (float64, error) {
+		for _, p := range periods {
+			if p.Contains(from) {
+				return p.Rate(ctx, from, to)
 			}
 		}
-		return 0, fmt.Errorf("no more tariff entries, but need %d -> %d", fromU, toU)
+		return 0, fmt.Errorf("%w for %v", ErrNotScheduled, from)
 	}
 }
 
+// SplitScheduled separates the intervals of cons which start within one of the periods from those which
+// don't. The unscheduled intervals are merged into runs of consecutive intervals, with their total
+// consumption, so that gaps in the schedule can be reported.
+func SplitScheduled(cons Consumption, periods []RatePeriod) (Consumption, []ConsumptionInterval) {
+	scheduled, gaps := Consumption{}, []ConsumptionInterval{}
+	for _, c := range cons.Intervals {
+		in := false
+		for _, p := range periods {
+			if p.Contains(c.Start) {
+				in = true
+				break
+			}
+		}
+		switch {
+		case in:
+			scheduled.Intervals = append(scheduled.Intervals, c)
+		case len(gaps) > 0 && gaps[len(gaps)-1].End.Equal(c.Start):
+			gaps[len(gaps)-1].End = c.End
+			gaps[len(gaps)-1].Consumption += c.Consumption
+		default:
+			gaps = append(gaps, c)
+		}
+	}
+	return scheduled, gaps
+}
+
 type Cost struct {
 	TotalCost        float64
 	TotalConsumption float64
 	IntervalCosts    []ConsumptionIntervalCost
 }
 
+// IntervalStat is a set of extra per-interval columns to write alongside costs in Cost.ToCSV.
 type IntervalStat interface {
 	Headers() []string
 	NumIntervals() int
 	Interval(i int) []any
 }
 
-func csvify(as []any) []byte {
-	r := [][]byte{}
-	for _, a := range as {
-		r = append(r, []byte(fmt.Sprintf("%v", a)))
-	}
-	return bytes.Join(r, []byte{','})
+// CSVOptions controls how values are formatted by Cost.ToCSV.
+type CSVOptions struct {
+	// Precision is the number of decimal places to write numbers with, so 0 rounds them to integers.
+	// If it's nil or -1, as many are used as necessary to represent them exactly.
+	Precision *int
+	// Pounds writes rates and costs in pounds, rather than pence.
+	Pounds bool
+	// Location is the time zone used for timestamps, which defaults to UTC.
+	Location *time.Location
+}
 
+// format returns v formatted as a CSV field.
+// Booleans are written as 1 or 0, and timestamps in RFC 3339 format with their UTC offset.
+func (o CSVOptions) format(v any) string {
+	prec := -1
+	if o.Precision != nil {
+		prec = *o.Precision
+	}
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', prec, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', prec, 32)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		loc := o.Location
+		if loc == nil {
+			loc = time.UTC
+		}
+		return v.In(loc).Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
 }
 
-func (c *Cost) ToCSV(w io.Writer, stats ...IntervalStat) error {
+// ToCSV writes the cost of each interval to w as CSV, along with the columns from any stats.
+// The Start, End, Consumption, Rate, and Cost columns are always written first, in that order.
+// Consumption is in kWh, and the rate and cost are in pence per kWh and pence unless
+// opts.Pounds is set.
+func (c *Cost) ToCSV(w io.Writer, opts CSVOptions, stats ...IntervalStat) error {
 	headers := []string{"Start", "End", "Consumption", "Rate", "Cost"}
 	l := len(c.IntervalCosts)
 	for _, s := range stats {
@@ -85,20 +262,29 @@ func (c *Cost) ToCSV(w io.Writer, stats ...IntervalStat) error {
 			return fmt.Errorf("got %d cost intervals, but stats (%T) has %d intervals", l, s, sl)
 		}
 	}
-	if _, err := w.Write(append([]byte(strings.Join(headers, ",")), '\n')); err != nil {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(headers); err != nil {
 		return fmt.Errorf("writing headers: %v", err)
 	}
+	unit := 1.0
+	if opts.Pounds {
+		unit = 100
+	}
 	for i, ci := range c.IntervalCosts {
-		vs := []any{ci.Start.Format(time.RFC3339), ci.End.Format(time.RFC3339), ci.Consumption, ci.Rate, ci.Cost}
+		vs := []any{ci.Start, ci.End, ci.Consumption, ci.Rate / unit, ci.Cost / unit}
 		for _, s := range stats {
 			vs = append(vs, s.Interval(i)...)
 		}
-		if _, err := w.Write(append(csvify(vs), '\n')); err != nil {
-			return fmt.Errorf("writing headers: %v", err)
+		rec := make([]string, 0, len(vs))
+		for _, v := range vs {
+			rec = append(rec, opts.format(v))
+		}
+		if err := cw.Write(rec); err != nil {
+			return fmt.Errorf("writing interval %d: %v", i, err)
 		}
 	}
-
-	return nil
+	cw.Flush()
+	return cw.Error()
 }
 
 type ConsumptionIntervalCost struct {
@@ -125,3 +311,51 @@ func TotalCost(ctx context.Context, cons Consumption, c RateFn) (*Cost, error) {
 	}
 	return &r, nil
 }
+
+// Standing describes the standing charges incurred over a period.
+type Standing struct {
+	TotalCost  float64
+	DailyCosts []DailyStandingCharge
+}
+
+// DailyStandingCharge is the standing charge levied for a single day.
+type DailyStandingCharge struct {
+	Start time.Time
+	End   time.Time
+	Cost  float64
+}
+
+// StartOfDay returns midnight at the start of the day containing t, in the given location.
+func StartOfDay(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// StandingCost calculates the standing charges for each whole day between from and to, using
+// the daily rate which was valid at the start of each day.
+// Days start at midnight in the given location, so may be 23 or 25 hours long when the clocks change.
+// If from isn't midnight, the day containing it isn't whole, so isn't charged.
+func StandingCost(ctx context.Context, from, to time.Time, loc *time.Location, c RateFn) (*Standing, error) {
+	r := Standing{}
+	first := StartOfDay(from, loc)
+	if first.Before(from) {
+		first = first.AddDate(0, 0, 1)
+	}
+	for d := first; d.Before(to); d = d.AddDate(0, 0, 1) {
+		e := d.AddDate(0, 0, 1)
+		if e.After(to) {
+			break
+		}
+		p, err := c(ctx, d, d)
+		if err != nil {
+			return nil, fmt.Errorf("standing charge for %v: %v", d, err)
+		}
+		r.TotalCost += p
+		r.DailyCosts = append(r.DailyCosts, DailyStandingCharge{
+			Start: d,
+			End:   e,
+			Cost:  p,
+		})
+	}
+	return &r, nil
+}
@@ -0,0 +1,358 @@
+package octonaut
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+)
+
+func TestStandingCostClockChanges(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	for _, test := range []struct {
+		name      string
+		from, to  time.Time
+		wantDays  int
+		wantHours []float64
+	}{
+		{
+			name:      "spring forward",
+			from:      time.Date(2024, 3, 30, 0, 0, 0, 0, london),
+			to:        time.Date(2024, 4, 1, 0, 0, 0, 0, london),
+			wantDays:  2,
+			wantHours: []float64{24, 23},
+		}, {
+			name:      "fall back",
+			from:      time.Date(2024, 10, 26, 0, 0, 0, 0, london),
+			to:        time.Date(2024, 10, 28, 0, 0, 0, 0, london),
+			wantDays:  2,
+			wantHours: []float64{24, 25},
+		}, {
+			name: "summer days start at 23:00 UTC",
+			// 23:00 UTC is midnight in London during BST.
+			from:      time.Date(2024, 6, 30, 23, 0, 0, 0, time.UTC),
+			to:        time.Date(2024, 7, 2, 23, 0, 0, 0, time.UTC),
+			wantDays:  2,
+			wantHours: []float64{24, 24},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := StandingCost(context.Background(), test.from, test.to, london, FlatRate(50))
+			if err != nil {
+				t.Fatalf("StandingCost: %v", err)
+			}
+			if got := len(s.DailyCosts); got != test.wantDays {
+				t.Fatalf("got %d days, want %d", got, test.wantDays)
+			}
+			if got, want := s.TotalCost, float64(test.wantDays*50); got != want {
+				t.Errorf("got total %f, want %f", got, want)
+			}
+			for i, d := range s.DailyCosts {
+				if got := d.End.Sub(d.Start).Hours(); got != test.wantHours[i] {
+					t.Errorf("day %d: got %f hours, want %f", i, got, test.wantHours[i])
+				}
+				if h, m, _ := d.Start.In(london).Clock(); h != 0 || m != 0 {
+					t.Errorf("day %d starts at %v, want local midnight", i, d.Start)
+				}
+			}
+		})
+	}
+}
+
+func TestDayNight(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	// Economy 7 meters keep to UTC, so the night window is parsed in UTC as the model command does.
+	isNight, err := ParseWindow("0.5-7.5", time.UTC)
+	if err != nil {
+		t.Fatalf("ParseWindow: %v", err)
+	}
+	const day, night = 30.0, 10.0
+	rates := DayNight(FlatRate(day), FlatRate(night), isNight)
+	for _, test := range []struct {
+		name  string
+		start time.Time
+		want  float64
+	}{
+		{name: "before night", start: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), want: day},
+		{name: "start of night", start: time.Date(2024, 1, 15, 0, 30, 0, 0, time.UTC), want: night},
+		{name: "last night interval", start: time.Date(2024, 1, 15, 7, 0, 0, 0, time.UTC), want: night},
+		{name: "end of night", start: time.Date(2024, 1, 15, 7, 30, 0, 0, time.UTC), want: day},
+		{name: "late evening", start: time.Date(2024, 1, 15, 23, 30, 0, 0, time.UTC), want: day},
+		// During BST the night window is an hour later on the wall clock.
+		{name: "summer 01:00 local", start: time.Date(2024, 7, 1, 1, 0, 0, 0, london), want: day},
+		{name: "summer 01:30 local", start: time.Date(2024, 7, 1, 1, 30, 0, 0, london), want: night},
+		{name: "summer 08:00 local", start: time.Date(2024, 7, 1, 8, 0, 0, 0, london), want: night},
+		{name: "summer 08:30 local", start: time.Date(2024, 7, 1, 8, 30, 0, 0, london), want: day},
+		// The clocks go forward at 01:00 UTC, in the middle of the night, which doesn't affect the meter.
+		{name: "spring forward before change", start: time.Date(2024, 3, 31, 0, 30, 0, 0, time.UTC), want: night},
+		{name: "spring forward after change", start: time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC), want: night},
+		{name: "spring forward end of night", start: time.Date(2024, 3, 31, 7, 30, 0, 0, time.UTC), want: day},
+		// The clocks go back at 01:00 UTC, so 01:30 BST and 01:30 GMT are both during the night.
+		{name: "fall back first 01:30", start: time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC), want: night},
+		{name: "fall back second 01:30", start: time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), want: night},
+		{name: "fall back end of night", start: time.Date(2024, 10, 27, 7, 30, 0, 0, time.UTC), want: day},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := rates(context.Background(), test.start, test.start.Add(30*time.Minute))
+			if err != nil {
+				t.Fatalf("rate: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got rate %f for interval starting %v, want %f", got, test.start.UTC(), test.want)
+			}
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	ctx := context.Background()
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	// Two agreements with a gap between them, e.g. after moving out and back in, then an open-ended one.
+	s := Schedule([]RatePeriod{
+		{From: day(1), To: day(3), Rate: FlatRate(10)},
+		{From: day(5), To: day(7), Rate: FlatRate(20)},
+		{From: day(7), Rate: FlatRate(30)},
+	})
+	for _, test := range []struct {
+		name    string
+		at      time.Time
+		want    float64
+		wantErr bool
+	}{
+		{name: "before", at: day(1).Add(-30 * time.Minute), wantErr: true},
+		{name: "start", at: day(1), want: 10},
+		{name: "within", at: day(2), want: 10},
+		{name: "last interval", at: day(3).Add(-30 * time.Minute), want: 10},
+		{name: "end is exclusive", at: day(3), wantErr: true},
+		{name: "gap", at: day(4), wantErr: true},
+		{name: "after gap", at: day(5), want: 20},
+		{name: "adjacent", at: day(7), want: 30},
+		{name: "open-ended", at: day(7).AddDate(1, 0, 0), want: 30},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := s(ctx, test.at, test.at.Add(30*time.Minute))
+			if test.wantErr {
+				if !errors.Is(err, ErrNotScheduled) {
+					t.Fatalf("got %v, %v, want ErrNotScheduled", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got err %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got rate %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSplitScheduled(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hh := 30 * time.Minute
+	cons := Consumption{}
+	for i := 0; i < 10; i++ {
+		s := start.Add(time.Duration(i) * hh)
+		cons.Intervals = append(cons.Intervals, ConsumptionInterval{Start: s, End: s.Add(hh), Consumption: float64(i)})
+	}
+	// Covers intervals 2-3 and 6-7, leaving gaps before, between, and after.
+	periods := []RatePeriod{
+		{From: start.Add(2 * hh), To: start.Add(4 * hh), Rate: FlatRate(10)},
+		{From: start.Add(6 * hh), To: start.Add(8 * hh), Rate: FlatRate(10)},
+	}
+	scheduled, gaps := SplitScheduled(cons, periods)
+	var got []float64
+	for _, c := range scheduled.Intervals {
+		got = append(got, c.Consumption)
+	}
+	if want := []float64{2, 3, 6, 7}; !slices.Equal(got, want) {
+		t.Errorf("got scheduled consumption %v, want %v", got, want)
+	}
+	want := []ConsumptionInterval{
+		{Start: start, End: start.Add(2 * hh), Consumption: 0 + 1},
+		{Start: start.Add(4 * hh), End: start.Add(6 * hh), Consumption: 4 + 5},
+		{Start: start.Add(8 * hh), End: start.Add(10 * hh), Consumption: 8 + 9},
+	}
+	if !slices.Equal(gaps, want) {
+		t.Errorf("got gaps %+v, want %+v", gaps, want)
+	}
+}
+
+func TestTariffVAT(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	rates := octopus.TariffRate{Results: []octopus.RateInterval{
+		{ValidFrom: start, ValidTo: &end, ValueExcVat: 20, ValueIncVat: 21.0001},
+	}}
+	for _, test := range []struct {
+		mode VATMode
+		want float64
+	}{
+		// Domestic prices come straight from the API, including any rounding.
+		{mode: VATDomestic, want: 21.0001},
+		{mode: VATBusiness, want: 24},
+		{mode: VATExclusive, want: 20},
+	} {
+		got, err := TariffVAT(rates, test.mode)(ctx, start, start.Add(30*time.Minute))
+		if err != nil {
+			t.Fatalf("%s: %v", test.mode, err)
+		}
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: got rate %v, want %v", test.mode, got, test.want)
+		}
+		if got, want := test.mode.ExcludingVAT(test.mode.Rate()*100+100), 100.0; math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: got %v excluding VAT, want %v", test.mode, got, want)
+		}
+	}
+}
+
+func TestTariffRateShapes(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	hh := 30 * time.Minute
+	// rate returns a rate interval which is open-ended if to is the zero time.
+	rate := func(from, to time.Time, v float64) octopus.RateInterval {
+		r := octopus.RateInterval{ValidFrom: from, ValueIncVat: v}
+		if !to.IsZero() {
+			r.ValidTo = &to
+		}
+		return r
+	}
+	for _, test := range []struct {
+		name    string
+		rates   []octopus.RateInterval
+		n       int
+		want    []float64
+		wantErr bool
+	}{
+		{
+			name:  "half hourly",
+			rates: []octopus.RateInterval{rate(start, start.Add(hh), 10), rate(start.Add(hh), start.Add(2*hh), 20)},
+			n:     2,
+			want:  []float64{10, 20},
+		}, {
+			name:  "daily",
+			rates: []octopus.RateInterval{rate(start, start.Add(24*time.Hour), 25), rate(start.Add(24*time.Hour), start.Add(48*time.Hour), 30)},
+			n:     96,
+			want:  append(repeat(25, 48), repeat(30, 48)...),
+		}, {
+			name:  "open-ended",
+			rates: []octopus.RateInterval{rate(start.Add(-time.Hour), start.Add(hh), 10), rate(start.Add(hh), time.Time{}, 20)},
+			n:     3,
+			want:  []float64{10, 20, 20},
+		}, {
+			name:  "straddling",
+			rates: []octopus.RateInterval{rate(start, start.Add(15*time.Minute), 10), rate(start.Add(15*time.Minute), time.Time{}, 20)},
+			n:     2,
+			want:  []float64{15, 20},
+		}, {
+			name:    "gap",
+			rates:   []octopus.RateInterval{rate(start, start.Add(15*time.Minute), 10), rate(start.Add(20*time.Minute), time.Time{}, 20)},
+			n:       1,
+			wantErr: true,
+		}, {
+			name:    "starts late",
+			rates:   []octopus.RateInterval{rate(start.Add(hh), time.Time{}, 20)},
+			n:       1,
+			wantErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := Tariff(octopus.TariffRate{Results: test.rates})
+			for i := 0; i < test.n; i++ {
+				s := start.Add(time.Duration(i) * hh)
+				got, err := f(ctx, s, s.Add(hh))
+				if gotErr := err != nil; gotErr != test.wantErr {
+					t.Fatalf("interval %d: got err %v, want err %t", i, err, test.wantErr)
+				}
+				if err == nil && math.Abs(got-test.want[i]) > 1e-9 {
+					t.Errorf("interval %d: got rate %v, want %v", i, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func repeat(v float64, n int) []float64 {
+	r := make([]float64, n)
+	for i := range r {
+		r[i] = v
+	}
+	return r
+}
+
+func TestCostToCSV(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	c := &Cost{IntervalCosts: []ConsumptionIntervalCost{
+		{ConsumptionInterval: ConsumptionInterval{Start: start, End: start.Add(30 * time.Minute), Consumption: 0.123456}, Rate: 24.5, Cost: 3.5},
+		{ConsumptionInterval: ConsumptionInterval{Start: start.Add(30 * time.Minute), End: start.Add(time.Hour), Consumption: 1}, Rate: 100, Cost: 100},
+	}}
+	stats := &LoadShiftStats{Intervals: []LoadShiftIntervalStats{
+		{BatteryCharge: 1.5, BatteryFull: true},
+		{BatteryCharge: 2, BatteryDelta: -0.25},
+	}}
+
+	zero, two, full := 0, 2, -1
+	for _, test := range []struct {
+		name string
+		opts CSVOptions
+		want string
+	}{
+		{
+			name: "pence",
+			opts: CSVOptions{Precision: &two},
+			want: "Start,End,Consumption,Rate,Cost,BatteryCharge,BatteryDelta,BatteryFull,BatteryLosses,OverServiceLimit\n" +
+				"2024-06-01T00:00:00Z,2024-06-01T00:30:00Z,0.12,24.50,3.50,1.50,0.00,1,0.00,0\n" +
+				"2024-06-01T00:30:00Z,2024-06-01T01:00:00Z,1.00,100.00,100.00,2.00,-0.25,0,0.00,0\n",
+		}, {
+			name: "pounds in local time",
+			opts: CSVOptions{Precision: &full, Pounds: true, Location: london},
+			want: "Start,End,Consumption,Rate,Cost,BatteryCharge,BatteryDelta,BatteryFull,BatteryLosses,OverServiceLimit\n" +
+				"2024-06-01T01:00:00+01:00,2024-06-01T01:30:00+01:00,0.123456,0.245,0.035,1.5,0,1,0,0\n" +
+				"2024-06-01T01:30:00+01:00,2024-06-01T02:00:00+01:00,1,1,1,2,-0.25,0,0,0\n",
+		}, {
+			name: "whole numbers",
+			opts: CSVOptions{Precision: &zero},
+			want: "Start,End,Consumption,Rate,Cost,BatteryCharge,BatteryDelta,BatteryFull,BatteryLosses,OverServiceLimit\n" +
+				"2024-06-01T00:00:00Z,2024-06-01T00:30:00Z,0,24,4,2,0,1,0,0\n" +
+				"2024-06-01T00:30:00Z,2024-06-01T01:00:00Z,1,100,100,2,-0,0,0,0\n",
+		}, {
+			// The zero value uses full precision.
+			name: "zero value",
+			opts: CSVOptions{},
+			want: "Start,End,Consumption,Rate,Cost,BatteryCharge,BatteryDelta,BatteryFull,BatteryLosses,OverServiceLimit\n" +
+				"2024-06-01T00:00:00Z,2024-06-01T00:30:00Z,0.123456,24.5,3.5,1.5,0,1,0,0\n" +
+				"2024-06-01T00:30:00Z,2024-06-01T01:00:00Z,1,100,100,2,-0.25,0,0,0\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := &strings.Builder{}
+			if err := c.ToCSV(b, test.opts, stats); err != nil {
+				t.Fatalf("ToCSV: %v", err)
+			}
+			if got := b.String(); got != test.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+
+	if err := c.ToCSV(&strings.Builder{}, CSVOptions{}, &LoadShiftStats{}); err == nil {
+		t.Errorf("ToCSV with mismatched stats succeeded, want error")
+	}
+}
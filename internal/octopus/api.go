@@ -4,23 +4,49 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/charmbracelet/log"
 )
 
+// Rate types which may be requested for a tariff.
+// Single register (1R) tariffs have standard unit rates, while two register (2R) tariffs such as
+// Economy 7 have separate day and night unit rates.
+const (
+	StandardUnitRates = "standard-unit-rates"
+	DayUnitRates      = "day-unit-rates"
+	NightUnitRates    = "night-unit-rates"
+	// StandingCharges isn't a unit rate, but its endpoint is alongside them.
+	StandingCharges = "standing-charges"
+)
+
 func accountPath(a string) string { return fmt.Sprintf("v1/accounts/%s/", a) }
 func consumptionPath(mpan string, serial string, from, to time.Time, N int) string {
 	return fmt.Sprintf("v1/electricity-meter-points/%s/meters/%s/consumption/?page_size=%d&period_from=%s&period_to=%s&order_by=period",
 		mpan, serial, N, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
 }
+func gasConsumptionPath(mprn string, serial string, from, to time.Time, N int) string {
+	return fmt.Sprintf("v1/gas-meter-points/%s/meters/%s/consumption/?page_size=%d&period_from=%s&period_to=%s&order_by=period",
+		mprn, serial, N, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+}
 func tariffRatePath(product string, fuel string, tariff string, rate string, from, to time.Time, N int) string {
 	return fmt.Sprintf("v1/products/%s/%s-tariffs/%s/%s/?page_size=%d&period_from=%s&period_to=%s", product, fuel, tariff, rate, N, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
 }
+func standingChargesPath(product string, fuel string, tariff string, from, to time.Time, N int) string {
+	return tariffRatePath(product, fuel, tariff, "standing-charges", from, to, N)
+}
+func gridSupplyPointsPath(postcode string) string {
+	return fmt.Sprintf("v1/industry/grid-supply-points/?postcode=%s", url.QueryEscape(postcode))
+}
+func productPath(code string) string { return fmt.Sprintf("v1/products/%s/", code) }
 func productsPath(availableAt *time.Time) string {
 	r := "v1/products/"
 	if availableAt != nil {
@@ -52,10 +78,25 @@ type ElectricityMeterPoint struct {
 	MPAN                string      `json:"mpan"`
 	ProfileClass        int         `json:"profile_class"`
 	ConsumptionStandard int         `json:"consumption_standard"`
+	IsExport            bool        `json:"is_export"`
 	Meters              []Meter     `json:"meters"`
 	Agreements          []Agreement `json:"agreements"`
 }
 
+// Export returns true if the meter point measures electricity exported to the grid, e.g. from solar panels.
+// Older account data doesn't always flag export meter points, so the tariffs agreed for it are checked too.
+func (em ElectricityMeterPoint) Export() bool {
+	if em.IsExport {
+		return true
+	}
+	for _, a := range em.Agreements {
+		if IsExportTariff(a.TariffCode) {
+			return true
+		}
+	}
+	return false
+}
+
 func (em ElectricityMeterPoint) ActiveMeters() []Meter {
 	r := []Meter{}
 	for i := range em.Meters {
@@ -104,15 +145,43 @@ type GasMeterPoints struct {
 	Agreements          []Agreement `json:"agreements"`
 }
 
+func (gm GasMeterPoints) ActiveMeters() []Meter {
+	r := []Meter{}
+	for i := range gm.Meters {
+		if gm.Meters[i].SerialNumber != "" {
+			r = append(r, gm.Meters[i])
+		}
+	}
+	return r
+}
+
+func (gm GasMeterPoints) ActiveAgreement(at time.Time) *Agreement {
+	for _, a := range gm.Agreements {
+		if at.Before(a.ValidFrom) {
+			continue
+		}
+		if a.ValidTo != nil && at.After(*a.ValidTo) {
+			continue
+		}
+		return &a
+	}
+	return nil
+}
+
+// Consumption holds consumption readings from a meter.
+// Electricity consumption is always in kWh, while gas consumption is reported in kWh by SMETS1
+// meters and in m³ by SMETS2 meters.
 type Consumption struct {
-	Count    int    `json:"count"`
-	Next     string `json:"next"`
-	Previous string `json:"previous"`
-	Results  []struct {
-		Consumption   float64   `json:"consumption"`
-		IntervalStart time.Time `json:"interval_start"`
-		IntervalEnd   time.Time `json:"interval_end"`
-	} `json:"results"`
+	Count    int                  `json:"count"`
+	Next     string               `json:"next"`
+	Previous string               `json:"previous"`
+	Results  []ConsumptionReading `json:"results"`
+}
+
+type ConsumptionReading struct {
+	Consumption   float64   `json:"consumption"`
+	IntervalStart time.Time `json:"interval_start"`
+	IntervalEnd   time.Time `json:"interval_end"`
 }
 
 type TariffRate struct {
@@ -126,7 +195,31 @@ type RateInterval struct {
 	ValueExcVat float64   `json:"value_exc_vat"`
 	ValueIncVat float64   `json:"value_inc_vat"`
 	ValidFrom   time.Time `json:"valid_from"`
-	ValidTo     time.Time `json:"valid_to"`
+	// ValidTo is nil for open-ended rates, e.g. the current rates of fixed and variable tariffs.
+	ValidTo *time.Time `json:"valid_to"`
+	// PaymentMethod is set for tariffs whose prices depend on how the customer pays, otherwise it's empty.
+	PaymentMethod string `json:"payment_method"`
+}
+
+// Payment methods of rates, as used in RateInterval.
+const (
+	// DirectDebit is the payment method used by most customers, and whose prices are used for modelling.
+	DirectDebit = "DIRECT_DEBIT"
+	// NonDirectDebit covers customers paying on receipt of a bill, or with a prepayment meter.
+	NonDirectDebit = "NON_DIRECT_DEBIT"
+)
+
+// ForPaymentMethod returns only the rates which apply to customers using the given payment method,
+// i.e. those for that method and those which don't depend on how the customer pays.
+func (t TariffRate) ForPaymentMethod(m string) TariffRate {
+	r := t
+	r.Results = nil
+	for _, ri := range t.Results {
+		if ri.PaymentMethod == "" || ri.PaymentMethod == m {
+			r.Results = append(r.Results, ri)
+		}
+	}
+	return r
 }
 
 type Products struct {
@@ -137,33 +230,173 @@ type Products struct {
 }
 
 type Product struct {
-	Code          string      `json:"code"`
-	FullName      string      `json:"full_name"`
-	DisplayName   string      `json:"display_name"`
-	Description   string      `json:"description"`
-	IsVariable    bool        `json:"is_variable"`
-	IsGreen       bool        `json:"is_green"`
-	IsTracker     bool        `json:"is_tracker"`
-	IsPrepay      bool        `json:"is_prepay"`
-	IsBusiness    bool        `json:"is_business"`
-	IsRestricted  bool        `json:"is_restricted"`
-	Term          int         `json:"term"`
-	Brand         string      `json:"brand"`
-	AvailableFrom time.Time   `json:"available_from"`
-	AvailableTo   interface{} `json:"available_to"`
-	Links         []struct {
-		Href   string `json:"href"`
-		Method string `json:"method"`
-		Rel    string `json:"rel"`
-	} `json:"links"`
+	Code          string     `json:"code"`
+	FullName      string     `json:"full_name"`
+	DisplayName   string     `json:"display_name"`
+	Description   string     `json:"description"`
+	IsVariable    bool       `json:"is_variable"`
+	IsGreen       bool       `json:"is_green"`
+	IsTracker     bool       `json:"is_tracker"`
+	IsPrepay      bool       `json:"is_prepay"`
+	IsBusiness    bool       `json:"is_business"`
+	IsRestricted  bool       `json:"is_restricted"`
+	Direction     string     `json:"direction"`
+	Term          int        `json:"term"`
+	Brand         string     `json:"brand"`
+	AvailableFrom time.Time  `json:"available_from"`
+	AvailableTo   *time.Time `json:"available_to"`
+	Links         []Link     `json:"links"`
+}
+
+// Link is a link to a related resource in the API.
+type Link struct {
+	Href   string `json:"href"`
+	Method string `json:"method"`
+	Rel    string `json:"rel"`
+}
+
+// Payment methods which tariffs are offered with, as used in ProductDetail.
+const (
+	DirectDebitMonthly   = "direct_debit_monthly"
+	DirectDebitQuarterly = "direct_debit_quarterly"
+	Varying              = "varying"
+	Prepayment           = "prepayment"
+)
+
+// tariffPaymentMethods is the order in which payment methods are preferred if none is requested, direct
+// debit prices being those used for modelling.
+var tariffPaymentMethods = []string{DirectDebitMonthly, DirectDebitQuarterly, Varying, Prepayment}
+
+// ProductDetail is a product along with the tariffs it offers.
+type ProductDetail struct {
+	Product
+	TariffsActiveAt time.Time `json:"tariffs_active_at"`
+
+	SingleRegisterElectricityTariffs RegionalTariffs `json:"single_register_electricity_tariffs"`
+	DualRegisterElectricityTariffs   RegionalTariffs `json:"dual_register_electricity_tariffs"`
+	SingleRegisterGasTariffs         RegionalTariffs `json:"single_register_gas_tariffs"`
+}
+
+// RegionalTariffs holds the tariffs offered in each region, keyed by the region with a leading
+// underscore (e.g. "_C"), and then by payment method.
+type RegionalTariffs map[string]map[string]Tariff
+
+// Tariff is a tariff offered by a product, with its current prices in pence.
+type Tariff struct {
+	Code                   string  `json:"code"`
+	StandingChargeExcVAT   float64 `json:"standing_charge_exc_vat"`
+	StandingChargeIncVAT   float64 `json:"standing_charge_inc_vat"`
+	StandardUnitRateExcVAT float64 `json:"standard_unit_rate_exc_vat"`
+	StandardUnitRateIncVAT float64 `json:"standard_unit_rate_inc_vat"`
+	DayUnitRateExcVAT      float64 `json:"day_unit_rate_exc_vat"`
+	DayUnitRateIncVAT      float64 `json:"day_unit_rate_inc_vat"`
+	NightUnitRateExcVAT    float64 `json:"night_unit_rate_exc_vat"`
+	NightUnitRateIncVAT    float64 `json:"night_unit_rate_inc_vat"`
+	Links                  []Link  `json:"links"`
+
+	// PaymentMethod is the payment method the tariff is offered with, e.g. DirectDebitMonthly, which isn't
+	// part of the tariff's own JSON, but is set by ProductDetail.Tariff.
+	PaymentMethod string `json:"-"`
+}
+
+// RatePaymentMethod returns the payment method of the tariff's rates which apply to it, for use with
+// TariffRate.ForPaymentMethod: DirectDebit for direct debit tariffs, and NonDirectDebit for the rest.
+func (t Tariff) RatePaymentMethod() string {
+	switch t.PaymentMethod {
+	case Varying, Prepayment:
+		return NonDirectDebit
+	}
+	return DirectDebit
+}
+
+// Link returns the URL of the tariff's rates of the given type (e.g. StandardUnitRates or StandingCharges),
+// or an empty string if it doesn't have any.
+func (t Tariff) Link(rateType string) string {
+	rel := strings.ReplaceAll(rateType, "-", "_")
+	for _, l := range t.Links {
+		if l.Rel == rel {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// UnitRateTypes returns the types of unit rate which the tariff has, i.e. standard unit rates for single
+// register tariffs, and day and night unit rates for dual register ones.
+func (t Tariff) UnitRateTypes() []string {
+	r := []string{}
+	for _, rt := range []string{StandardUnitRates, DayUnitRates, NightUnitRates} {
+		if t.Link(rt) != "" {
+			r = append(r, rt)
+		}
+	}
+	return r
+}
+
+// TariffTypes are the fuel and register types which products may offer tariffs for.
+var TariffTypes = []struct{ Fuel, Registers string }{{"E", "1R"}, {"E", "2R"}, {"G", "1R"}}
+
+// Tariffs returns the product's tariffs for the fuel ("E" or "G") and register type ("1R" or "2R"),
+// or nil if there's no such combination.
+func (p *ProductDetail) Tariffs(fuel, registers string) *RegionalTariffs {
+	switch fuel + "-" + registers {
+	case "E-1R":
+		return &p.SingleRegisterElectricityTariffs
+	case "E-2R":
+		return &p.DualRegisterElectricityTariffs
+	case "G-1R":
+		return &p.SingleRegisterGasTariffs
+	}
+	return nil
+}
+
+// Tariff returns the product's tariff for the fuel ("E" or "G"), register type ("1R" or "2R"), region,
+// and payment method. If paymentMethod is empty, direct debit tariffs are preferred.
+// An error wrapping ErrNotOffered is returned if the product doesn't offer such a tariff.
+func (p ProductDetail) Tariff(fuel, registers, region, paymentMethod string) (*Tariff, error) {
+	tsp := p.Tariffs(fuel, registers)
+	if tsp == nil {
+		return nil, fmt.Errorf("unknown fuel and register type %s-%s", fuel, registers)
+	}
+	ts := *tsp
+	if len(ts) == 0 {
+		return nil, fmt.Errorf("%s has no %s-%s tariffs: %w", p.Code, fuel, registers, ErrNotOffered)
+	}
+	byMethod, ok := ts["_"+region]
+	if !ok {
+		return nil, fmt.Errorf("%s has no %s-%s tariffs in region %s: %w", p.Code, fuel, registers, region, ErrNotOffered)
+	}
+	methods := tariffPaymentMethods
+	if paymentMethod != "" {
+		methods = []string{paymentMethod}
+	}
+	for _, m := range methods {
+		if t, ok := byMethod[m]; ok {
+			t.PaymentMethod = m
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no %s-%s tariffs in region %s paid by %v: %w", p.Code, fuel, registers, region, methods, ErrNotOffered)
 }
 
 type Client struct {
 	EndPoint  string
 	AccountID string
 	Key       string
+
+	// HTTPClient is used to make requests to the API, http.DefaultClient is used if it's nil.
+	HTTPClient *http.Client
+	// MaxRetries is the number of times a request which failed due to throttling, a server error,
+	// a timeout, or a refused or reset connection will be retried. Requests aren't retried if the
+	// server asks for a longer delay than maxRetryBackoff.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, which doubles for each subsequent attempt
+	// up to maxRetryBackoff. Defaults to one second.
+	RetryBackoff time.Duration
 }
 
+const maxRetryBackoff = 2 * time.Minute
+
 func (c *Client) Account(ctx context.Context) (Account, error) {
 	r := Account{}
 	return r, c.get(ctx, accountPath(c.AccountID), &r)
@@ -171,9 +404,17 @@ func (c *Client) Account(ctx context.Context) (Account, error) {
 
 func (c *Client) Consumption(ctx context.Context, mpan string, serial string, from time.Time, to time.Time) (Consumption, error) {
 	N := 2000
+	return c.consumption(ctx, consumptionPath(mpan, serial, from, to, N))
+}
+
+// GasConsumption returns the readings from the given gas meter between from and to.
+func (c *Client) GasConsumption(ctx context.Context, mprn string, serial string, from time.Time, to time.Time) (Consumption, error) {
+	N := 2000
+	return c.consumption(ctx, gasConsumptionPath(mprn, serial, from, to, N))
+}
 
+func (c *Client) consumption(ctx context.Context, req string) (Consumption, error) {
 	r := Consumption{}
-	req := consumptionPath(mpan, serial, from, to, N)
 	for req != "" {
 		page := Consumption{}
 		if err := c.get(ctx, req, &page); err != nil {
@@ -189,9 +430,34 @@ func (c *Client) Consumption(ctx context.Context, mpan string, serial string, fr
 
 func (c *Client) TariffRates(ctx context.Context, prod, fuel, tariff, rate string, from time.Time, to time.Time) (TariffRate, error) {
 	N := 2000
+	return c.rates(ctx, tariffRatePath(prod, fuel, tariff, rate, from, to, N))
+}
+
+// StandingCharges returns the daily standing charges for the given tariff which were valid between from and to.
+func (c *Client) StandingCharges(ctx context.Context, prod, fuel, tariff string, from time.Time, to time.Time) (TariffRate, error) {
+	N := 2000
+	return c.rates(ctx, standingChargesPath(prod, fuel, tariff, from, to, N))
+}
+
+// LinkedRates returns the rates, or standing charges, at a link from a Tariff which were valid between
+// from and to.
+func (c *Client) LinkedRates(ctx context.Context, href string, from time.Time, to time.Time) (TariffRate, error) {
+	N := 2000
+	p, ok := strings.CutPrefix(href, c.EndPoint)
+	if !ok {
+		// Links use the canonical API host, which may not be the one we're talking to.
+		u, err := url.Parse(href)
+		if err != nil {
+			return TariffRate{}, fmt.Errorf("invalid link %q: %v", href, err)
+		}
+		p = strings.TrimPrefix(u.Path, "/")
+	}
+	p, _, _ = strings.Cut(p, "?")
+	return c.rates(ctx, fmt.Sprintf("%s?page_size=%d&period_from=%s&period_to=%s", p, N, from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)))
+}
 
+func (c *Client) rates(ctx context.Context, req string) (TariffRate, error) {
 	r := TariffRate{}
-	req := tariffRatePath(prod, fuel, tariff, rate, from, to, N)
 	for req != "" {
 		page := TariffRate{}
 		if err := c.get(ctx, req, &page); err != nil {
@@ -220,6 +486,12 @@ func (c *Client) Products(ctx context.Context, availableAt *time.Time) (Products
 	return r, nil
 }
 
+// Product returns the details of the product with the given code, including the tariffs it offers.
+func (c *Client) Product(ctx context.Context, code string) (ProductDetail, error) {
+	r := ProductDetail{}
+	return r, c.get(ctx, productPath(code), &r)
+}
+
 func (p Products) FindByTariff(t string) *Product {
 	for _, r := range p.Results {
 		if strings.Contains(t, r.Code) {
@@ -229,24 +501,78 @@ func (p Products) FindByTariff(t string) *Product {
 	return nil
 }
 
+// get fetches the given path from the API and unmarshals the JSON response into out, retrying
+// if the request fails with what's likely to be a temporary error.
 func (c *Client) get(ctx context.Context, p string, out any) error {
+	backoff := c.RetryBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		err := c.getOnce(ctx, p, out)
+		if err == nil || attempt >= c.MaxRetries || !temporary(err) || ctx.Err() != nil {
+			return err
+		}
+		delay := backoff << attempt
+		if delay > maxRetryBackoff || delay <= 0 {
+			delay = maxRetryBackoff
+		}
+		var se *StatusError
+		if errors.As(err, &se) && se.RetryAfter > delay {
+			if se.RetryAfter > maxRetryBackoff {
+				// Rather than waiting for hours, give up so that the caller sees that it's being throttled.
+				return err
+			}
+			delay = se.RetryAfter
+		}
+		log.Warnf("GET %v failed (%v), retrying in %v", p, err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// temporary returns true if the error returned by getOnce may not occur if the request is retried.
+func temporary(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Temporary()
+	}
+	// Other network errors, e.g. failing to resolve the host or verify its certificate, won't go away
+	// by themselves.
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func (c *Client) getOnce(ctx context.Context, p string, out any) error {
 	log.Debugf("GET %v", p)
 	req, err := http.NewRequestWithContext(ctx, "GET", c.EndPoint+p, nil)
 	if err != nil {
 		return fmt.Errorf("NewRequestWithContext: %v", err)
 	}
 	req.Header.Add("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(c.Key))))
-	rsp, err := http.DefaultClient.Do(req)
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	rsp, err := hc.Do(req)
 	if err != nil {
-		return fmt.Errorf("Do: %v", err)
+		return fmt.Errorf("Do: %w", err)
 	}
+	defer rsp.Body.Close()
 	if rsp.StatusCode != 200 {
-		return fmt.Errorf("Do(%q): unexpected status %s", p, rsp.Status)
+		// Drain the body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, rsp.Body)
+		return statusError(p, rsp)
 	}
-	defer rsp.Body.Close()
 	b, err := io.ReadAll(rsp.Body)
 	if err != nil {
-		return fmt.Errorf("Read(%s): %v", p, err)
+		return fmt.Errorf("Read(%s): %w", p, err)
 	}
 	if err := json.Unmarshal(b, out); err != nil {
 		return fmt.Errorf("Unmarshal(%s): %v", p, err)
@@ -254,6 +580,72 @@ func (c *Client) get(ctx context.Context, p string, out any) error {
 	return nil
 }
 
+// Regions maps the letters used for regions (GSP groups) in tariff codes to the name of the region.
+var Regions = map[string]string{
+	"A": "Eastern England",
+	"B": "East Midlands",
+	"C": "London",
+	"D": "Merseyside and Northern Wales",
+	"E": "West Midlands",
+	"F": "North Eastern England",
+	"G": "North Western England",
+	"H": "Southern England",
+	"J": "South Eastern England",
+	"K": "Southern Wales",
+	"L": "South Western England",
+	"M": "Yorkshire",
+	"N": "Southern Scotland",
+	"P": "Northern Scotland",
+}
+
+// distributorRegions maps the distributor ID which starts each MPAN to its region.
+var distributorRegions = map[string]string{
+	"10": "A", "11": "B", "12": "C", "13": "D", "14": "E", "15": "F", "16": "G",
+	"17": "P", "18": "N", "19": "J", "20": "H", "21": "K", "22": "L", "23": "M",
+}
+
+// RegionFromMPAN returns the region of the meter point, using the distributor ID at the start of the MPAN.
+// Independent distribution networks have their own IDs, so the region can't always be determined.
+func RegionFromMPAN(mpan string) (string, error) {
+	if len(mpan) < 2 {
+		return "", fmt.Errorf("invalid MPAN %q", mpan)
+	}
+	r, ok := distributorRegions[mpan[:2]]
+	if !ok {
+		return "", fmt.Errorf("unknown distributor %q for MPAN %s", mpan[:2], mpan)
+	}
+	return r, nil
+}
+
+// gridSupplyPoints is the response from the grid supply points endpoint.
+type gridSupplyPoints struct {
+	Count   int `json:"count"`
+	Results []struct {
+		GroupID string `json:"group_id"`
+	} `json:"results"`
+}
+
+// Region returns the region (GSP group) which the postcode is in, as used in tariff codes.
+func (c *Client) Region(ctx context.Context, postcode string) (string, error) {
+	r := gridSupplyPoints{}
+	if err := c.get(ctx, gridSupplyPointsPath(strings.ReplaceAll(postcode, " ", "")), &r); err != nil {
+		return "", err
+	}
+	switch len(r.Results) {
+	case 0:
+		return "", fmt.Errorf("no region found for postcode %q", postcode)
+	case 1:
+	default:
+		// Postcodes on the border between regions can be in more than one.
+		return "", fmt.Errorf("postcode %q is in %d regions", postcode, len(r.Results))
+	}
+	region := strings.TrimPrefix(r.Results[0].GroupID, "_")
+	if _, ok := Regions[region]; !ok {
+		return "", fmt.Errorf("unknown region %q for postcode %q", r.Results[0].GroupID, postcode)
+	}
+	return region, nil
+}
+
 // ParseTariffCode splits a tariff code from an agreement into its cnstituent parts:
 // <Fuel>-<Registers>-<Product code>-<Postcode area>
 func ParseTariffCode(tc string) (string, string, string, string, error) {
@@ -265,6 +657,11 @@ func ParseTariffCode(tc string) (string, string, string, string, error) {
 	return bits[0], bits[1], strings.Join(bits[2:l-1], "-"), bits[l-1], nil
 }
 
+// IsExportTariff returns true if the product or tariff code is for an export (outgoing) tariff.
+func IsExportTariff(code string) bool {
+	return strings.Contains(code, "OUTGOING") || strings.Contains(code, "EXPORT")
+}
+
 func BuildTariffCode(fuel, registers, product, area string) string {
 	return strings.Join([]string{fuel, registers, product, area}, "-")
 }
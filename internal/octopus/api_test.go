@@ -1,7 +1,17 @@
 package octopus
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestParseTariffCode(t *testing.T) {
@@ -52,3 +62,401 @@ func TestParseTariffCode(t *testing.T) {
 		})
 	}
 }
+
+// roundTripFunc adapts a function to an http.RoundTripper.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestClientErrors(t *testing.T) {
+	errBadCert := errors.New("x509: certificate signed by unknown authority")
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	for _, test := range []struct {
+		name     string
+		statuses []int
+		// transportErrs, if set, are returned by the transport instead of the response for each call
+		// which has a non-nil error.
+		transportErrs []error
+		maxRetries    int
+		// retryAfter is the Retry-After header sent with throttled responses, defaults to 0.
+		retryAfter string
+		wantErr    any
+		wantCalls  int
+	}{
+		{
+			name:      "ok",
+			statuses:  []int{http.StatusOK},
+			wantCalls: 1,
+		}, {
+			name:       "retry throttled",
+			statuses:   []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK},
+			maxRetries: 3,
+			wantCalls:  3,
+		}, {
+			name:       "retry server error",
+			statuses:   []int{http.StatusBadGateway, http.StatusOK},
+			maxRetries: 3,
+			wantCalls:  2,
+		}, {
+			name:       "throttled retries exhausted",
+			statuses:   []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests},
+			maxRetries: 2,
+			wantErr:    &ThrottledError{},
+			wantCalls:  3,
+		}, {
+			name:       "throttled for too long",
+			statuses:   []int{http.StatusTooManyRequests, http.StatusOK},
+			maxRetries: 3,
+			retryAfter: "86400",
+			wantErr:    &ThrottledError{},
+			wantCalls:  1,
+		}, {
+			name:       "auth",
+			statuses:   []int{http.StatusUnauthorized},
+			maxRetries: 3,
+			wantErr:    &AuthError{},
+			wantCalls:  1,
+		}, {
+			name:       "not found",
+			statuses:   []int{http.StatusNotFound},
+			maxRetries: 3,
+			wantErr:    &NotFoundError{},
+			wantCalls:  1,
+		}, {
+			name:       "server error retries exhausted",
+			statuses:   []int{http.StatusInternalServerError, http.StatusInternalServerError},
+			maxRetries: 1,
+			wantErr:    &StatusError{},
+			wantCalls:  2,
+		}, {
+			name:          "retry connection reset",
+			statuses:      []int{0, http.StatusOK},
+			transportErrs: []error{reset, nil},
+			maxRetries:    3,
+			wantCalls:     2,
+		}, {
+			name:          "certificate error",
+			statuses:      []int{0},
+			transportErrs: []error{errBadCert},
+			maxRetries:    3,
+			wantErr:       errBadCert,
+			wantCalls:     1,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			calls := 0
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := test.statuses[calls-1]
+				if status == http.StatusTooManyRequests {
+					ra := test.retryAfter
+					if ra == "" {
+						ra = "0"
+					}
+					w.Header().Set("Retry-After", ra)
+				}
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					fmt.Fprint(w, `{"number": "A-1234"}`)
+				}
+			}))
+			defer s.Close()
+			transport := s.Client().Transport
+			hc := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				calls++
+				if i := calls - 1; i < len(test.transportErrs) && test.transportErrs[i] != nil {
+					return nil, test.transportErrs[i]
+				}
+				return transport.RoundTrip(r)
+			})}
+
+			c := &Client{
+				EndPoint:     s.URL + "/",
+				AccountID:    "A-1234",
+				HTTPClient:   hc,
+				MaxRetries:   test.maxRetries,
+				RetryBackoff: time.Millisecond,
+			}
+			a, err := c.Account(context.Background())
+			if calls != test.wantCalls {
+				t.Errorf("got %d calls, want %d", calls, test.wantCalls)
+			}
+			switch want := test.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("Account: %v", err)
+				}
+				if a.Number != "A-1234" {
+					t.Errorf("got account %q, want A-1234", a.Number)
+				}
+			case *ThrottledError:
+				if !errors.As(err, &want) {
+					t.Errorf("got err %v, want ThrottledError", err)
+				}
+			case *AuthError:
+				if !errors.As(err, &want) {
+					t.Errorf("got err %v, want AuthError", err)
+				}
+			case *NotFoundError:
+				if !errors.As(err, &want) {
+					t.Errorf("got err %v, want NotFoundError", err)
+				}
+			case *StatusError:
+				if !errors.As(err, &want) || want.StatusCode != http.StatusInternalServerError {
+					t.Errorf("got err %v, want StatusError", err)
+				}
+			case error:
+				if !errors.Is(err, want) {
+					t.Errorf("got err %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTariffRatesOpenEnded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"count": 3, "results": [
+			{"value_exc_vat": 25, "value_inc_vat": 26.25, "valid_from": "2024-04-01T00:00:00Z", "valid_to": null, "payment_method": "NON_DIRECT_DEBIT"},
+			{"value_exc_vat": 24, "value_inc_vat": 25.2, "valid_from": "2024-04-01T00:00:00Z", "valid_to": null, "payment_method": "DIRECT_DEBIT"},
+			{"value_exc_vat": 23, "value_inc_vat": 24.15, "valid_from": "2024-01-01T00:00:00Z", "valid_to": "2024-04-01T00:00:00Z", "payment_method": null}
+		]}`)
+	}))
+	defer srv.Close()
+
+	c := &Client{EndPoint: srv.URL + "/"}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rates, err := c.TariffRates(context.Background(), "VAR-22-11-01", "electricity", "E-1R-VAR-22-11-01-A", StandardUnitRates, from, from.AddDate(0, 6, 0))
+	if err != nil {
+		t.Fatalf("TariffRates: %v", err)
+	}
+	if got := rates.Results[0].ValidTo; got != nil {
+		t.Errorf("got ValidTo %v for open-ended rate, want nil", got)
+	}
+	if got, want := rates.Results[2].ValidTo, from.AddDate(0, 3, 0); got == nil || !got.Equal(want) {
+		t.Errorf("got ValidTo %v, want %v", got, want)
+	}
+
+	dd := rates.ForPaymentMethod(DirectDebit)
+	if got, want := len(dd.Results), 2; got != want {
+		t.Fatalf("got %d direct debit rates, want %d", got, want)
+	}
+	if got, want := dd.Results[0].ValueIncVat, 25.2; got != want {
+		t.Errorf("got direct debit rate %v, want %v", got, want)
+	}
+}
+
+func TestStandingCharges(t *testing.T) {
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		fmt.Fprint(w, `{"count": 2, "results": [
+			{"value_exc_vat": 60, "value_inc_vat": 63, "valid_from": "2024-01-03T00:00:00Z", "valid_to": null},
+			{"value_exc_vat": 50, "value_inc_vat": 52.5, "valid_from": "2024-01-01T00:00:00Z", "valid_to": "2024-01-03T00:00:00Z"}
+		]}`)
+	}))
+	defer srv.Close()
+
+	c := &Client{EndPoint: srv.URL + "/"}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sc, err := c.StandingCharges(context.Background(), "VAR-22-11-01", "gas", "G-1R-VAR-22-11-01-A", from, from.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("StandingCharges: %v", err)
+	}
+	if want := "/v1/products/VAR-22-11-01/gas-tariffs/G-1R-VAR-22-11-01-A/standing-charges/"; gotPath != want {
+		t.Errorf("requested %q, want %q", gotPath, want)
+	}
+	if got := len(sc.Results); got != 2 {
+		t.Fatalf("got %d standing charges, want 2", got)
+	}
+	if got, want := sc.Results[1].ValueIncVat, 52.5; got != want {
+		t.Errorf("got standing charge %v, want %v", got, want)
+	}
+}
+
+func TestRegionFromMPAN(t *testing.T) {
+	for _, test := range []struct {
+		mpan    string
+		want    string
+		wantErr bool
+	}{
+		{mpan: "1012345678901", want: "A"},
+		{mpan: "1900001234567", want: "J"},
+		{mpan: "2300001234567", want: "M"},
+		{mpan: "2400001234567", wantErr: true},
+		{mpan: "1", wantErr: true},
+	} {
+		got, err := RegionFromMPAN(test.mpan)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("RegionFromMPAN(%q): got err %v, want err %t", test.mpan, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("RegionFromMPAN(%q) = %q, want %q", test.mpan, got, test.want)
+		}
+	}
+}
+
+func TestIsExportTariff(t *testing.T) {
+	for _, test := range []struct {
+		code string
+		want bool
+	}{
+		{code: "AGILE-OUTGOING-19-05-13", want: true},
+		{code: "E-1R-AGILE-OUTGOING-19-05-13-C", want: true},
+		{code: "OUTGOING-FIX-12M-19-05-13", want: true},
+		{code: "E-1R-SEG-EXPORT-22-11-01-J", want: true},
+		{code: "AGILE-23-12-06", want: false},
+		{code: "E-1R-VAR-22-11-01-C", want: false},
+		{code: "", want: false},
+	} {
+		if got := IsExportTariff(test.code); got != test.want {
+			t.Errorf("IsExportTariff(%q) = %t, want %t", test.code, got, test.want)
+		}
+	}
+}
+
+func TestElectricityMeterPointExport(t *testing.T) {
+	agreements := func(codes ...string) []Agreement {
+		r := []Agreement{}
+		for _, c := range codes {
+			r = append(r, Agreement{TariffCode: c})
+		}
+		return r
+	}
+	for _, test := range []struct {
+		name string
+		em   ElectricityMeterPoint
+		want bool
+	}{
+		{name: "flagged", em: ElectricityMeterPoint{IsExport: true}, want: true},
+		{name: "export agreement", em: ElectricityMeterPoint{Agreements: agreements("E-1R-AGILE-23-12-06-C", "E-1R-AGILE-OUTGOING-19-05-13-C")}, want: true},
+		{name: "import", em: ElectricityMeterPoint{Agreements: agreements("E-1R-AGILE-23-12-06-C")}},
+		{name: "no agreements", em: ElectricityMeterPoint{}},
+	} {
+		if got := test.em.Export(); got != test.want {
+			t.Errorf("%s: Export() = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRegion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("postcode") {
+		case "SW1A1AA":
+			fmt.Fprint(w, `{"count": 1, "results": [{"group_id": "_C"}]}`)
+		case "TD151AA":
+			fmt.Fprint(w, `{"count": 2, "results": [{"group_id": "_N"}, {"group_id": "_F"}]}`)
+		default:
+			fmt.Fprint(w, `{"count": 0, "results": []}`)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{EndPoint: srv.URL + "/"}
+	for _, test := range []struct {
+		postcode string
+		want     string
+		wantErr  bool
+	}{
+		{postcode: "SW1A 1AA", want: "C"},
+		{postcode: "TD15 1AA", wantErr: true},
+		{postcode: "XX1 1XX", wantErr: true},
+	} {
+		got, err := c.Region(context.Background(), test.postcode)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("Region(%q): got err %v, want err %t", test.postcode, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Region(%q) = %q, want %q", test.postcode, got, test.want)
+		}
+	}
+}
+
+func TestProductTariff(t *testing.T) {
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/products/AGILE-24-10-01/":
+			fmt.Fprint(w, `{"code": "AGILE-24-10-01", "direction": "IMPORT", "tariffs_active_at": "2024-12-01T00:00:00Z",
+				"single_register_electricity_tariffs": {
+					"_A": {
+						"direct_debit_monthly": {"code": "E-1R-AGILE-24-10-01-A", "standing_charge_inc_vat": 48.1, "links": [
+							{"href": "https://api.octopus.energy/v1/products/AGILE-24-10-01/electricity-tariffs/E-1R-AGILE-24-10-01-A/standing-charges/", "method": "GET", "rel": "standing_charges"},
+							{"href": "https://api.octopus.energy/v1/products/AGILE-24-10-01/electricity-tariffs/E-1R-AGILE-24-10-01-A/standard-unit-rates/", "method": "GET", "rel": "standard_unit_rates"}
+						]}
+					},
+					"_C": {
+						"prepayment": {"code": "E-1R-AGILE-24-10-01-C", "links": []}
+					}
+				},
+				"dual_register_electricity_tariffs": {},
+				"single_register_gas_tariffs": {}
+			}`)
+		case "/v1/products/AGILE-24-10-01/electricity-tariffs/E-1R-AGILE-24-10-01-A/standard-unit-rates/":
+			if r.URL.Query().Get("period_from") != "2024-12-01T00:00:00Z" {
+				http.Error(w, "bad period", http.StatusBadRequest)
+				return
+			}
+			next := ""
+			if r.URL.Query().Get("page") == "" {
+				next = srvURL + r.URL.RequestURI() + "&page=2"
+			}
+			fmt.Fprintf(w, `{"count": 2, "next": %q, "results": [{"value_exc_vat": 20, "value_inc_vat": 21, "valid_from": "2024-12-01T00:00:00Z"}]}`, next)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	ctx := context.Background()
+	c := &Client{EndPoint: srv.URL + "/"}
+	p, err := c.Product(ctx, "AGILE-24-10-01")
+	if err != nil {
+		t.Fatalf("Product: %v", err)
+	}
+
+	tariff, err := p.Tariff("E", "1R", "A", "")
+	if err != nil {
+		t.Fatalf("Tariff: %v", err)
+	}
+	if got, want := tariff.Code, "E-1R-AGILE-24-10-01-A"; got != want {
+		t.Errorf("got tariff %q, want %q", got, want)
+	}
+	if got, want := tariff.UnitRateTypes(), []string{StandardUnitRates}; !slices.Equal(got, want) {
+		t.Errorf("got unit rate types %q, want %q", got, want)
+	}
+	from := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	rates, err := c.LinkedRates(ctx, tariff.Link(StandardUnitRates), from, from.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("LinkedRates: %v", err)
+	}
+	if got, want := len(rates.Results), 2; got != want {
+		t.Errorf("got %d rates, want %d", got, want)
+	}
+
+	for _, test := range []struct {
+		name                                   string
+		fuel, registers, region, paymentMethod string
+	}{
+		{name: "dual register", fuel: "E", registers: "2R", region: "A"},
+		{name: "gas", fuel: "G", registers: "1R", region: "A"},
+		{name: "region", fuel: "E", registers: "1R", region: "B"},
+		{name: "payment method", fuel: "E", registers: "1R", region: "C", paymentMethod: DirectDebitMonthly},
+	} {
+		if _, err := p.Tariff(test.fuel, test.registers, test.region, test.paymentMethod); !errors.Is(err, ErrNotOffered) {
+			t.Errorf("%s: got err %v, want ErrNotOffered", test.name, err)
+		}
+	}
+	if got, want := tariff.RatePaymentMethod(), DirectDebit; got != want {
+		t.Errorf("got rate payment method %q for %s tariff, want %q", got, tariff.PaymentMethod, want)
+	}
+	tariff, err = p.Tariff("E", "1R", "C", "")
+	if err != nil || tariff.Code != "E-1R-AGILE-24-10-01-C" {
+		t.Fatalf("got %+v, %v, want prepayment tariff when it's the only one", tariff, err)
+	}
+	if tariff.PaymentMethod != Prepayment || tariff.RatePaymentMethod() != NonDirectDebit {
+		t.Errorf("got payment method %q with rates for %q, want %q with rates for %q", tariff.PaymentMethod, tariff.RatePaymentMethod(), Prepayment, NonDirectDebit)
+	}
+}
@@ -0,0 +1,88 @@
+package octopus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusError is returned when the API responds to a request with an unexpected HTTP status.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay requested by the server via the Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("GET %q: unexpected status %s", e.Path, e.Status)
+}
+
+// Temporary returns true if the request may succeed if retried later.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// AuthError is returned when the API rejects the account number or API key.
+type AuthError struct {
+	StatusError
+}
+
+func (e *AuthError) Unwrap() error { return &e.StatusError }
+
+// NotFoundError is returned when the requested resource doesn't exist, e.g. when a product
+// doesn't offer the requested tariff.
+type NotFoundError struct {
+	StatusError
+}
+
+func (e *NotFoundError) Unwrap() error { return &e.StatusError }
+
+// ErrNotOffered is returned when a product doesn't offer a tariff for the requested fuel, register type,
+// region, or payment method.
+var ErrNotOffered = errors.New("tariff not offered by product")
+
+// ThrottledError is returned when the API has rate limited requests, and retries have been exhausted or
+// the server asked for too long a delay before retrying.
+type ThrottledError struct {
+	StatusError
+}
+
+func (e *ThrottledError) Unwrap() error { return &e.StatusError }
+
+// statusError returns a typed error describing the unexpected response.
+func statusError(p string, rsp *http.Response) error {
+	se := StatusError{
+		Path:       p,
+		StatusCode: rsp.StatusCode,
+		Status:     rsp.Status,
+		RetryAfter: retryAfter(rsp.Header.Get("Retry-After")),
+	}
+	switch rsp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &AuthError{se}
+	case http.StatusNotFound:
+		return &NotFoundError{se}
+	case http.StatusTooManyRequests:
+		return &ThrottledError{se}
+	default:
+		return &se
+	}
+}
+
+// retryAfter parses the value of a Retry-After header, which may either be a number of seconds or a date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
@@ -0,0 +1,424 @@
+// Package fake provides an in-process fake of the parts of the Octopus Energy API used by octonaut.
+//
+// It's intended for use in tests, and for trying octonaut out without an Octopus account, so it
+// serves whatever data it's been given, or synthetic data created by Generate.
+package fake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+)
+
+// defaultMaxPageSize is the largest page of results returned if Server.MaxPageSize isn't set.
+const defaultMaxPageSize = 1000
+
+// Server is a fake Octopus API.
+//
+// Account and consumption endpoints require requests to authenticate with the server's key, as the
+// real API does, while products and their rates are public.
+type Server struct {
+	// Key is the API key which requests for account data must use.
+	Key string
+	// MaxPageSize caps the number of results returned in each page, regardless of the page size
+	// requested, so that pagination can be exercised with small amounts of data.
+	MaxPageSize int
+
+	mux *http.ServeMux
+
+	mu          sync.Mutex
+	accounts    map[string]octopus.Account
+	consumption map[string][]octopus.ConsumptionReading
+	products    []octopus.Product
+	rates       map[string][]octopus.RateInterval
+	regions     map[string]string
+	failures    []int
+	requests    int
+}
+
+// New creates a new fake server with no data, which authenticates requests using the given key.
+func New(key string) *Server {
+	s := &Server{
+		Key:         key,
+		mux:         http.NewServeMux(),
+		accounts:    map[string]octopus.Account{},
+		consumption: map[string][]octopus.ConsumptionReading{},
+		rates:       map[string][]octopus.RateInterval{},
+		regions:     map[string]string{},
+	}
+	s.mux.HandleFunc("GET /v1/accounts/{account}/{$}", s.authenticated(s.account))
+	s.mux.HandleFunc("GET /v1/electricity-meter-points/{point}/meters/{serial}/consumption/{$}", s.authenticated(s.meterConsumption("electricity")))
+	s.mux.HandleFunc("GET /v1/gas-meter-points/{point}/meters/{serial}/consumption/{$}", s.authenticated(s.meterConsumption("gas")))
+	s.mux.HandleFunc("GET /v1/products/{$}", s.productList)
+	s.mux.HandleFunc("GET /v1/products/{product}/{$}", s.productDetail)
+	s.mux.HandleFunc("GET /v1/products/{product}/{fuel}/{tariff}/{rate}/{$}", s.tariffRates)
+	s.mux.HandleFunc("GET /v1/industry/grid-supply-points/{$}", s.gridSupplyPoints)
+	return s
+}
+
+// AddAccount adds or replaces an account.
+func (s *Server) AddAccount(a octopus.Account) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[a.Number] = a
+}
+
+// AddConsumption adds readings for the meter, fuel is either "electricity" or "gas".
+func (s *Server) AddConsumption(fuel, point, serial string, rs []octopus.ConsumptionReading) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := consumptionKey(fuel, point, serial)
+	s.consumption[k] = append(s.consumption[k], rs...)
+	sort.Slice(s.consumption[k], func(i, j int) bool {
+		return s.consumption[k][i].IntervalStart.Before(s.consumption[k][j].IntervalStart)
+	})
+}
+
+// AddProduct adds a product to the list of products.
+func (s *Server) AddProduct(p octopus.Product) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.products = append(s.products, p)
+}
+
+// AddRates adds rates for the tariff, rateType is either one of the octopus unit rate types or octopus.StandingCharges.
+func (s *Server) AddRates(tariffCode, rateType string, rs []octopus.RateInterval) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := tariffCode + "/" + rateType
+	s.rates[k] = append(s.rates[k], rs...)
+	// The real API returns the most recent rates first.
+	sort.SliceStable(s.rates[k], func(i, j int) bool {
+		return s.rates[k][i].ValidFrom.After(s.rates[k][j].ValidFrom)
+	})
+}
+
+// AddRegion sets the region (GSP group) of the postcode, e.g. "C" for London.
+func (s *Server) AddRegion(postcode, region string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.regions[normalisePostcode(postcode)] = region
+}
+
+// Fail causes the next n requests to fail with the given HTTP status code.
+// Throttled requests are told they may be retried immediately.
+func (s *Server) Fail(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// Requests returns the number of requests which have been made to the server.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	status := 0
+	if len(s.failures) > 0 {
+		status, s.failures = s.failures[0], s.failures[1:]
+	}
+	s.mu.Unlock()
+
+	if status != 0 {
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authenticated wraps the handler with a check that the request uses the server's key.
+// The key is sent as the username for basic auth, the password is ignored.
+func (s *Server) authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enc, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Basic ")
+		if !ok {
+			http.Error(w, "Authentication credentials were not provided.", http.StatusUnauthorized)
+			return
+		}
+		b, err := base64.StdEncoding.DecodeString(enc)
+		if user, _, _ := strings.Cut(string(b), ":"); err != nil || user != s.Key {
+			http.Error(w, "Invalid API key.", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) account(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	a, ok := s.accounts[r.PathValue("account")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, a)
+}
+
+func (s *Server) meterConsumption(fuel string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := period(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		rs, ok := s.consumption[consumptionKey(fuel, r.PathValue("point"), r.PathValue("serial"))]
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		res := []octopus.ConsumptionReading{}
+		for _, c := range rs {
+			if !c.IntervalStart.Before(from) && c.IntervalStart.Before(to) {
+				res = append(res, c)
+			}
+		}
+		if r.URL.Query().Get("order_by") != "period" {
+			// The real API returns the most recent readings first unless asked otherwise.
+			for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+				res[i], res[j] = res[j], res[i]
+			}
+		}
+		writeJSON(w, paginate(s, r, res))
+	}
+}
+
+// productList returns the products which are available now, or at the time given by available_at.
+func (s *Server) productList(w http.ResponseWriter, r *http.Request) {
+	at := time.Now()
+	if v := r.URL.Query().Get("available_at"); v != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, fmt.Sprintf("invalid available_at: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	ps := []octopus.Product{}
+	s.mu.Lock()
+	for _, p := range s.products {
+		if !at.Before(p.AvailableFrom) && (p.AvailableTo == nil || at.Before(*p.AvailableTo)) {
+			ps = append(ps, p)
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, paginate(s, r, ps))
+}
+
+// productDetail returns the product along with a tariff for each tariff code which has rates, whose prices
+// are the most recent ones. Tariffs are for direct debit customers, or prepayment ones for prepay products.
+func (s *Server) productDetail(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("product")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := octopus.ProductDetail{}
+	found := false
+	for _, p := range s.products {
+		if p.Code == code {
+			d.Product, found = p, true
+		}
+	}
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	d.TariffsActiveAt = time.Now().UTC()
+	// Tariffs are offered to direct debit customers, apart from those of prepay products.
+	method, rateMethod := octopus.DirectDebitMonthly, octopus.DirectDebit
+	if d.IsPrepay {
+		method, rateMethod = octopus.Prepayment, octopus.NonDirectDebit
+	}
+
+	tariffs := map[string]*octopus.Tariff{}
+	for k, rs := range s.rates {
+		tariffCode, rateType, _ := strings.Cut(k, "/")
+		f, registers, product, region, err := octopus.ParseTariffCode(tariffCode)
+		if err != nil || product != code {
+			continue
+		}
+		fuel := "electricity"
+		if f == "G" {
+			fuel = "gas"
+		}
+		ts := d.Tariffs(f, registers)
+		if ts == nil {
+			continue
+		}
+		if *ts == nil {
+			*ts = octopus.RegionalTariffs{}
+		}
+		t, ok := tariffs[tariffCode]
+		if !ok {
+			t = &octopus.Tariff{Code: tariffCode}
+			tariffs[tariffCode] = t
+		}
+		t.Links = append(t.Links, octopus.Link{
+			Href:   fmt.Sprintf("http://%s/v1/products/%s/%s-tariffs/%s/%s/", r.Host, code, fuel, tariffCode, rateType),
+			Method: "GET",
+			Rel:    strings.ReplaceAll(rateType, "-", "_"),
+		})
+		// Rates are stored most recent first.
+		for _, ri := range rs {
+			if ri.PaymentMethod != "" && ri.PaymentMethod != rateMethod {
+				continue
+			}
+			switch rateType {
+			case octopus.StandingCharges:
+				t.StandingChargeExcVAT, t.StandingChargeIncVAT = ri.ValueExcVat, ri.ValueIncVat
+			case octopus.StandardUnitRates:
+				t.StandardUnitRateExcVAT, t.StandardUnitRateIncVAT = ri.ValueExcVat, ri.ValueIncVat
+			case octopus.DayUnitRates:
+				t.DayUnitRateExcVAT, t.DayUnitRateIncVAT = ri.ValueExcVat, ri.ValueIncVat
+			case octopus.NightUnitRates:
+				t.NightUnitRateExcVAT, t.NightUnitRateIncVAT = ri.ValueExcVat, ri.ValueIncVat
+			}
+			break
+		}
+		(*ts)["_"+region] = map[string]octopus.Tariff{method: *t}
+	}
+	writeJSON(w, d)
+}
+
+func (s *Server) tariffRates(w http.ResponseWriter, r *http.Request) {
+	from, to, err := period(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tariffCode := r.PathValue("tariff")
+	_, _, product, _, err := octopus.ParseTariffCode(tariffCode)
+	if err != nil || product != r.PathValue("product") {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	rs, ok := s.rates[tariffCode+"/"+r.PathValue("rate")]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	res := []octopus.RateInterval{}
+	for _, ri := range rs {
+		if ri.ValidFrom.Before(to) && (ri.ValidTo == nil || ri.ValidTo.After(from)) {
+			res = append(res, ri)
+		}
+	}
+	writeJSON(w, paginate(s, r, res))
+}
+
+// gridSupplyPoints returns the GSP group of the postcode, or no results if it's unknown.
+func (s *Server) gridSupplyPoints(w http.ResponseWriter, r *http.Request) {
+	type gsp struct {
+		GroupID string `json:"group_id"`
+	}
+	res := []gsp{}
+	s.mu.Lock()
+	region, ok := s.regions[normalisePostcode(r.URL.Query().Get("postcode"))]
+	s.mu.Unlock()
+	if ok {
+		res = append(res, gsp{GroupID: "_" + region})
+	}
+	writeJSON(w, paginate(s, r, res))
+}
+
+// normalisePostcode removes spaces from the postcode and upper-cases it.
+func normalisePostcode(p string) string {
+	return strings.ToUpper(strings.ReplaceAll(p, " ", ""))
+}
+
+// page is a single page of results from a list endpoint.
+type page[T any] struct {
+	Count    int    `json:"count"`
+	Next     string `json:"next"`
+	Previous string `json:"previous"`
+	Results  []T    `json:"results"`
+}
+
+// paginate returns the page of results requested by the page and page_size parameters, with links
+// to the next and previous pages.
+func paginate[T any](s *Server, r *http.Request, all []T) page[T] {
+	q := r.URL.Query()
+	max := s.MaxPageSize
+	if max <= 0 {
+		max = defaultMaxPageSize
+	}
+	size := max
+	if n, err := strconv.Atoi(q.Get("page_size")); err == nil && n > 0 && n < max {
+		size = n
+	}
+	num := 1
+	if n, err := strconv.Atoi(q.Get("page")); err == nil && n > 0 {
+		num = n
+	}
+
+	link := func(n int) string {
+		q.Set("page", strconv.Itoa(n))
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		return fmt.Sprintf("http://%s%s", r.Host, u.RequestURI())
+	}
+	p := page[T]{Count: len(all), Results: []T{}}
+	start, end := (num-1)*size, num*size
+	if start < len(all) {
+		p.Results = all[start:min(end, len(all))]
+	}
+	if end < len(all) {
+		p.Next = link(num + 1)
+	}
+	if num > 1 {
+		p.Previous = link(num - 1)
+	}
+	return p
+}
+
+// period returns the period requested with the period_from and period_to parameters, either of which
+// may be absent.
+func period(r *http.Request) (time.Time, time.Time, error) {
+	from, to := time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"period_from", &from}, {"period_to", &to}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid %s: %v", p.name, err)
+		}
+		*p.t = t
+	}
+	return from, to, nil
+}
+
+func consumptionKey(fuel, point, serial string) string {
+	return fuel + "/" + point + "/" + serial
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
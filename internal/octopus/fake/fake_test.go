@@ -0,0 +1,204 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+)
+
+func newTestServer(t *testing.T) (*Server, *octopus.Client, Options) {
+	t.Helper()
+	s := New(DefaultKey)
+	// Small pages make sure that clients follow the next links.
+	s.MaxPageSize = 100
+	o := Options{From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)}
+	s.Generate(o)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, &octopus.Client{
+		EndPoint:     srv.URL + "/",
+		AccountID:    DefaultAccount,
+		Key:          DefaultKey,
+		MaxRetries:   3,
+		RetryBackoff: time.Millisecond,
+	}, o
+}
+
+func TestFakeServer(t *testing.T) {
+	ctx := context.Background()
+	_, c, o := newTestServer(t)
+
+	a, err := c.Account(ctx)
+	if err != nil {
+		t.Fatalf("Account: %v", err)
+	}
+	if got, want := len(a.Properties), 1; got != want {
+		t.Fatalf("got %d properties, want %d", got, want)
+	}
+
+	days := int(o.To.Sub(o.From).Hours() / 24)
+	cons, err := c.Consumption(ctx, ImportMPAN, ImportMeter, o.From, o.To)
+	if err != nil {
+		t.Fatalf("Consumption: %v", err)
+	}
+	if got, want := len(cons.Results), days*48; got != want {
+		t.Errorf("got %d readings, want %d", got, want)
+	}
+	for i := 1; i < len(cons.Results); i++ {
+		if !cons.Results[i].IntervalStart.Equal(cons.Results[i-1].IntervalEnd) {
+			t.Fatalf("reading %d starts at %v, want %v", i, cons.Results[i].IntervalStart, cons.Results[i-1].IntervalEnd)
+		}
+	}
+	gas, err := c.GasConsumption(ctx, GasMPRN, GasMeter, o.From, o.From.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("GasConsumption: %v", err)
+	}
+	if got, want := len(gas.Results), 48; got != want {
+		t.Errorf("got %d gas readings, want %d", got, want)
+	}
+
+	rates, err := c.TariffRates(ctx, ImportAgile, "electricity", octopus.BuildTariffCode("E", "1R", ImportAgile, Region), octopus.StandardUnitRates, o.From, o.From.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatalf("TariffRates: %v", err)
+	}
+	if got, want := len(rates.Results), 48; got != want {
+		t.Errorf("got %d rates, want %d", got, want)
+	}
+	sc, err := c.StandingCharges(ctx, Variable, "gas", octopus.BuildTariffCode("G", "1R", Variable, Region), o.From, o.To)
+	if err != nil {
+		t.Fatalf("StandingCharges: %v", err)
+	}
+	if got := sc.ForPaymentMethod(octopus.DirectDebit).Results; len(got) != 1 || got[0].ValidTo != nil {
+		t.Errorf("got standing charges %+v, want a single open-ended one", got)
+	}
+
+	ps, err := c.Products(ctx, nil)
+	if err != nil {
+		t.Fatalf("Products: %v", err)
+	}
+	if ps.FindByTariff(octopus.BuildTariffCode("E", "1R", Tracker, Region)) == nil {
+		t.Errorf("Products missing %s", Tracker)
+	}
+
+	p, err := c.Product(ctx, Variable)
+	if err != nil {
+		t.Fatalf("Product: %v", err)
+	}
+	gt, err := p.Tariff("G", "1R", Region, "")
+	if err != nil {
+		t.Fatalf("Tariff: %v", err)
+	}
+	if got, want := gt.Code, octopus.BuildTariffCode("G", "1R", Variable, Region); got != want {
+		t.Errorf("got gas tariff %q, want %q", got, want)
+	}
+	if gt.StandingChargeIncVAT == 0 || gt.StandardUnitRateIncVAT == 0 {
+		t.Errorf("got gas tariff %+v, want current prices", gt)
+	}
+	if sc, err := c.LinkedRates(ctx, gt.Link(octopus.StandingCharges), o.From, o.To); err != nil || len(sc.Results) == 0 {
+		t.Errorf("LinkedRates(standing charges): got %+v, %v", sc, err)
+	}
+	et7, err := p.Tariff("E", "2R", Region, "")
+	if err != nil {
+		t.Fatalf("Tariff(E-2R): %v", err)
+	}
+	if got, want := et7.UnitRateTypes(), []string{octopus.DayUnitRates, octopus.NightUnitRates}; !slices.Equal(got, want) {
+		t.Errorf("got 2R unit rate types %q, want %q", got, want)
+	}
+	if et7.NightUnitRateIncVAT == 0 || et7.NightUnitRateIncVAT >= et7.DayUnitRateIncVAT {
+		t.Errorf("got 2R tariff %+v, want cheaper night rates", et7)
+	}
+	if p, err := c.Product(ctx, Go); err != nil {
+		t.Errorf("Product(%s): %v", Go, err)
+	} else if _, err := p.Tariff("E", "2R", Region, ""); !errors.Is(err, octopus.ErrNotOffered) {
+		t.Errorf("Tariff(%s E-2R): got err %v, want ErrNotOffered", Go, err)
+	}
+
+	if got, err := c.Region(ctx, a.Properties[0].Postcode); err != nil || got != Region {
+		t.Errorf("Region: got %q, %v, want %q", got, err, Region)
+	}
+	if got, err := octopus.RegionFromMPAN(ImportMPAN); err != nil || got != Region {
+		t.Errorf("RegionFromMPAN: got %q, %v, want %q", got, err, Region)
+	}
+}
+
+func TestFakeServerErrors(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range []struct {
+		name    string
+		setup   func(s *Server, c *octopus.Client)
+		call    func(c *octopus.Client) error
+		wantErr any
+	}{
+		{
+			name:  "bad key",
+			setup: func(_ *Server, c *octopus.Client) { c.Key = "sk_live_wrong" },
+			call: func(c *octopus.Client) error {
+				_, err := c.Account(ctx)
+				return err
+			},
+			wantErr: &octopus.AuthError{},
+		}, {
+			name:  "unknown account",
+			setup: func(_ *Server, c *octopus.Client) { c.AccountID = "A-NOTFOUND" },
+			call: func(c *octopus.Client) error {
+				_, err := c.Account(ctx)
+				return err
+			},
+			wantErr: &octopus.NotFoundError{},
+		}, {
+			name: "unknown tariff",
+			call: func(c *octopus.Client) error {
+				_, err := c.TariffRates(ctx, ImportAgile, "electricity", octopus.BuildTariffCode("E", "1R", ImportAgile, "P"), octopus.StandardUnitRates, time.Time{}, time.Now())
+				return err
+			},
+			wantErr: &octopus.NotFoundError{},
+		}, {
+			name:  "retried failures",
+			setup: func(s *Server, _ *octopus.Client) { s.Fail(2, http.StatusBadGateway) },
+			call: func(c *octopus.Client) error {
+				_, err := c.Account(ctx)
+				return err
+			},
+		}, {
+			name:  "throttled",
+			setup: func(s *Server, _ *octopus.Client) { s.Fail(4, http.StatusTooManyRequests) },
+			call: func(c *octopus.Client) error {
+				_, err := c.Account(ctx)
+				return err
+			},
+			wantErr: &octopus.ThrottledError{},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, c, _ := newTestServer(t)
+			if test.setup != nil {
+				test.setup(s, c)
+			}
+			err := test.call(c)
+			switch want := test.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("got err %v, want nil", err)
+				}
+			case *octopus.AuthError:
+				if !errors.As(err, &want) {
+					t.Fatalf("got err %v, want %T", err, test.wantErr)
+				}
+			case *octopus.NotFoundError:
+				if !errors.As(err, &want) {
+					t.Fatalf("got err %v, want %T", err, test.wantErr)
+				}
+			case *octopus.ThrottledError:
+				if !errors.As(err, &want) {
+					t.Fatalf("got err %v, want %T", err, test.wantErr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package fake
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/AlCutter/octonaut/internal/octopus"
+)
+
+// Identifiers used for the generated account.
+const (
+	DefaultAccount = "A-FAKE1234"
+	DefaultKey     = "sk_test_fake"
+
+	// Region is the region (GSP group) of the generated property and tariffs, London.
+	Region = "C"
+
+	ImportMPAN  = "1200000000001"
+	ImportMeter = "21E0000001"
+	ExportMPAN  = "1200000000002"
+	ExportMeter = "21E0000002"
+	GasMPRN     = "1000000001"
+	GasMeter    = "G4A0000001"
+	ImportAgile = "AGILE-23-12-06"
+	OldAgile    = "AGILE-FLEX-22-11-25"
+	ExportAgile = "AGILE-OUTGOING-19-05-13"
+	Variable    = "VAR-22-11-01"
+	Go          = "GO-VAR-22-10-14"
+	Tracker     = "SILVER-23-12-06"
+	Fixed       = "COOP-FIX-12M-24-01-01"
+	BusinessVar = "BUS-VAR-22-11-01"
+)
+
+// Options configures the synthetic data created by Generate.
+type Options struct {
+	// Account is the account number, defaults to DefaultAccount.
+	Account string
+	// From and To bound the half-hourly consumption and rates which are generated.
+	// To defaults to the start of today, and From to 90 days before To.
+	From, To time.Time
+	// Seed seeds the random variation in consumption and Agile prices, so that the same data is
+	// generated each time.
+	Seed int64
+}
+
+// Generate adds a synthetic account to the server, with a property which imports electricity on Agile,
+// exports on Agile Outgoing, and has gas on a variable tariff, along with a selection of products
+// and their rates in Region, including a two register (2R) electricity tariff.
+// All times are in UTC.
+func (s *Server) Generate(o Options) octopus.Account {
+	if o.Account == "" {
+		o.Account = DefaultAccount
+	}
+	if o.To.IsZero() {
+		o.To = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if o.From.IsZero() {
+		o.From = o.To.AddDate(0, 0, -90)
+	}
+	rnd := rand.New(rand.NewSource(o.Seed))
+
+	agreement := func(product, fuel string) []octopus.Agreement {
+		return []octopus.Agreement{{TariffCode: octopus.BuildTariffCode(fuel, "1R", product, Region), ValidFrom: o.From}}
+	}
+	a := octopus.Account{
+		Number: o.Account,
+		Properties: []octopus.Property{{
+			ID:           1,
+			MovedInAt:    o.From,
+			AddressLine1: "1 Fake Street",
+			Town:         "London",
+			Postcode:     "SW1A 1AA",
+			ElectricityMeterPoints: []octopus.ElectricityMeterPoint{
+				{
+					MPAN:         ImportMPAN,
+					ProfileClass: 1,
+					Meters:       []octopus.Meter{{SerialNumber: ImportMeter}},
+					Agreements:   agreement(ImportAgile, "E"),
+				}, {
+					MPAN:       ExportMPAN,
+					IsExport:   true,
+					Meters:     []octopus.Meter{{SerialNumber: ExportMeter}},
+					Agreements: agreement(ExportAgile, "E"),
+				},
+			},
+			GasMeterPoints: []octopus.GasMeterPoints{{
+				MPRN:       GasMPRN,
+				Meters:     []octopus.Meter{{SerialNumber: GasMeter}},
+				Agreements: agreement(Variable, "G"),
+			}},
+		}},
+	}
+	s.AddAccount(a)
+	s.AddRegion(a.Properties[0].Postcode, Region)
+
+	imp, exp, gas := []octopus.ConsumptionReading{}, []octopus.ConsumptionReading{}, []octopus.ConsumptionReading{}
+	for t := o.From; t.Before(o.To); t = t.Add(30 * time.Minute) {
+		h := hour(t)
+		season := math.Cos(2 * math.Pi * float64(t.YearDay()) / 365)
+		reading := func(v float64) octopus.ConsumptionReading {
+			return octopus.ConsumptionReading{Consumption: math.Round(v*1000) / 1000, IntervalStart: t, IntervalEnd: t.Add(30 * time.Minute)}
+		}
+		// A baseload, with peaks for breakfast and the evening.
+		kWh := 0.12 + 0.3*bump(h, 7.5, 1) + 0.6*bump(h, 18.5, 2) + 0.1*rnd.Float64()
+		imp = append(imp, reading(kWh))
+		// Solar generation around midday, more in summer.
+		exp = append(exp, reading(math.Max(0, (0.6-0.4*season)*bump(h, 13, 3)-0.05*rnd.Float64())))
+		// Heating in the morning and evening, more in winter.
+		gas = append(gas, reading(math.Max(0, (0.1+0.15*season)*(bump(h, 7, 1.5)+bump(h, 19, 2.5))+0.02*rnd.Float64())))
+	}
+	s.AddConsumption("electricity", ImportMPAN, ImportMeter, imp)
+	s.AddConsumption("electricity", ExportMPAN, ExportMeter, exp)
+	s.AddConsumption("gas", GasMPRN, GasMeter, gas)
+
+	s.generateProducts(o, rnd)
+	return a
+}
+
+// generateProducts adds a selection of products, with their rates for Region.
+func (s *Server) generateProducts(o Options, rnd *rand.Rand) {
+	product := func(code, name string, variable, tracker, business bool, direction string) {
+		s.AddProduct(octopus.Product{
+			Code:          code,
+			FullName:      name,
+			DisplayName:   name,
+			Description:   "A synthetic product served by octonaut's fake Octopus API.",
+			IsVariable:    variable,
+			IsTracker:     tracker,
+			IsBusiness:    business,
+			Direction:     direction,
+			Brand:         "OCTOPUS_ENERGY",
+			AvailableFrom: o.From,
+		})
+	}
+	elec := func(p string) string { return octopus.BuildTariffCode("E", "1R", p, Region) }
+	gas := func(p string) string { return octopus.BuildTariffCode("G", "1R", p, Region) }
+	standing := func(tariffCode string, p float64) {
+		s.AddRates(tariffCode, octopus.StandingCharges, []octopus.RateInterval{rate(o.From, time.Time{}, p)})
+	}
+
+	// Agile prices follow the wholesale market, with an evening peak, half-hour by half-hour.
+	product(ImportAgile, "Agile Octopus", true, false, false, "IMPORT")
+	product(ExportAgile, "Agile Outgoing Octopus", true, false, false, "EXPORT")
+	agile, outgoing := []octopus.RateInterval{}, []octopus.RateInterval{}
+	for t := o.From; t.Before(o.To.AddDate(0, 0, 1)); t = t.Add(30 * time.Minute) {
+		h := hour(t)
+		wholesale := 10 + 5*bump(h, 8, 1.5) + 15*bump(h, 17.5, 1.5) - 6*bump(h, 3.5, 2) + 4*rnd.NormFloat64()
+		agile = append(agile, rate(t, t.Add(30*time.Minute), math.Min(100, 2.2*wholesale+(12*bump(h, 17.5, 1.5)))))
+		outgoing = append(outgoing, rate(t, t.Add(30*time.Minute), math.Max(0, wholesale)))
+	}
+	s.AddRates(elec(ImportAgile), octopus.StandardUnitRates, agile)
+	// An earlier version of Agile was withdrawn when the current one became available.
+	s.AddProduct(octopus.Product{
+		Code:          OldAgile,
+		FullName:      "Agile Octopus November 2022",
+		DisplayName:   "Agile Octopus",
+		Description:   "A synthetic product served by octonaut's fake Octopus API.",
+		IsVariable:    true,
+		Direction:     "IMPORT",
+		Brand:         "OCTOPUS_ENERGY",
+		AvailableFrom: o.From.AddDate(-1, 0, 0),
+		AvailableTo:   &o.From,
+	})
+	s.AddRates(elec(ExportAgile), octopus.StandardUnitRates, outgoing)
+	standing(elec(ImportAgile), 47.8)
+
+	// Flexible prices change with the price cap every quarter, and differ for those not paying by direct debit.
+	// It's also offered as a two register Economy 7 tariff, with cheaper rates for seven hours overnight.
+	product(Variable, "Flexible Octopus", true, false, false, "IMPORT")
+	type unitRate struct {
+		rateType string
+		price    float64
+	}
+	for _, t := range []struct {
+		tariffCode string
+		units      []unitRate
+		standing   float64
+	}{
+		{elec(Variable), []unitRate{{octopus.StandardUnitRates, 24.5}}, 53.4},
+		{gas(Variable), []unitRate{{octopus.StandardUnitRates, 6.1}}, 31.4},
+		{octopus.BuildTariffCode("E", "2R", Variable, Region), []unitRate{{octopus.DayUnitRates, 27.6}, {octopus.NightUnitRates, 13.2}}, 53.4},
+	} {
+		units, sc := make([][]octopus.RateInterval, len(t.units)), []octopus.RateInterval{}
+		for q := o.From; q.Before(o.To); q = q.AddDate(0, 3, 0) {
+			e := q.AddDate(0, 3, 0)
+			if !e.Before(o.To) {
+				e = time.Time{}
+			}
+			change := 1 + 0.05*rnd.NormFloat64()
+			for _, pm := range []struct {
+				method string
+				markup float64
+			}{{octopus.DirectDebit, 1}, {octopus.NonDirectDebit, 1.05}} {
+				for i, u := range t.units {
+					ur := rate(q, e, u.price*change*pm.markup)
+					ur.PaymentMethod = pm.method
+					units[i] = append(units[i], ur)
+				}
+				sr := rate(q, e, t.standing*pm.markup)
+				sr.PaymentMethod = pm.method
+				sc = append(sc, sr)
+			}
+		}
+		for i, u := range t.units {
+			s.AddRates(t.tariffCode, u.rateType, units[i])
+		}
+		s.AddRates(t.tariffCode, octopus.StandingCharges, sc)
+	}
+
+	// Octopus Go has cheap rates overnight.
+	product(Go, "Octopus Go", true, false, false, "IMPORT")
+	goRates := []octopus.RateInterval{}
+	for d := o.From.Truncate(24 * time.Hour); d.Before(o.To); d = d.AddDate(0, 0, 1) {
+		goRates = append(goRates,
+			rate(d, d.Add(30*time.Minute), 27.3),
+			rate(d.Add(30*time.Minute), d.Add(5*time.Hour+30*time.Minute), 8.5),
+			rate(d.Add(5*time.Hour+30*time.Minute), d.AddDate(0, 0, 1), 27.3))
+	}
+	s.AddRates(elec(Go), octopus.StandardUnitRates, goRates)
+	standing(elec(Go), 47.8)
+
+	// Tracker prices change daily, with today's price open-ended.
+	product(Tracker, "Octopus Tracker", true, true, false, "IMPORT")
+	tracker := []octopus.RateInterval{}
+	for d := o.From.Truncate(24 * time.Hour); d.Before(o.To); d = d.AddDate(0, 0, 1) {
+		e := d.AddDate(0, 0, 1)
+		if !e.Before(o.To) {
+			e = time.Time{}
+		}
+		tracker = append(tracker, rate(d, e, 22+3*rnd.NormFloat64()))
+	}
+	s.AddRates(elec(Tracker), octopus.StandardUnitRates, tracker)
+	standing(elec(Tracker), 45.6)
+
+	// Fixed prices are open-ended.
+	product(Fixed, "Co-op Fixed 12M", false, false, false, "IMPORT")
+	s.AddRates(elec(Fixed), octopus.StandardUnitRates, []octopus.RateInterval{rate(o.From, time.Time{}, 23.9)})
+	standing(elec(Fixed), 49.9)
+
+	// A business product, which shouldn't be considered for domestic customers.
+	product(BusinessVar, "Business Flexible", true, false, true, "IMPORT")
+	s.AddRates(elec(BusinessVar), octopus.StandardUnitRates, []octopus.RateInterval{rate(o.From, time.Time{}, 26.1)})
+	standing(elec(BusinessVar), 60.2)
+}
+
+// rate returns a rate interval with domestic VAT applied, which is open-ended if to is the zero time.
+func rate(from, to time.Time, excVAT float64) octopus.RateInterval {
+	excVAT = math.Round(excVAT*10000) / 10000
+	r := octopus.RateInterval{
+		ValidFrom:   from,
+		ValueExcVat: excVAT,
+		ValueIncVat: math.Round(excVAT*1.05*10000) / 10000,
+	}
+	if !to.IsZero() {
+		r.ValidTo = &to
+	}
+	return r
+}
+
+// hour returns the time of day in hours.
+func hour(t time.Time) float64 {
+	return float64(t.Hour()) + float64(t.Minute())/60
+}
+
+// bump returns a smooth peak of height 1 centred on the hour c, with the given width in hours.
+func bump(h, c, width float64) float64 {
+	d := math.Abs(h - c)
+	d = math.Min(d, 24-d)
+	return math.Exp(-(d * d) / (2 * width * width))
+}